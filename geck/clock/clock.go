@@ -0,0 +1,44 @@
+package clock
+
+import (
+	"time"
+)
+
+// Clock source of the current time and of the timers, so that
+//  the scheduling can run on a simulated time in tests
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer fires once on C, unless it's stopped before
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+}
+
+// Real the system clock
+type Real struct {
+}
+
+func (Real) Now() time.Time {
+	return time.Now()
+}
+
+func (Real) NewTimer(d time.Duration) Timer {
+	return &realTimer{time.NewTimer(d)}
+}
+
+type realTimer struct {
+	timer *time.Timer
+}
+
+func (t *realTimer) C() <-chan time.Time {
+	return t.timer.C
+}
+
+func (t *realTimer) Stop() bool {
+	return t.timer.Stop()
+}
+
+var _ Clock = Real{}
@@ -0,0 +1,159 @@
+package clock
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Fake a clock, which only moves when it's advanced. The timers
+//  fire during Advance, in the order of their deadlines
+type Fake struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	now     time.Time
+	timers  []*fakeTimer
+	created uint64
+}
+
+type fakeTimer struct {
+	clock    *Fake
+	deadline time.Time
+	c        chan time.Time
+}
+
+// NewFake a fake clock starting at the given time
+func NewFake(now time.Time) *Fake {
+	f := &Fake{now: now}
+	f.cond = sync.NewCond(&f.mu)
+	return f
+}
+
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.now
+}
+
+func (f *Fake) NewTimer(d time.Duration) Timer {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	t := &fakeTimer{
+		clock:    f,
+		deadline: f.now.Add(d),
+		c:        make(chan time.Time, 1),
+	}
+
+	if d <= 0 {
+		t.c <- f.now
+		return t
+	}
+
+	f.timers = append(f.timers, t)
+	f.created++
+	f.cond.Broadcast()
+
+	return t
+}
+
+// Created number of timers created so far, to find out if a goroutine
+//  got to wait for the next timer. Timers, which fire right away, are
+//  not counted
+func (f *Fake) Created() uint64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.created
+}
+
+// WaitCreated wait until n timers are created in total
+func (f *Fake) WaitCreated(n uint64, timeout time.Duration) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	expired := false
+
+	// Wakes the wait below on the timeout
+	deadline := time.AfterFunc(timeout, func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		expired = true
+		f.cond.Broadcast()
+	})
+	defer deadline.Stop()
+
+	for f.created < n && !expired {
+		f.cond.Wait()
+	}
+
+	if f.created < n {
+		return fmt.Errorf("timed out waiting for %d timers", n)
+	}
+
+	return nil
+}
+
+// NextDeadline the earliest deadline of the active timers
+func (f *Fake) NextDeadline() (time.Time, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var result time.Time
+
+	for _, t := range f.timers {
+		if result.IsZero() || t.deadline.Before(result) {
+			result = t.deadline
+		}
+	}
+
+	return result, !result.IsZero()
+}
+
+// Advance move the clock forward and fire the timers, which are due.
+//  Returns the number of timers fired
+func (f *Fake) Advance(d time.Duration) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.now = f.now.Add(d)
+	fired := 0
+	active := f.timers[:0]
+
+	for _, t := range f.timers {
+		if t.deadline.After(f.now) {
+			active = append(active, t)
+			continue
+		}
+
+		t.c <- f.now
+		fired++
+	}
+
+	f.timers = active
+
+	return fired
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	f := t.clock
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for i, other := range f.timers {
+		if other == t {
+			f.timers = append(f.timers[:i], f.timers[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+var _ Clock = &Fake{}
@@ -0,0 +1,32 @@
+package clock
+
+import (
+	"github.com/stretchr/testify/require"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestFakeWaitCreated(t *testing.T) {
+	f := NewFake(time.Date(2021, 5, 24, 5, 0, 0, 0, time.UTC))
+	goroutines := runtime.NumGoroutine()
+
+	for i := 0; i < 10; i++ {
+		require.Error(t, f.WaitCreated(1, time.Millisecond))
+	}
+
+	// The failed waits are over
+	for i := 0; i < 100 && runtime.NumGoroutine() > goroutines; i++ {
+		time.Sleep(time.Millisecond)
+	}
+
+	require.LessOrEqual(t, runtime.NumGoroutine(), goroutines)
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		f.NewTimer(time.Minute)
+	}()
+
+	require.NoError(t, f.WaitCreated(1, time.Second))
+	require.Equal(t, uint64(1), f.Created())
+}
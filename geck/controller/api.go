@@ -2,11 +2,13 @@ package controller
 
 import (
 	"encoding/json"
+	"fmt"
 	"geck/model"
 	"geck/web"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
 	"regexp"
 	"strconv"
 	"time"
@@ -27,6 +29,84 @@ type Response struct {
 	Zone   []*model.ZoneInfo `json:"zones"`
 }
 
+type HistoryResponse struct {
+	Status  string            `json:"status"`
+	Error   string            `json:"error,omitempty"`
+	History []*model.ZoneRun  `json:"history"`
+	Total   int               `json:"total"`
+	Offset  int               `json:"offset"`
+	Limit   int               `json:"limit"`
+}
+
+type UsageResponse struct {
+	Status  string         `json:"status"`
+	Error   string         `json:"error,omitempty"`
+	Period  string         `json:"period"`
+	Usage   []*UsagePeriod `json:"usage"`
+}
+
+type AlertsResponse struct {
+	Status  string         `json:"status"`
+	Error   string         `json:"error,omitempty"`
+	Alerts  []*model.Alert `json:"alerts"`
+}
+
+type SettingsResponse struct {
+	Status   string               `json:"status"`
+	Error    string               `json:"error,omitempty"`
+	Settings model.GardenSettings `json:"settings"`
+}
+
+type QueueResponse struct {
+	Status string      `json:"status"`
+	Error  string      `json:"error,omitempty"`
+	Lane   string      `json:"lane"`
+	Queue  []QueuedRun `json:"queue"`
+}
+
+type ProgramsResponse struct {
+	Status   string           `json:"status"`
+	Error    string           `json:"error,omitempty"`
+	Programs []*model.Program `json:"programs"`
+}
+
+type BlackoutsResponse struct {
+	Status    string           `json:"status"`
+	Error     string           `json:"error,omitempty"`
+	Blackouts []model.Blackout `json:"blackouts"`
+}
+
+type PauseResponse struct {
+	Status string                  `json:"status"`
+	Error  string                  `json:"error,omitempty"`
+	Pause  *model.Pause            `json:"pause,omitempty"`
+	Lanes  map[string]*model.Pause `json:"lanes"`
+}
+
+type MastersResponse struct {
+	Status  string        `json:"status"`
+	Masters []MasterState `json:"masters"`
+}
+
+type PreviewResponse struct {
+	Status string                  `json:"status"`
+	Error  string                  `json:"error,omitempty"`
+	Start  time.Time               `json:"start"`
+	Days   int                     `json:"days"`
+	Lanes  map[string]*LanePreview `json:"lanes"`
+}
+
+type QueueOrderRequest struct {
+	Order []uint64 `json:"order"`
+}
+
+const (
+	defaultPreviewDays   = 7
+	defaultHistoryPeriod = 7 * 24 * time.Hour
+	defaultHistoryLimit  = 100
+	maxHistoryLimit      = 1000
+)
+
 type GardenAPI struct {
 	*web.HttpService
 	webData    web.Directory
@@ -101,12 +181,453 @@ func (api * GardenAPI) HandleZoneUpdate(context APIContext) error {
 	return nil
 }
 
+func writeJSON(writer http.ResponseWriter, response interface{}) error {
+	data, err := json.Marshal(response)
+	if err != nil {
+		return err
+	}
+
+	writer.Header().Add("Content-Type", "application/json")
+	_, err = writer.Write(data)
+
+	return err
+}
+
+func parseTimeParam(query url.Values, name string, defaultValue time.Time) (time.Time, error) {
+	tStr := query.Get(name)
+	if tStr == "" {
+		return defaultValue, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, tStr)
+	if err != nil {
+		return t, fmt.Errorf("invalid %s time %s: %s", name, tStr, err.Error())
+	}
+
+	return t, nil
+}
+
+func parseIntParam(query url.Values, name string, defaultValue int) (int, error) {
+	iStr := query.Get(name)
+	if iStr == "" {
+		return defaultValue, nil
+	}
+
+	i, err := strconv.Atoi(iStr)
+	if err != nil || i < 0 {
+		return 0, fmt.Errorf("invalid %s value: %s", name, iStr)
+	}
+
+	return i, nil
+}
+
+func (api * GardenAPI) HandleHistory(context APIContext) error {
+	var err error
+	params := context.Request.URL.Query()
+
+	query := HistoryQuery{
+		ZoneId: params.Get("zone"),
+		Lane:   params.Get("lane"),
+	}
+
+	if query.End, err = parseTimeParam(params, "end", api.controller.clock.Now()); err != nil {
+		return err
+	}
+
+	if query.Start, err = parseTimeParam(params, "start", query.End.Add(-defaultHistoryPeriod)); err != nil {
+		return err
+	}
+
+	if query.Offset, err = parseIntParam(params, "offset", 0); err != nil {
+		return err
+	}
+
+	if query.Limit, err = parseIntParam(params, "limit", defaultHistoryLimit); err != nil {
+		return err
+	}
+
+	if query.Limit == 0 || query.Limit > maxHistoryLimit {
+		query.Limit = maxHistoryLimit
+	}
+
+	runs, total, err := api.controller.GetHistory(query)
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(context.Writer, HistoryResponse{
+		Status:  "OK",
+		History: runs,
+		Total:   total,
+		Offset:  query.Offset,
+		Limit:   query.Limit,
+	})
+}
+
+func (api * GardenAPI) HandleUsage(context APIContext) error {
+	params := context.Request.URL.Query()
+
+	period := params.Get("period")
+	if period == "" {
+		period = UsageByDay
+	}
+
+	end, err := parseTimeParam(params, "end", api.controller.clock.Now())
+	if err != nil {
+		return err
+	}
+
+	start, err := parseTimeParam(params, "start", end.Add(-defaultHistoryPeriod))
+	if err != nil {
+		return err
+	}
+
+	usage, err := api.controller.GetWaterUsage(start, end, period)
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(context.Writer, UsageResponse{
+		Status: "OK",
+		Period: period,
+		Usage:  usage,
+	})
+}
+
+func (api * GardenAPI) HandleAlerts(context APIContext) error {
+	params := context.Request.URL.Query()
+
+	end, err := parseTimeParam(params, "end", api.controller.clock.Now())
+	if err != nil {
+		return err
+	}
+
+	start, err := parseTimeParam(params, "start", end.Add(-defaultHistoryPeriod))
+	if err != nil {
+		return err
+	}
+
+	alerts, err := api.controller.GetAlerts(start, end)
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(context.Writer, AlertsResponse{
+		Status: "OK",
+		Alerts: alerts,
+	})
+}
+
+func (api * GardenAPI) HandleSettings(context APIContext) error {
+	if context.Request.Method == http.MethodPost || context.Request.Method == http.MethodPut {
+		var body = context.Request.Body
+		defer body.Close()
+
+		bytes, err := ioutil.ReadAll(body)
+		if err != nil {
+			return err
+		}
+
+		// Only the fields in the request are updated
+		err = api.controller.ModifySettings(func(settings *model.GardenSettings) error {
+			return json.Unmarshal(bytes, settings)
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return writeJSON(context.Writer, SettingsResponse{
+		Status:   "OK",
+		Settings: api.controller.GetSettings(),
+	})
+}
+
+func (api * GardenAPI) HandleQueue(context APIContext) error {
+	if context.PathParts == nil {
+		return fmt.Errorf("bad queue request: %s", context.Request.URL.Path)
+	}
+
+	laneId := context.PathParts[1]
+	action := context.PathParts[2]
+
+	if action != "" {
+		if err := requirePost(context, "queue " + action); err != nil {
+			return err
+		}
+	}
+
+	var queue []QueuedRun
+	var err error
+
+	switch action {
+	case "cancel":
+		id, perr := strconv.ParseUint(context.PathParts[3], 10, 64)
+		if perr != nil {
+			return fmt.Errorf("bad queued run id: %s", context.PathParts[3])
+		}
+
+		queue, err = api.controller.CancelQueuedRun(laneId, id)
+	case "reorder":
+		var body = context.Request.Body
+		defer body.Close()
+
+		bytes, rerr := ioutil.ReadAll(body)
+		if rerr != nil {
+			return rerr
+		}
+
+		order := QueueOrderRequest{}
+
+		if err = json.Unmarshal(bytes, &order); err != nil {
+			return err
+		}
+
+		queue, err = api.controller.ReorderLaneQueue(laneId, order.Order)
+	default:
+		queue, err = api.controller.GetLaneQueue(laneId)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(context.Writer, QueueResponse{
+		Status: "OK",
+		Lane:   laneId,
+		Queue:  queue,
+	})
+}
+
+// HandlePrograms lists the programs, a POST or PUT with a program id
+//  saves the program from the body, /program/<id>/delete removes it
+func (api * GardenAPI) HandlePrograms(context APIContext) error {
+	id := ""
+	action := ""
+
+	if context.PathParts != nil {
+		id = context.PathParts[1]
+		action = context.PathParts[2]
+	}
+
+	method := context.Request.Method
+
+	switch {
+	case id != "" && action == "delete":
+		if err := requirePost(context, "program delete"); err != nil {
+			return err
+		}
+
+		if err := api.controller.DeleteProgram(id); err != nil {
+			return err
+		}
+	case id != "" && (method == http.MethodPost || method == http.MethodPut):
+		var body = context.Request.Body
+		defer body.Close()
+
+		bytes, err := ioutil.ReadAll(body)
+		if err != nil {
+			return err
+		}
+
+		var program model.Program
+
+		if err = json.Unmarshal(bytes, &program); err != nil {
+			return err
+		}
+
+		program.Id = id
+
+		if err = api.controller.SaveProgram(&program); err != nil {
+			return err
+		}
+	}
+
+	programs, err := api.controller.GetPrograms()
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(context.Writer, ProgramsResponse{
+		Status:   "OK",
+		Programs: programs,
+	})
+}
+
+// HandleBlackouts lists the blackout windows, a POST or PUT with an id
+//  saves the window from the body, /blackout/<id>/delete removes it
+func (api * GardenAPI) HandleBlackouts(context APIContext) error {
+	id := ""
+	action := ""
+
+	if context.PathParts != nil {
+		id = context.PathParts[1]
+		action = context.PathParts[2]
+	}
+
+	method := context.Request.Method
+
+	switch {
+	case id != "" && action == "delete":
+		if err := requirePost(context, "blackout delete"); err != nil {
+			return err
+		}
+
+		if err := api.controller.DeleteBlackout(id); err != nil {
+			return err
+		}
+	case id != "" && (method == http.MethodPost || method == http.MethodPut):
+		var body = context.Request.Body
+		defer body.Close()
+
+		bytes, err := ioutil.ReadAll(body)
+		if err != nil {
+			return err
+		}
+
+		var blackout model.Blackout
+
+		if err = json.Unmarshal(bytes, &blackout); err != nil {
+			return err
+		}
+
+		blackout.Id = id
+
+		if err = api.controller.SaveBlackout(&blackout); err != nil {
+			return err
+		}
+	}
+
+	return writeJSON(context.Writer, BlackoutsResponse{
+		Status:    "OK",
+		Blackouts: api.controller.GetBlackouts(),
+	})
+}
+
+func (api * GardenAPI) writePauses(context APIContext) error {
+	pause, lanes := api.controller.GetPauses()
+
+	return writeJSON(context.Writer, PauseResponse{
+		Status: "OK",
+		Pause:  pause,
+		Lanes:  lanes,
+	})
+}
+
+// HandlePause shows the pauses, a POST or PUT pauses all the lanes,
+//  or the lane in the path, until the time in the body
+func (api * GardenAPI) HandlePause(context APIContext) error {
+	method := context.Request.Method
+
+	if method == http.MethodPost || method == http.MethodPut {
+		var body = context.Request.Body
+		defer body.Close()
+
+		bytes, err := ioutil.ReadAll(body)
+		if err != nil {
+			return err
+		}
+
+		var pause model.Pause
+
+		if err = json.Unmarshal(bytes, &pause); err != nil {
+			return err
+		}
+
+		if err = api.controller.SetPause(context.PathParts[1], &pause); err != nil {
+			return err
+		}
+	}
+
+	return api.writePauses(context)
+}
+
+// HandleResume clears the pause of all the lanes, or of the lane in the path
+func (api * GardenAPI) HandleResume(context APIContext) error {
+	if err := api.controller.ClearPause(context.PathParts[1]); err != nil {
+		return err
+	}
+
+	return api.writePauses(context)
+}
+
+// HandleMasters shows the master valves and the lanes holding them
+func (api * GardenAPI) HandleMasters(context APIContext) error {
+	return writeJSON(context.Writer, MastersResponse{
+		Status:  "OK",
+		Masters: api.controller.GetMasters(),
+	})
+}
+
+// HandlePreview projects the runs of the current zones, a POST or PUT
+//  body with a zone previews the proposed change of that zone
+func (api * GardenAPI) HandlePreview(context APIContext) error {
+	days, err := parseIntParam(context.Request.URL.Query(), "days", defaultPreviewDays)
+	if err != nil {
+		return err
+	}
+
+	var proposed *model.ZoneInfoStatic
+	method := context.Request.Method
+
+	if method == http.MethodPost || method == http.MethodPut {
+		var body = context.Request.Body
+		defer body.Close()
+
+		bytes, err := ioutil.ReadAll(body)
+		if err != nil {
+			return err
+		}
+
+		proposed = &model.ZoneInfoStatic{}
+
+		if err = json.Unmarshal(bytes, proposed); err != nil {
+			return err
+		}
+	}
+
+	start := api.controller.clock.Now()
+
+	lanes, err := api.controller.PreviewSchedule(proposed, start, days)
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(context.Writer, PreviewResponse{
+		Status: "OK",
+		Start:  start,
+		Days:   days,
+		Lanes:  lanes,
+	})
+}
+
 type APIContext struct {
 	Writer http.ResponseWriter
 	Request *http.Request
 	PathParts []string
 }
 
+// methodError the action is not made with the method of the request
+type methodError struct {
+	action string
+	method string
+}
+
+func (e *methodError) Error() string {
+	return fmt.Sprintf("%s requires POST: %s", e.action, e.method)
+}
+
+// requirePost the changes are never made by a plain link,
+//  e.g. by a crawler or a prefetch
+func requirePost(context APIContext, action string) error {
+	if context.Request.Method != http.MethodPost {
+		return &methodError{action: action, method: context.Request.Method}
+	}
+
+	return nil
+}
+
 func WrapAPICall(
 	handler func (context APIContext) error,
 	re * regexp.Regexp) func
@@ -120,7 +641,10 @@ func WrapAPICall(
 			PathParts: re.FindStringSubmatch(req.URL.Path),
 		})
 
-		if err != nil {
+		if _, ok := err.(*methodError); ok {
+			writer.Header().Set("Allow", http.MethodPost)
+			http.Error(writer, err.Error(), http.StatusMethodNotAllowed)
+		} else if err != nil {
 			log.Printf("Controller error : %s", err.Error())
 			http.Error(writer, err.Error(), 503)
 		}
@@ -169,6 +693,61 @@ func (api * GardenAPI) PrepareHttp() error {
 			api.HandleZoneUpdate,
 			regexp.MustCompile("/update/([a-zA-Z0-9\\-]+)")))
 
+	api.Mux().HandleFunc("/history/",
+		WrapAPICall(
+			api.HandleHistory,
+			regexp.MustCompile("/history/")))
+
+	api.Mux().HandleFunc("/usage/",
+		WrapAPICall(
+			api.HandleUsage,
+			regexp.MustCompile("/usage/")))
+
+	api.Mux().HandleFunc("/alerts/",
+		WrapAPICall(
+			api.HandleAlerts,
+			regexp.MustCompile("/alerts/")))
+
+	api.Mux().HandleFunc("/settings/",
+		WrapAPICall(
+			api.HandleSettings,
+			regexp.MustCompile("/settings/")))
+
+	api.Mux().HandleFunc("/program/",
+		WrapAPICall(
+			api.HandlePrograms,
+			regexp.MustCompile("/program/([a-zA-Z0-9\\-]+)(?:/(delete))?")))
+
+	api.Mux().HandleFunc("/blackout/",
+		WrapAPICall(
+			api.HandleBlackouts,
+			regexp.MustCompile("/blackout/([a-zA-Z0-9\\-]+)(?:/(delete))?")))
+
+	api.Mux().HandleFunc("/pause/",
+		WrapAPICall(
+			api.HandlePause,
+			regexp.MustCompile("/pause/([a-zA-Z0-9\\-]*)")))
+
+	api.Mux().HandleFunc("/resume/",
+		WrapAPICall(
+			api.HandleResume,
+			regexp.MustCompile("/resume/([a-zA-Z0-9\\-]*)")))
+
+	api.Mux().HandleFunc("/master/",
+		WrapAPICall(
+			api.HandleMasters,
+			regexp.MustCompile("/master/")))
+
+	api.Mux().HandleFunc("/preview/",
+		WrapAPICall(
+			api.HandlePreview,
+			regexp.MustCompile("/preview/")))
+
+	api.Mux().HandleFunc("/queue/",
+		WrapAPICall(
+			api.HandleQueue,
+			regexp.MustCompile("/queue/([a-zA-Z0-9\\-]+)(?:/(cancel|reorder))?(?:/([0-9]+))?")))
+
 	api.Mux().HandleFunc("/stop/",
 		WrapAPICall(
 			api.HandleZoneStop,
@@ -0,0 +1,71 @@
+package controller
+
+import (
+	"fmt"
+	"geck/model"
+	"time"
+)
+
+// blackoutReason returns the skip reason, if the time is within a blackout
+func (gc *GardenController) blackoutReason(t time.Time) string {
+	gc.mutex.Lock()
+	defer gc.mutex.Unlock()
+
+	for _, b := range gc.settings.Blackouts {
+		if !b.Contains(t.In(gc.location)) {
+			continue
+		}
+
+		if b.Reason != "" {
+			return "blackout: " + b.Reason
+		}
+
+		return "blackout: " + b.Id
+	}
+
+	return ""
+}
+
+// GetBlackouts returns the blackout windows
+func (gc *GardenController) GetBlackouts() []model.Blackout {
+	return gc.GetSettings().Blackouts
+}
+
+// SaveBlackout add or replace the blackout window with the same id
+func (gc *GardenController) SaveBlackout(blackout *model.Blackout) error {
+	if err := blackout.Validate(); err != nil {
+		return err
+	}
+
+	return gc.ModifySettings(func(settings *model.GardenSettings) error {
+		for i, b := range settings.Blackouts {
+			if b.Id == blackout.Id {
+				settings.Blackouts[i] = *blackout
+				return nil
+			}
+		}
+
+		settings.Blackouts = append(settings.Blackouts, *blackout)
+		return nil
+	})
+}
+
+// DeleteBlackout remove the blackout window
+func (gc *GardenController) DeleteBlackout(id string) error {
+	return gc.ModifySettings(func(settings *model.GardenSettings) error {
+		blackouts := make([]model.Blackout, 0, len(settings.Blackouts))
+
+		for _, b := range settings.Blackouts {
+			if b.Id != id {
+				blackouts = append(blackouts, b)
+			}
+		}
+
+		if len(blackouts) == len(settings.Blackouts) {
+			return fmt.Errorf("blackout not found: %s", id)
+		}
+
+		settings.Blackouts = blackouts
+		return nil
+	})
+}
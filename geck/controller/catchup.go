@@ -0,0 +1,85 @@
+package controller
+
+import (
+	"fmt"
+	"geck/model"
+	"geck/schedule"
+	"log"
+	"time"
+)
+
+// At most that many missed runs are written to the history at once
+const maxMissedRecords = 50
+
+func validateCatchUp(zone *model.ZoneInfoStatic) error {
+	switch zone.CatchUp {
+	case "", model.CatchUpAlways, model.CatchUpNever:
+		return nil
+	case model.CatchUpWithin:
+		if zone.CatchUpWindow <= 0 {
+			return fmt.Errorf("catch up window must be positive: %s", zone.CatchUpWindow)
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("unknown catch up policy: %s", zone.CatchUp)
+}
+
+// catchUpAllowed whether the run missed at the slot can start at t
+func (zone *ZoneRuntimeState) catchUpAllowed(slot time.Time, t time.Time) bool {
+	switch zone.catchUp {
+	case model.CatchUpNever:
+		return false
+	case model.CatchUpWithin:
+		return t.Sub(slot) <= zone.catchUpWindow
+	}
+
+	return true
+}
+
+// missedSlots the slots after the last run up to the given one, in
+//  the order of time. A zone, which has never run, only missed that one
+func (zone *ZoneRuntimeState) missedSlots(last schedule.TimeSlot) []schedule.TimeSlot {
+	var result []schedule.TimeSlot
+
+	if zone.State.LastRun.IsZero() {
+		return []schedule.TimeSlot{last}
+	}
+
+	for s := last; !s.Time.IsZero() && s.Time.After(zone.State.LastRun); {
+		if len(result) == maxMissedRecords {
+			break
+		}
+
+		result = append([]schedule.TimeSlot{s}, result...)
+		s, _ = zone.sch.GetNearest(s.Time.Add(-time.Second))
+	}
+
+	return result
+}
+
+// recordMissed write the missed runs to the history as skipped. The last
+//  run moves to the latest of them, so that they are not recorded again
+func (lane *Lane) recordMissed(zone *ZoneRuntimeState, missed []schedule.TimeSlot) {
+	if len(missed) == 0 {
+		return
+	}
+
+	log.Printf("Missed runs: zone %s, %d since %s", zone.Id, len(missed), zone.State.LastRun)
+
+	zone.State.LastRun = missed[len(missed) - 1].Time
+
+	for _, slot := range missed {
+		lane.recordSkipped(zone, &ZoneRunData{
+			ZoneRun: ZoneRun{
+				StartTime: slot.Time,
+				Duration:  getDuration(slot.Data),
+				ZoneId:    zone.Id,
+				Due:       slot.Time,
+			},
+			actor: zone.actor,
+			slot:  slot.Time,
+		}, "missed")
+	}
+}
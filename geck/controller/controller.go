@@ -2,10 +2,13 @@ package controller
 
 import (
 	"fmt"
+	"geck/clock"
 	"geck/driver"
 	"geck/model"
+	"geck/weather"
 	"log"
 	"sort"
+	"sync"
 	"sync/atomic"
 	"time"
 	"unsafe"
@@ -19,15 +22,39 @@ type Zone struct {
 
 type GardenController struct {
 	location *time.Location
+	clock    clock.Clock
 
 	lanes map[string]*Lane
 	zones map[string]*Zone
 
 	historyC chan *ZoneRun
 
-	driver    driver.WireDriver
-	actorById map[string]driver.WireActor
+	driver     driver.WireDriver
+	actorById  map[string]driver.WireActor
+	sensorById map[string]driver.PulseSensor
 	storage   model.StorageDriver
+
+	// last zone finished on each flow sensor, to find a stuck valve
+	mutex           sync.Mutex
+	lastRunBySensor map[string]string
+	finishedRuns    uint64
+
+	// serializes the settings changes, from the copy to the save
+	settingsMutex sync.Mutex
+
+	// the zones and lanes are replaced as a whole on reload
+	reloadMutex sync.Mutex
+	zonesMutex  sync.RWMutex
+
+	stopC chan struct{}
+
+	// master valves in use by the lanes
+	masterMutex sync.Mutex
+	masters     map[string]*masterRelay
+
+	rainSkip *weather.RainSkipPolicy
+	weather  weather.Provider
+	settings *model.GardenSettings
 }
 
 func NewGardenController(
@@ -40,12 +67,41 @@ func NewGardenController(
 		driver:    drv,
 		storage:   storageDriver,
 		actorById: make(map[string]driver.WireActor),
+		sensorById: make(map[string]driver.PulseSensor),
 		location:  time.Local,
+		clock:     clock.Real{},
+
+		lastRunBySensor: make(map[string]string),
+		masters:         make(map[string]*masterRelay),
+		settings:        &model.GardenSettings{},
+		stopC:           make(chan struct{}),
 	}
 
 	return gc
 }
 
+// SetClock set the time source of the controller and the lanes, before the startup
+func (gc *GardenController) SetClock(clk clock.Clock) {
+	gc.clock = clk
+}
+
+// SetRainSkip set the policy for skipping scheduled runs after the rain
+func (gc *GardenController) SetRainSkip(policy *weather.RainSkipPolicy) {
+	gc.rainSkip = policy
+}
+
+func (gc *GardenController) skipReason(laneId string, t time.Time) string {
+	if reason := gc.pauseReason(laneId, t); reason != "" {
+		return reason
+	}
+
+	if reason := gc.blackoutReason(t); reason != "" {
+		return reason
+	}
+
+	return gc.rainSkip.SkipReason(t)
+}
+
 // Stop
 func (zone *Zone) Stop() {
 	zone.lane.OobStopC <- ZoneIdType(zone.info.Id)
@@ -60,12 +116,33 @@ func (zone *Zone) Start(d time.Duration, t time.Time) {
 	}
 }
 
+// zoneMap the current zones, the map is not changed after the reload
+func (gc *GardenController) zoneMap() map[string]*Zone {
+	gc.zonesMutex.RLock()
+	defer gc.zonesMutex.RUnlock()
+	return gc.zones
+}
+
+// laneMap the current lanes, the map is not changed after the reload
+func (gc *GardenController) laneMap() map[string]*Lane {
+	gc.zonesMutex.RLock()
+	defer gc.zonesMutex.RUnlock()
+	return gc.lanes
+}
+
 func (gc * GardenController) ReloadZones() error {
+	gc.reloadMutex.Lock()
+	defer gc.reloadMutex.Unlock()
+
 	zones, err := gc.storage.LoadZones()
 	if err != nil {
 		panic(err)
 	}
 
+	if err := gc.loadSettings(); err != nil {
+		return err
+	}
+
 	byLane := make(map[string][]*model.ZoneInfo)
 	actorsCheck := make(map[string]string)
 	newZones := make(map[string]*Zone)
@@ -100,7 +177,7 @@ func (gc * GardenController) ReloadZones() error {
 	newLanes := make(map[string]*Lane)
 	oldLanes := gc.lanes
 
-	for laneId, laneZones := range byLane {
+	for laneId := range byLane {
 		ln, found := oldLanes[laneId]
 
 		if !found {
@@ -111,16 +188,21 @@ func (gc * GardenController) ReloadZones() error {
 		}
 
 		newLanes[ln.Name] = ln
-		ln.ResetZones(laneZones)
 	}
 
 	for _, zone := range newZones {
 		zone.lane = newLanes[zone.info.Lane]
 	}
 
-	// TODO: make atomic
+	gc.zonesMutex.Lock()
 	gc.lanes = newLanes
 	gc.zones = newZones
+	gc.zonesMutex.Unlock()
+
+	// The lanes report the zone states, so the zones are set first
+	for laneId, laneZones := range byLane {
+		newLanes[laneId].ResetZones(laneZones)
+	}
 
 	for laneId, oldLane := range oldLanes {
 		if _, found := newLanes[laneId]; !found {
@@ -128,7 +210,7 @@ func (gc * GardenController) ReloadZones() error {
 		}
 	}
 
-	return nil
+	return gc.reloadPrograms()
 }
 
 func (gc *GardenController) ProcessHistory() {
@@ -137,10 +219,66 @@ func (gc *GardenController) ProcessHistory() {
 			Id:       string(history.ZoneId),
 			Started:  history.StartTime,
 			Duration: history.Duration,
+			Lane:     history.LaneId,
+			Volume:   history.Volume,
+			Measured: history.Measured,
+			Skipped:  history.Skipped,
+			RunId:    history.RunId,
+			Cycle:    history.Cycle,
+
+			Interrupted: history.Interrupted,
+			Program:     history.ProgramId,
 		})
 	}
 }
 
+// HistoryQuery filter and page for the run history lookup
+type HistoryQuery struct {
+	Start  time.Time
+	End    time.Time
+	ZoneId string
+	Lane   string
+	Offset int
+	Limit  int
+}
+
+// GetHistory returns one page of history records matching the query
+//  together with the total number of matching records
+func (gc *GardenController) GetHistory(query HistoryQuery) ([]*model.ZoneRun, int, error) {
+	runs, err := gc.storage.GetHistory(query.Start, query.End)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	result := make([]*model.ZoneRun, 0, len(runs))
+
+	for _, run := range runs {
+		if query.ZoneId != "" && run.Id != query.ZoneId {
+			continue
+		}
+
+		if query.Lane != "" && run.Lane != query.Lane {
+			continue
+		}
+
+		result = append(result, run)
+	}
+
+	total := len(result)
+
+	if query.Offset > total {
+		query.Offset = total
+	}
+
+	result = result[query.Offset:]
+
+	if query.Limit > 0 && query.Limit < len(result) {
+		result = result[:query.Limit]
+	}
+
+	return result, total, nil
+}
+
 // Run - main function for the controller
 func (gc *GardenController) Startup() error {
 	actors := gc.driver.AvailableActors()
@@ -149,12 +287,20 @@ func (gc *GardenController) Startup() error {
 		gc.actorById[actor.GetID()] = actor
 	}
 
+	if pulseDriver, ok := gc.driver.(driver.PulseDriver); ok {
+		for _, sensor := range pulseDriver.AvailableSensors() {
+			gc.sensorById[sensor.GetID()] = sensor
+		}
+	}
+
 	err := gc.ReloadZones()
 	if err != nil {
 		log.Fatalf("Unable to load zones : %s", err.Error())
 	}
 
 	go gc.ProcessHistory()
+	go gc.MonitorFlow()
+	go gc.ExpirePauses()
 
 	return nil
 }
@@ -164,19 +310,23 @@ func (gc * GardenController) StartZone(
 	id string,
 	duration time.Duration,
 	preemptive bool) error {
-	zone, ok := gc.zones[id]
+	zone, ok := gc.zoneMap()[id]
 
 	if !ok {
 		return fmt.Errorf("zone not found : %s", id)
 	}
 
-	zone.Start(duration, time.Now())
+	if err := gc.manualAllowed(zone.info.Lane, gc.clock.Now()); err != nil {
+		return err
+	}
+
+	zone.Start(duration, gc.clock.Now())
 	return nil
 }
 
 // StopZone stop zone
 func (gc * GardenController) StopZone(id string) error {
-	zone, ok := gc.zones[id]
+	zone, ok := gc.zoneMap()[id]
 
 	if !ok {
 		return fmt.Errorf("zone not found : %s", id)
@@ -187,11 +337,22 @@ func (gc * GardenController) StopZone(id string) error {
 }
 
 func (gc *GardenController) ZoneFinish(run ZoneRun) {
+	// Skipped and missed runs never opened the valve
+	if run.Skipped == "" && run.Duration > 0 {
+		if zone, ok := gc.zoneMap()[string(run.ZoneId)]; ok && zone.info.FlowSensor != "" {
+			gc.mutex.Lock()
+			gc.lastRunBySensor[zone.info.FlowSensor] = zone.info.Id
+			gc.mutex.Unlock()
+		}
+
+		atomic.AddUint64(&gc.finishedRuns, 1)
+	}
+
 	gc.historyC <- &run
 }
 
 func (gc *GardenController) UpdateZoneState(id ZoneIdType, state model.ZoneState) {
-	gc.zones[string(id)].state.Set(&state)
+	gc.zoneMap()[string(id)].state.Set(&state)
 
 	if err := gc.storage.UpdateZoneState(string(id), &state); err != nil {
 		log.Printf("Zone save error %s: %s", id, err.Error())
@@ -199,19 +360,20 @@ func (gc *GardenController) UpdateZoneState(id ZoneIdType, state model.ZoneState
 }
 
 func (gc *GardenController) Shutdown() {
-	for _, lane := range gc.lanes {
+	for _, lane := range gc.laneMap() {
 		close(lane.ResetC)
 	}
 
+	close(gc.stopC)
 	close(gc.historyC)
 }
 
 // GetZoneInfo race condition safe get info for a zone
 func (gc *GardenController) GetZoneInfo(zoneId string) []*model.ZoneInfo {
-	zones := gc.zones
+	zones := gc.zoneMap()
 
 	if zoneId != "" {
-		zone, ok := gc.zones[zoneId]
+		zone, ok := zones[zoneId]
 		if !ok {
 			return nil
 		}
@@ -282,7 +444,64 @@ func (gc *GardenController) UpdateZone(zone *model.ZoneInfoStatic, resetEnabled
 			existingZone.Lane = zone.Lane
 		}
 
+		if zone.FlowRate != 0 {
+			existingZone.FlowRate = zone.FlowRate
+		}
+
+		if zone.FlowSensor != "" {
+			existingZone.FlowSensor = zone.FlowSensor
+		}
+
+		if zone.Budget != nil {
+			existingZone.Budget = zone.Budget
+		}
+
+		if zone.Mode != "" {
+			existingZone.Mode = zone.Mode
+		}
+
+		if zone.MaxCycle != 0 {
+			existingZone.MaxCycle = zone.MaxCycle
+		}
+
+		if zone.MinSoak != 0 {
+			existingZone.MinSoak = zone.MinSoak
+		}
+
+		if zone.ET != nil {
+			existingZone.ET = zone.ET
+		}
+
+		if zone.OnPreempt != "" {
+			existingZone.OnPreempt = zone.OnPreempt
+		}
+
+		if zone.CatchUp != "" {
+			existingZone.CatchUp = zone.CatchUp
+		}
+
+		if zone.CatchUpWindow != 0 {
+			existingZone.CatchUpWindow = zone.CatchUpWindow
+		}
+
+		if zone.PulsesPerUnit != 0 {
+			existingZone.PulsesPerUnit = zone.PulsesPerUnit
+		}
+
 		zone = &existingZone.ZoneInfoStatic
+
+		// The merged zone is checked like a new one
+		if err := gc.validateZone(zone); err != nil {
+			return err
+		}
+
+		if resetEnabled && zone.IsEnabled && existingZone.Disabled {
+			// Enabling the zone again clears the fault
+			state := existingZone.ZoneState
+			state.Disabled = false
+			state.Fault = ""
+			gc.UpdateZoneState(ZoneIdType(existingZone.Id), state)
+		}
 	}
 
 	if err := gc.storage.SaveZone(zone); err != nil {
@@ -309,9 +528,189 @@ func (gc *GardenController) validateZone(zone *model.ZoneInfoStatic) error {
 		return fmt.Errorf("hardware element not found: %+v", *zone)
 	}
 
+	if err := gc.validateZoneMaster(zone); err != nil {
+		return err
+	}
+
+	if zone.FlowRate < 0 {
+		return fmt.Errorf("flow rate must not be negative: %+v", *zone)
+	}
+
+	if err := gc.validateFlowSensor(zone); err != nil {
+		return err
+	}
+
+	if err := zone.Budget.Validate(); err != nil {
+		return err
+	}
+
+	if err := validateZoneMode(zone); err != nil {
+		return err
+	}
+
+	if err := validatePreemptPolicy(zone); err != nil {
+		return err
+	}
+
+	if err := validateCatchUp(zone); err != nil {
+		return err
+	}
+
+	for _, sch := range zone.Schedule {
+		if err := sch.Validate(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetSettings returns a copy of the controller wide settings
+func (gc *GardenController) GetSettings() model.GardenSettings {
+	gc.mutex.Lock()
+	defer gc.mutex.Unlock()
+
+	result := *gc.settings
+	result.Budget.Monthly = append([]int(nil), gc.settings.Budget.Monthly...)
+	result.Blackouts = append([]model.Blackout(nil), gc.settings.Blackouts...)
+
+	if gc.settings.Pause != nil {
+		pause := *gc.settings.Pause
+		result.Pause = &pause
+	}
+
+	result.LanePauses = make(map[string]*model.Pause)
+
+	for laneId, p := range gc.settings.LanePauses {
+		pause := *p
+		result.LanePauses[laneId] = &pause
+	}
+
+	if gc.settings.Master != nil {
+		master := *gc.settings.Master
+		result.Master = &master
+	}
+
+	result.LaneMasters = make(map[string]*model.MasterValve)
+
+	for laneId, m := range gc.settings.LaneMasters {
+		master := *m
+		result.LaneMasters[laneId] = &master
+	}
+
+	return result
+}
+
+func (gc *GardenController) loadSettings() error {
+	gc.settingsMutex.Lock()
+	defer gc.settingsMutex.Unlock()
+
+	settings, err := gc.storage.LoadSettings()
+	if err != nil {
+		return err
+	}
+
+	gc.mutex.Lock()
+	gc.settings = settings
+	gc.mutex.Unlock()
+
 	return nil
 }
 
+// saveSettings store the settings, the caller holds the settings mutex
+func (gc *GardenController) saveSettings(settings *model.GardenSettings) error {
+	if err := gc.storage.SaveSettings(settings); err != nil {
+		return err
+	}
+
+	gc.mutex.Lock()
+	gc.settings = settings
+	gc.mutex.Unlock()
+
+	return nil
+}
+
+// changeSettings change a copy of the settings and save it, no other
+//  change is made in between. Nothing is saved if the change fails
+//  or there is nothing to change
+func (gc *GardenController) changeSettings(change func(settings *model.GardenSettings) (bool, error)) error {
+	gc.settingsMutex.Lock()
+	defer gc.settingsMutex.Unlock()
+
+	settings := gc.GetSettings()
+	changed, err := change(&settings)
+
+	if err != nil || !changed {
+		return err
+	}
+
+	return gc.saveSettings(&settings)
+}
+
+func (gc *GardenController) validateSettings(settings *model.GardenSettings) error {
+	if err := settings.Budget.Validate(); err != nil {
+		return err
+	}
+
+	for _, b := range settings.Blackouts {
+		if err := b.Validate(); err != nil {
+			return err
+		}
+	}
+
+	return gc.validateMasters(settings)
+}
+
+// ModifySettings change the controller wide settings and reschedule the lanes
+func (gc *GardenController) ModifySettings(modify func(settings *model.GardenSettings) error) error {
+	err := gc.changeSettings(func(settings *model.GardenSettings) (bool, error) {
+		if err := modify(settings); err != nil {
+			return false, err
+		}
+
+		return true, gc.validateSettings(settings)
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return gc.ReloadZones()
+}
+
+// UpdateSettings save the controller wide settings and reschedule the lanes
+func (gc *GardenController) UpdateSettings(settings *model.GardenSettings) error {
+	return gc.ModifySettings(func(current *model.GardenSettings) error {
+		*current = *settings
+		return nil
+	})
+}
+
+func (gc *GardenController) site() model.SiteLocation {
+	gc.mutex.Lock()
+	defer gc.mutex.Unlock()
+
+	return gc.settings.Site
+}
+
+// budgetPercent the zone budget if it's set, the global budget otherwise
+func (gc *GardenController) budgetPercent(zoneBudget *model.WaterBudget, t time.Time) int {
+	month := t.In(gc.location).Month()
+
+	if percent, ok := zoneBudget.PercentFor(month); ok {
+		return percent
+	}
+
+	gc.mutex.Lock()
+	defer gc.mutex.Unlock()
+
+	if percent, ok := gc.settings.Budget.PercentFor(month); ok {
+		return percent
+	}
+
+	return 100
+}
+
 type ZoneStatePtr struct {
 	state unsafe.Pointer
 }
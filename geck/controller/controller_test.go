@@ -0,0 +1,389 @@
+package controller
+
+import (
+	"encoding/json"
+	"geck/clock"
+	"geck/driver"
+	"geck/model"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryStorage keeps the zones, the settings and the history in memory,
+//  the values are copied in and out like the directory storage does
+type memoryStorage struct {
+	mu       sync.Mutex
+	zones    []*model.ZoneInfo
+	history  []*model.ZoneRun
+	alerts   []*model.Alert
+	settings *model.GardenSettings
+	programs []*model.Program
+}
+
+func copyJson(from interface{}, to interface{}) {
+	data, err := json.Marshal(from)
+
+	if err != nil {
+		panic(err)
+	}
+
+	if err = json.Unmarshal(data, to); err != nil {
+		panic(err)
+	}
+}
+
+func (s *memoryStorage) LoadZones() ([]*model.ZoneInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var result []*model.ZoneInfo
+	copyJson(s.zones, &result)
+	return result, nil
+}
+
+func (s *memoryStorage) SaveZone(zone *model.ZoneInfoStatic) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var static model.ZoneInfoStatic
+	copyJson(zone, &static)
+
+	for _, z := range s.zones {
+		if z.Id == zone.Id {
+			z.ZoneInfoStatic = static
+			return nil
+		}
+	}
+
+	s.zones = append(s.zones, &model.ZoneInfo{ZoneInfoStatic: static})
+	return nil
+}
+
+func (s *memoryStorage) UpdateZoneState(zoneId string, state *model.ZoneState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, z := range s.zones {
+		if z.Id == zoneId {
+			z.ZoneState = *state
+		}
+	}
+
+	return nil
+}
+
+func (s *memoryStorage) GetHistory(start time.Time, end time.Time) ([]*model.ZoneRun, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := []*model.ZoneRun{}
+
+	for _, run := range s.history {
+		if !run.Started.Before(start) && run.Started.Before(end) {
+			r := *run
+			result = append(result, &r)
+		}
+	}
+
+	return result, nil
+}
+
+func (s *memoryStorage) AddHistoryItem(run *model.ZoneRun) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r := *run
+	s.history = append(s.history, &r)
+	return nil
+}
+
+func (s *memoryStorage) GetAlerts(start time.Time, end time.Time) ([]*model.Alert, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := []*model.Alert{}
+
+	for _, alert := range s.alerts {
+		if !alert.Time.Before(start) && alert.Time.Before(end) {
+			a := *alert
+			result = append(result, &a)
+		}
+	}
+
+	return result, nil
+}
+
+func (s *memoryStorage) AddAlert(alert *model.Alert) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a := *alert
+	s.alerts = append(s.alerts, &a)
+	return nil
+}
+
+func (s *memoryStorage) LoadSettings() (*model.GardenSettings, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := &model.GardenSettings{}
+
+	if s.settings != nil {
+		copyJson(s.settings, result)
+	}
+
+	return result, nil
+}
+
+func (s *memoryStorage) SaveSettings(settings *model.GardenSettings) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.settings = &model.GardenSettings{}
+	copyJson(settings, s.settings)
+	return nil
+}
+
+func (s *memoryStorage) LoadPrograms() ([]*model.Program, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var result []*model.Program
+	copyJson(s.programs, &result)
+	return result, nil
+}
+
+func (s *memoryStorage) SaveProgram(program *model.Program) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var p model.Program
+	copyJson(program, &p)
+
+	for i, existing := range s.programs {
+		if existing.Id == p.Id {
+			s.programs[i] = &p
+			return nil
+		}
+	}
+
+	s.programs = append(s.programs, &p)
+	return nil
+}
+
+func (s *memoryStorage) DeleteProgram(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, existing := range s.programs {
+		if existing.Id == id {
+			s.programs = append(s.programs[:i], s.programs[i + 1:]...)
+		}
+	}
+
+	return nil
+}
+
+func (s *memoryStorage) alertsOf(kind string) []*model.Alert {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var result []*model.Alert
+
+	for _, alert := range s.alerts {
+		if alert.Kind == kind {
+			a := *alert
+			result = append(result, &a)
+		}
+	}
+
+	return result
+}
+
+var _ model.StorageDriver = &memoryStorage{}
+
+// controllerFixture the controller over the test driver with gpio0 to gpio7
+//  and flow0, running on a fake clock from laneTestStart
+type controllerFixture struct {
+	t       *testing.T
+	clock   *clock.Fake
+	driver  *driver.TestDriver
+	storage *memoryStorage
+	gc      *GardenController
+}
+
+func newControllerFixture(t *testing.T, zones ...*model.ZoneInfo) *controllerFixture {
+	fake := clock.NewFake(laneTestStart)
+	drv := driver.NewTestDriverWithClock(fake)
+
+	f := startControllerFixture(t, fake, drv, &memoryStorage{zones: zones})
+	f.driver = drv
+	return f
+}
+
+// startControllerFixture the controller over any driver
+func startControllerFixture(
+	t *testing.T,
+	fake *clock.Fake,
+	drv driver.WireDriver,
+	storage *memoryStorage) *controllerFixture {
+	f := &controllerFixture{
+		t:       t,
+		clock:   fake,
+		storage: storage,
+	}
+
+	f.gc = NewGardenController(drv, f.storage)
+	f.gc.SetClock(f.clock)
+	require.NoError(t, f.gc.Startup())
+
+	// The flow and the pause monitors wait for their timers, the lanes
+	//  wait once at the start, after the zones and after the programs
+	require.NoError(t, f.clock.WaitCreated(uint64(2 + 3 * len(f.gc.lanes)), time.Second))
+
+	t.Cleanup(func() {
+		// The history channel stays open, the lanes may still
+		//  be finishing their runs
+		for _, lane := range f.gc.lanes {
+			close(lane.ResetC)
+		}
+
+		close(f.gc.stopC)
+	})
+
+	return f
+}
+
+// advanceTo move the clock to t, one timer deadline at a time. Every fired
+//  timer is followed by the next one of the same goroutine, the lanes and
+//  the monitors, but the outcome may still be on the way
+func (f *controllerFixture) advanceTo(t time.Time) {
+	for {
+		now := f.clock.Now()
+		next, ok := f.clock.NextDeadline()
+
+		if !ok || next.After(t) {
+			next = t
+		}
+
+		n := f.clock.Created()
+
+		if fired := f.clock.Advance(next.Sub(now)); fired > 0 {
+			require.NoError(f.t, f.clock.WaitCreated(n + uint64(fired), time.Second))
+		}
+
+		if !next.Before(t) {
+			return
+		}
+	}
+}
+
+// reload make a change, which reloads the zones, and wait for every lane
+//  to wait again after the zones and after the programs
+func (f *controllerFixture) reload(change func() error) {
+	n := f.clock.Created()
+	require.NoError(f.t, change())
+	require.NoError(f.t, f.clock.WaitCreated(n + uint64(2 * len(f.gc.laneMap())), time.Second))
+}
+
+func (f *controllerFixture) eventually(condition func() bool) {
+	require.Eventually(f.t, condition, time.Second, time.Millisecond)
+}
+
+// testZone a zone on the test driver pin
+func testZone(id string, pin string) *model.ZoneInfo {
+	zone := laneTestZone(id)
+	zone.Name = id
+	zone.HardwareId = pin
+	return zone
+}
+
+func TestUpdateZoneValidation(t *testing.T) {
+	gc := newControllerFixture(t, testZone("a", "gpio0")).gc
+
+	require.Error(t, gc.UpdateZone(&model.ZoneInfoStatic{Id: "b", Name: "B", HardwareId: "gpio1", FlowRate: -1}, false))
+	require.Error(t, gc.UpdateZone(&model.ZoneInfoStatic{Id: "a", FlowRate: -1}, false))
+	require.Error(t, gc.UpdateZone(&model.ZoneInfoStatic{Id: "a", HardwareId: "gpio99"}, false))
+	require.Error(t, gc.UpdateZone(&model.ZoneInfoStatic{Id: "b", Name: "B", HardwareId: "gpio1", OnPreempt: "later"}, false))
+	require.Error(t, gc.UpdateZone(&model.ZoneInfoStatic{Id: "a", OnPreempt: "later"}, false))
+	require.Error(t, gc.UpdateZone(&model.ZoneInfoStatic{Id: "b", Name: "B", HardwareId: "gpio1", CatchUp: model.CatchUpWithin}, false))
+	require.Error(t, gc.UpdateZone(&model.ZoneInfoStatic{Id: "a", CatchUp: "sometimes"}, false))
+
+	// The zones are not on the hardware of the master valves
+	settings := gc.GetSettings()
+	settings.Master = &model.MasterValve{HardwareId: "gpio7"}
+	settings.LaneMasters = map[string]*model.MasterValve{"1": {HardwareId: "gpio6"}}
+	require.NoError(t, gc.UpdateSettings(&settings))
+
+	require.Error(t, gc.UpdateZone(&model.ZoneInfoStatic{Id: "b", Name: "B", HardwareId: "gpio7"}, false))
+	require.Error(t, gc.UpdateZone(&model.ZoneInfoStatic{Id: "a", HardwareId: "gpio7"}, false))
+	require.Error(t, gc.UpdateZone(&model.ZoneInfoStatic{Id: "a", HardwareId: "gpio6"}, false))
+
+	require.NoError(t, gc.UpdateZone(&model.ZoneInfoStatic{Id: "a", FlowRate: 12}, false))
+	require.Equal(t, 12.0, gc.GetZoneInfo("a")[0].FlowRate)
+}
+
+func TestGetWaterUsage(t *testing.T) {
+	zone := testZone("a", "gpio0")
+	zone.FlowRate = 10
+
+	f := newControllerFixture(t, zone, testZone("b", "gpio1"))
+	gc, storage := f.gc, f.storage
+
+	day := laneTestStart.Add(time.Hour)
+
+	history := []*model.ZoneRun{
+		// Nominal volume for the old records, the lane from the zone
+		{ Id: "a", Started: day, Duration: 10 * time.Minute },
+		// Metered runs keep the volume, even without any flow
+		{ Id: "a", Started: day.Add(time.Hour), Duration: 10 * time.Minute, Lane: "0", Measured: true },
+		{ Id: "b", Started: day.Add(2 * time.Hour), Duration: 5 * time.Minute, Lane: "0", Volume: 20, Measured: true },
+		{ Id: "a", Started: day.AddDate(0, 0, 1), Duration: 3 * time.Minute, Lane: "1", Volume: 30 },
+		{ Id: "b", Started: day.AddDate(0, 0, 1), Lane: "0", Skipped: "rain" },
+	}
+
+	for _, run := range history {
+		require.NoError(t, storage.AddHistoryItem(run))
+	}
+
+	usage, err := gc.GetWaterUsage(laneTestStart, laneTestStart.AddDate(0, 0, 7), UsageByDay)
+	require.NoError(t, err)
+	require.Len(t, usage, 2)
+
+	require.Equal(t, 120.0, usage[0].Total.Volume)
+	require.Equal(t, 25 * time.Minute, usage[0].Total.Runtime)
+	require.Equal(t, 100.0, usage[0].Zones["a"].Volume)
+	require.Equal(t, 120.0, usage[0].Lanes["0"].Volume)
+
+	require.Equal(t, 30.0, usage[1].Total.Volume)
+	require.Equal(t, 30.0, usage[1].Lanes["1"].Volume)
+	require.Equal(t, 0.0, usage[1].Zones["b"].Volume)
+
+	usage, err = gc.GetWaterUsage(laneTestStart, laneTestStart.AddDate(0, 0, 7), UsageByWeek)
+	require.NoError(t, err)
+	require.Len(t, usage, 1)
+	require.Equal(t, 150.0, usage[0].Total.Volume)
+	require.Equal(t, laneTestStart.Add(-5 * time.Hour), usage[0].Start)
+
+	_, err = gc.GetWaterUsage(laneTestStart, laneTestStart, "year")
+	require.Error(t, err)
+}
+
+func TestUpdateZoneFinishWithoutAnchor(t *testing.T) {
+	gc := newControllerFixture(t, testZone("a", "gpio0")).gc
+
+	finish := &model.ZoneScheduleSpec{DaysOfWeek: []time.Weekday{time.Monday}, Hours: 6, Finish: true, Duration: time.Minute}
+	require.Error(t, gc.UpdateZone(&model.ZoneInfoStatic{Id: "a", Schedule: []*model.ZoneScheduleSpec{finish}}, false))
+
+	finish.Anchor = "sunrise"
+	require.NoError(t, gc.UpdateZone(&model.ZoneInfoStatic{Id: "a", Schedule: []*model.ZoneScheduleSpec{finish}}, false))
+
+	program := &model.Program{
+		Id:       "p",
+		Schedule: []*model.ZoneScheduleSpec{{ Hours: 6, Finish: true }},
+		Steps:    []*model.ProgramStep{{ ZoneId: "a", Duration: time.Minute }},
+	}
+	require.Error(t, gc.SaveProgram(program))
+}
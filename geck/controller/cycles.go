@@ -0,0 +1,55 @@
+package controller
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// runId identifies the originating run for all of its cycles
+func runId(run *ZoneRun) string {
+	return fmt.Sprintf("%s-%d", run.ZoneId, run.StartTime.Unix())
+}
+
+// splitCycle cuts the run to the zone max cycle and queues the
+//  remainder to start after the soak. Other zones of the lane can run
+//  during the soak, since the remainder is just another pending run
+func (lane *Lane) splitCycle(zone *ZoneRuntimeState, run *ZoneRunData, t time.Time) {
+	if run.RunId == "" {
+		run.RunId = runId(&run.ZoneRun)
+		run.Cycle = 1
+	}
+
+	if zone.maxCycle <= 0 || run.Duration <= zone.maxCycle {
+		return
+	}
+
+	rest := &ZoneRunData{
+		ZoneRun: ZoneRun{
+			StartTime: t.Add(zone.maxCycle + zone.minSoak),
+			Duration:  run.Duration - zone.maxCycle,
+			ZoneId:    run.ZoneId,
+			RunId:     run.RunId,
+			Cycle:     run.Cycle + 1,
+			ProgramId: run.ProgramId,
+		},
+		actor: run.actor,
+	}
+
+	run.Duration = zone.maxCycle
+	lane.enqueue(rest, RunCycle)
+
+	log.Printf("Cycle %d: zone %s, %.2f minutes left after %.2f minutes soak",
+		run.Cycle, zone.Id, rest.Duration.Minutes(), zone.minSoak.Minutes())
+}
+
+// runLength the time from the start to the end of the run, with
+//  the soaks between the cycles
+func (zone *ZoneRuntimeState) runLength(d time.Duration) time.Duration {
+	if zone.maxCycle <= 0 || d <= zone.maxCycle {
+		return d
+	}
+
+	cycles := (d + zone.maxCycle - 1) / zone.maxCycle
+	return d + (cycles - 1) * zone.minSoak
+}
@@ -0,0 +1,160 @@
+package controller
+
+import (
+	"geck/model"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+// cycleZone runs at most 4 minutes with at least 5 minutes of soak
+func cycleZone(id string, schedule ...*model.ZoneScheduleSpec) *model.ZoneInfo {
+	zone := laneTestZone(id, schedule...)
+	zone.MaxCycle = 4 * time.Minute
+	zone.MinSoak = 5 * time.Minute
+	return zone
+}
+
+type expectedRun struct {
+	zone  ZoneIdType
+	start time.Duration
+	d     time.Duration
+	cycle int
+}
+
+// requireRuns check the finished runs, the start is from laneTestStart
+func requireRuns(t *testing.T, expected []expectedRun, runs []ZoneRun) {
+	require.Len(t, runs, len(expected))
+
+	for i, e := range expected {
+		require.Equal(t, e.zone, runs[i].ZoneId, "run %d", i)
+		require.True(t, laneTestStart.Add(e.start).Equal(runs[i].StartTime), "run %d at %s", i, runs[i].StartTime)
+		require.Equal(t, e.d, runs[i].Duration, "run %d", i)
+		require.Equal(t, e.cycle, runs[i].Cycle, "run %d", i)
+	}
+}
+
+func TestLaneCycleSoak(t *testing.T) {
+	f := newLaneFixture(t, []*model.ZoneInfo{
+		cycleZone("roses", mondayMorning),
+		laneTestZone("lawn", &model.ZoneScheduleSpec{
+			DaysOfWeek: []time.Weekday{time.Monday},
+			Hours:      6,
+			Minutes:    2,
+			Duration:   5 * time.Minute,
+		}),
+	})
+
+	f.advanceTo(laneTestStart.Add(2 * time.Hour))
+
+	// The other zone runs during the soak
+	runs := f.finished()
+	requireRuns(t, []expectedRun{
+		{ "roses", time.Hour, 4 * time.Minute, 1 },
+		{ "lawn", time.Hour + 4 * time.Minute, 5 * time.Minute, 1 },
+		{ "roses", time.Hour + 9 * time.Minute, 4 * time.Minute, 2 },
+		{ "roses", time.Hour + 18 * time.Minute, 2 * time.Minute, 3 },
+	}, runs)
+
+	require.NotEmpty(t, runs[0].RunId)
+	require.Equal(t, runs[0].RunId, runs[2].RunId)
+	require.Equal(t, runs[0].RunId, runs[3].RunId)
+	require.NotEqual(t, runs[0].RunId, runs[1].RunId)
+
+	// The next week starts over with the first cycle
+	f.advanceTo(laneTestStart.AddDate(0, 0, 7).Add(2 * time.Hour))
+
+	runs = f.finished()
+	week := 7 * 24 * time.Hour
+	requireRuns(t, []expectedRun{
+		{ "roses", week + time.Hour, 4 * time.Minute, 1 },
+		{ "lawn", week + time.Hour + 4 * time.Minute, 5 * time.Minute, 1 },
+		{ "roses", week + time.Hour + 9 * time.Minute, 4 * time.Minute, 2 },
+		{ "roses", week + time.Hour + 18 * time.Minute, 2 * time.Minute, 3 },
+	}, runs[4:])
+
+	require.NotEqual(t, runs[0].RunId, runs[4].RunId)
+	require.Equal(t, runs[4].RunId, runs[7].RunId)
+}
+
+func TestLaneCyclePreemption(t *testing.T) {
+	f := newLaneFixture(t, []*model.ZoneInfo{
+		cycleZone("roses", mondayMorning),
+		laneTestZone("lawn"),
+	})
+
+	at := laneTestStart.Add(time.Hour + 2 * time.Minute)
+	f.advanceTo(at)
+
+	f.do(func() {
+		f.lane.ScheduleC <- &ZoneRun{
+			StartTime: at,
+			Duration:  3 * time.Minute,
+			ZoneId:    "lawn",
+		}
+	})
+
+	f.advanceTo(laneTestStart.Add(2 * time.Hour))
+
+	// The rest of the first cycle runs after the manual run,
+	//  the next cycles keep their soak
+	runs := f.finished()
+	requireRuns(t, []expectedRun{
+		{ "roses", time.Hour, 2 * time.Minute, 1 },
+		{ "lawn", time.Hour + 2 * time.Minute, 3 * time.Minute, 1 },
+		{ "roses", time.Hour + 5 * time.Minute, 2 * time.Minute, 1 },
+		{ "roses", time.Hour + 9 * time.Minute, 4 * time.Minute, 2 },
+		{ "roses", time.Hour + 18 * time.Minute, 2 * time.Minute, 3 },
+	}, runs)
+
+	require.Equal(t, model.PreemptResume, runs[0].Interrupted)
+
+	var total time.Duration
+
+	for _, run := range runs {
+		if run.ZoneId == "roses" {
+			require.Equal(t, runs[0].RunId, run.RunId)
+			total += run.Duration
+		}
+	}
+
+	require.Equal(t, 10 * time.Minute, total)
+}
+
+func TestLaneFinishBy(t *testing.T) {
+	site := model.SiteLocation{Latitude: 38.72, Longitude: -9.14}
+	sunset := &model.ZoneScheduleSpec{
+		DaysOfWeek: []time.Weekday{time.Monday},
+		Anchor:     "sunset",
+		Finish:     true,
+		Duration:   10 * time.Minute,
+	}
+
+	sch := buildSchedule([]*model.ZoneScheduleSpec{sunset}, "Local", site)
+	_, rt := sch.GetNearest(laneTestStart)
+	finish := rt.Time.Add(10 * time.Minute)
+
+	withSite := func(lane *Lane) {
+		lane.Site = func() model.SiteLocation { return site }
+	}
+
+	// The soaks are part of the run
+	f := newLaneFixture(t, []*model.ZoneInfo{cycleZone("roses", sunset)}, withSite)
+	f.advanceTo(finish.Add(time.Hour))
+
+	runs := f.finished()
+	require.Len(t, runs, 3)
+	require.True(t, finish.Add(-20 * time.Minute).Equal(runs[0].StartTime), "%s", runs[0].StartTime)
+	require.True(t, finish.Equal(runs[2].StartTime.Add(runs[2].Duration)), "%s", runs[2].StartTime)
+
+	// and so is the budget
+	f = newLaneFixture(t, []*model.ZoneInfo{laneTestZone("roses", sunset)}, withSite, func(lane *Lane) {
+		lane.BudgetPercent = func(*model.WaterBudget, time.Time) int { return 150 }
+	})
+	f.advanceTo(finish.Add(time.Hour))
+
+	runs = f.finished()
+	require.Len(t, runs, 1)
+	require.Equal(t, 15 * time.Minute, runs[0].Duration)
+	require.True(t, finish.Add(-15 * time.Minute).Equal(runs[0].StartTime), "%s", runs[0].StartTime)
+}
@@ -0,0 +1,147 @@
+package controller
+
+import (
+	"fmt"
+	"geck/model"
+	"geck/weather"
+	"log"
+	"math"
+	"time"
+)
+
+// How long to wait before asking for the weather again after a failure
+const etRetryInterval = time.Hour
+
+// zoneETSettings returns the et settings if the zone is in the et mode
+func zoneETSettings(zone *model.ZoneInfoStatic) *model.ETSettings {
+	if zone.Mode != model.ModeET || zone.ET == nil {
+		return nil
+	}
+
+	return zone.ET
+}
+
+func validateZoneMode(zone *model.ZoneInfoStatic) error {
+	switch zone.Mode {
+	case "", model.ModeSchedule:
+		return nil
+	case model.ModeET:
+		if zone.ET == nil {
+			return fmt.Errorf("et mode requires et settings: %+v", *zone)
+		}
+
+		return zone.ET.Validate()
+	}
+
+	return fmt.Errorf("unknown zone mode: %s", zone.Mode)
+}
+
+// nextETRun waters at the next allowed window once the
+//  deficit exceeds the allowed depletion, for as long as it
+//  takes to bring the root zone back to field capacity
+func (lane *Lane) nextETRun(zone *ZoneRuntimeState, t time.Time) *ZoneRunData {
+	lane.updateDeficit(zone, t)
+
+	_, rt := zone.sch.GetNearest(t)
+	var result *ZoneRunData
+
+	if rt.Time.Equal(zone.handledSlot) {
+		_, rt = zone.sch.GetNearest(rt.Time.Add(time.Second))
+	}
+
+	if !rt.Time.IsZero() && zone.State.Deficit >= zone.et.ReadilyAvailableWater() {
+		hours := zone.State.Deficit / zone.et.ApplicationRate
+		d := time.Duration(hours * float64(time.Hour)).Truncate(time.Second)
+
+		result = &ZoneRunData{
+			ZoneRun: ZoneRun{
+				StartTime: finishStart(rt.Time, rt.Data, zone.runLength(d), t),
+				Duration:  d,
+				ZoneId:    zone.Id,
+			},
+			actor: zone.actor,
+			slot:  rt.Time,
+		}
+	}
+
+	if result != nil {
+		lane.updateNextRunState(zone, result, result.Duration)
+	} else {
+		lane.updateNextRunState(zone, nil, 0)
+	}
+
+	return result
+}
+
+// updateDeficit adds up the daily water balance for the full days
+//  since the last update. The balance starts at field capacity
+func (lane *Lane) updateDeficit(zone *ZoneRuntimeState, t time.Time) {
+	today := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+
+	if zone.State.DeficitDate.IsZero() {
+		zone.State.DeficitDate = today
+		lane.UpdateZoneState(zone.Id, zone.State)
+		return
+	}
+
+	day := zone.State.DeficitDate.In(t.Location())
+
+	if !day.Before(today) || t.Before(zone.etRetryTime) || lane.DailyWater == nil {
+		return
+	}
+
+	taw := zone.et.TotalAvailableWater()
+	updated := false
+
+	for day.Before(today) {
+		et0, rain, err := lane.DailyWater(day)
+
+		if err == weather.ErrPending {
+			// The later days are fetched meanwhile too, the deficit
+			//  is updated at the next check of the lane
+			for d := day.AddDate(0, 0, 1); d.Before(today); d = d.AddDate(0, 0, 1) {
+				_, _, _ = lane.DailyWater(d)
+			}
+
+			break
+		}
+
+		if err != nil {
+			log.Printf("Cannot update deficit: zone %s, day %s : %s",
+				zone.Id, day.Format("2006-01-02"), err.Error())
+			zone.etRetryTime = t.Add(etRetryInterval)
+			break
+		}
+
+		deficit := zone.State.Deficit + et0 * zone.et.CropCoefficient - rain
+		zone.State.Deficit = math.Max(0, math.Min(taw, deficit))
+		day = day.AddDate(0, 0, 1)
+		updated = true
+	}
+
+	if updated {
+		zone.State.DeficitDate = day
+		lane.UpdateZoneState(zone.Id, zone.State)
+	}
+}
+
+// applyWater reduces the deficit by the water the run has put down
+func (zone *ZoneRuntimeState) applyWater(d time.Duration) {
+	applied := d.Hours() * zone.et.ApplicationRate
+	zone.State.Deficit = math.Max(0, zone.State.Deficit - applied)
+}
+
+// SetWeatherProvider set the weather source for the et mode zones
+func (gc *GardenController) SetWeatherProvider(provider weather.Provider) {
+	gc.weather = provider
+}
+
+func (gc *GardenController) dailyWater(day time.Time) (float64, float64, error) {
+	if gc.weather == nil {
+		return 0, 0, fmt.Errorf("no weather provider")
+	}
+
+	settings := gc.GetSettings()
+
+	return weather.DailyWater(gc.weather, settings.Site.Latitude, day)
+}
@@ -0,0 +1,159 @@
+package controller
+
+import (
+	"fmt"
+	"geck/driver"
+	"geck/model"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// How often flow readings are checked for faults
+	flowCheckInterval = time.Minute
+
+	// Minimum pulses per check interval with an open valve
+	noFlowPulses = 2
+
+	// Pulses per check interval with all valves closed, which mean a leak
+	leakPulses = 10
+)
+
+// flowMeter pulse sensor together with the zone calibration
+type flowMeter struct {
+	sensor        driver.PulseSensor
+	pulsesPerUnit float64
+}
+
+func newFlowMeter(sensor driver.PulseSensor, pulsesPerUnit float64) *flowMeter {
+	if sensor == nil || pulsesPerUnit <= 0 {
+		return nil
+	}
+
+	return &flowMeter{
+		sensor:        sensor,
+		pulsesPerUnit: pulsesPerUnit,
+	}
+}
+
+// volume converts the pulses counted since startPulses to volume units
+func (m *flowMeter) volume(startPulses uint64) float64 {
+	return float64(m.sensor.Pulses() - startPulses) / m.pulsesPerUnit
+}
+
+func (gc *GardenController) validateFlowSensor(zone *model.ZoneInfoStatic) error {
+	if zone.FlowSensor == "" {
+		return nil
+	}
+
+	if _, found := gc.sensorById[zone.FlowSensor]; !found {
+		return fmt.Errorf("flow sensor not found: %+v", *zone)
+	}
+
+	if zone.PulsesPerUnit <= 0 {
+		return fmt.Errorf("flow sensor requires positive pulses per unit: %+v", *zone)
+	}
+
+	return nil
+}
+
+// sensorWatch flow meter reading at the last leak check
+type sensorWatch struct {
+	pulses   uint64
+	finished uint64
+	idle     bool
+	leaking  bool
+}
+
+// MonitorFlow periodically checks all the flow meters for leaks
+func (gc *GardenController) MonitorFlow() {
+	watches := make(map[string]*sensorWatch)
+
+	for {
+		timer := gc.clock.NewTimer(flowCheckInterval)
+
+		select {
+		case <-gc.stopC:
+			timer.Stop()
+			return
+		case t := <-timer.C():
+			gc.checkLeaks(watches, t)
+		}
+	}
+}
+
+// checkLeaks detects flow, while no zone is running. To account for the
+//  water still draining after a valve closes, the check only triggers if
+//  the controller was idle for the whole interval between two checks
+func (gc *GardenController) checkLeaks(watches map[string]*sensorWatch, t time.Time) {
+	idle := true
+
+	for _, zone := range gc.zoneMap() {
+		if zone.state.Get().IsRunning {
+			idle = false
+			break
+		}
+	}
+
+	finished := atomic.LoadUint64(&gc.finishedRuns)
+
+	for id, sensor := range gc.sensorById {
+		pulses := sensor.Pulses()
+		watch, ok := watches[id]
+
+		if !ok {
+			watch = &sensorWatch{}
+			watches[id] = watch
+		} else if idle && watch.idle && watch.finished == finished {
+			leaking := pulses - watch.pulses >= leakPulses
+
+			if leaking && !watch.leaking {
+				gc.reportLeak(id, pulses - watch.pulses, t)
+			}
+
+			watch.leaking = leaking
+		}
+
+		watch.pulses = pulses
+		watch.finished = finished
+		watch.idle = idle
+	}
+}
+
+// reportLeak disables the zone which was the last to run
+//  on the sensor, since it's the most likely stuck valve
+func (gc *GardenController) reportLeak(sensorId string, pulses uint64, t time.Time) {
+	alert := &model.Alert{
+		Time:    t,
+		Kind:    model.AlertLeak,
+		Sensor:  sensorId,
+		Message: fmt.Sprintf("%d pulses while all valves are closed", pulses),
+	}
+
+	gc.mutex.Lock()
+	alert.ZoneId = gc.lastRunBySensor[sensorId]
+	gc.mutex.Unlock()
+
+	if zone, ok := gc.zoneMap()[alert.ZoneId]; ok && zone.lane != nil {
+		zone.lane.FaultC <- alert
+		return
+	}
+
+	gc.RaiseAlert(*alert)
+}
+
+// RaiseAlert log and store the fault event
+func (gc *GardenController) RaiseAlert(alert model.Alert) {
+	log.Printf("Alert %s: zone %s, sensor %s, %s",
+		alert.Kind, alert.ZoneId, alert.Sensor, alert.Message)
+
+	if err := gc.storage.AddAlert(&alert); err != nil {
+		log.Printf("Alert save error: %s", err.Error())
+	}
+}
+
+// GetAlerts returns the fault events in the [start, end) range
+func (gc *GardenController) GetAlerts(start, end time.Time) ([]*model.Alert, error) {
+	return gc.storage.GetAlerts(start, end)
+}
@@ -0,0 +1,120 @@
+package controller
+
+import (
+	"geck/model"
+	"github.com/stretchr/testify/require"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// meteredZone a zone on flow0, which counts 450 pulses per litre
+func meteredZone(id string, pin string, schedule ...*model.ZoneScheduleSpec) *model.ZoneInfo {
+	zone := testZone(id, pin)
+	zone.Schedule = schedule
+	zone.FlowSensor = "flow0"
+	zone.PulsesPerUnit = 450
+	return zone
+}
+
+func TestLeakDisablesLastZone(t *testing.T) {
+	f := newControllerFixture(t,
+		meteredZone("roses", "gpio0", mondayMorning),
+		meteredZone("lawn", "gpio1"))
+
+	sensor, err := f.driver.GetSensor("flow0")
+	require.NoError(t, err)
+
+	// The flow of the run itself is not a leak
+	f.advanceTo(laneTestStart.Add(time.Hour + 20 * time.Minute))
+	require.Empty(t, f.storage.alertsOf(model.AlertLeak))
+
+	f.eventually(func() bool {
+		runs, _ := f.storage.GetHistory(laneTestStart, laneTestStart.Add(2 * time.Hour))
+		return len(runs) == 1 && runs[0].Volume == 10
+	})
+
+	sensor.InjectLeak(1)
+	f.advanceTo(laneTestStart.Add(time.Hour + 25 * time.Minute))
+
+	f.eventually(func() bool { return len(f.storage.alertsOf(model.AlertLeak)) == 1 })
+
+	alert := f.storage.alertsOf(model.AlertLeak)[0]
+	require.Equal(t, "roses", alert.ZoneId)
+	require.Equal(t, "flow0", alert.Sensor)
+
+	f.eventually(func() bool {
+		zone := f.gc.GetZoneInfo("roses")[0]
+		return zone.Disabled && zone.Fault == model.AlertLeak
+	})
+
+	require.False(t, f.gc.GetZoneInfo("lawn")[0].Disabled)
+
+	// Reported once while it keeps leaking
+	f.advanceTo(laneTestStart.Add(time.Hour + 40 * time.Minute))
+	require.Len(t, f.storage.alertsOf(model.AlertLeak), 1)
+}
+
+func TestNoFlowDisablesZone(t *testing.T) {
+	f := newControllerFixture(t, meteredZone("roses", "gpio0", mondayMorning))
+
+	sensor, err := f.driver.GetSensor("flow0")
+	require.NoError(t, err)
+
+	sensor.InjectBlockage(true)
+	f.advanceTo(laneTestStart.Add(time.Hour + 5 * time.Minute))
+
+	f.eventually(func() bool { return len(f.storage.alertsOf(model.AlertNoFlow)) == 1 })
+
+	alert := f.storage.alertsOf(model.AlertNoFlow)[0]
+	require.Equal(t, "roses", alert.ZoneId)
+	require.Equal(t, "flow0", alert.Sensor)
+	require.True(t, laneTestStart.Add(time.Hour + time.Minute).Equal(alert.Time))
+
+	f.eventually(func() bool {
+		zone := f.gc.GetZoneInfo("roses")[0]
+		return zone.Disabled && !zone.IsRunning && zone.Fault == model.AlertNoFlow
+	})
+
+	actor := f.driver.AvailableActors()[1]
+	require.Equal(t, "gpio0", actor.GetID())
+	require.False(t, actor.IsRunning())
+
+	// The zone stays off, until it's enabled again
+	sensor.InjectBlockage(false)
+	f.advanceTo(laneTestStart.AddDate(0, 0, 7).Add(2 * time.Hour))
+	require.False(t, actor.IsRunning())
+
+	runs, err := f.storage.GetHistory(laneTestStart, laneTestStart.AddDate(0, 0, 8))
+	require.NoError(t, err)
+	require.Len(t, runs, 1)
+	require.Equal(t, time.Minute, runs[0].Duration)
+	require.Equal(t, 0.0, runs[0].Volume)
+
+	require.NoError(t, f.gc.UpdateZone(&model.ZoneInfoStatic{Id: "roses", IsEnabled: true}, true))
+
+	f.eventually(func() bool {
+		zone := f.gc.GetZoneInfo("roses")[0]
+		return !zone.Disabled && zone.Fault == ""
+	})
+}
+
+func TestLeakIgnoresSkippedRuns(t *testing.T) {
+	f := newControllerFixture(t,
+		meteredZone("roses", "gpio0"),
+		meteredZone("lawn", "gpio1"))
+
+	at := laneTestStart.Add(time.Hour)
+
+	f.gc.ZoneFinish(ZoneRun{ZoneId: "roses", StartTime: at, Duration: 10 * time.Minute})
+	f.gc.ZoneFinish(ZoneRun{ZoneId: "lawn", StartTime: at, Skipped: "rain"})
+	f.gc.ZoneFinish(ZoneRun{ZoneId: "lawn", StartTime: at, Skipped: "missed"})
+	require.Equal(t, uint64(1), atomic.LoadUint64(&f.gc.finishedRuns))
+
+	f.gc.reportLeak("flow0", 60, at.Add(time.Hour))
+	f.eventually(func() bool { return len(f.storage.alertsOf(model.AlertLeak)) == 1 })
+	require.Equal(t, "roses", f.storage.alertsOf(model.AlertLeak)[0].ZoneId)
+
+	f.eventually(func() bool { return f.gc.GetZoneInfo("roses")[0].Disabled })
+	require.False(t, f.gc.GetZoneInfo("lawn")[0].Disabled)
+}
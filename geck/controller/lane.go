@@ -1,6 +1,9 @@
 package controller
 
 import (
+	"container/heap"
+	"geck/clock"
+	"fmt"
 	"geck/driver"
 	"geck/model"
 	"geck/schedule"
@@ -8,12 +11,37 @@ import (
 	"time"
 )
 
+const (
+	// How long a valve may take to report it's open
+	startConfirmTimeout = 10 * time.Second
+
+	// How often a pending start is checked
+	startCheckInterval = time.Second
+)
+
 type ZoneIdType string
 
 type ZoneRun struct {
 	StartTime time.Time
 	Duration  time.Duration
 	ZoneId    ZoneIdType
+	LaneId    string
+	Volume    float64
+	Measured  bool
+	Skipped   string
+
+	// originating run and the cycle number, if it's split with soaks
+	RunId     string
+	Cycle     int
+
+	// policy applied to the rest of the run, if it was preempted
+	Interrupted string
+
+	// program, which queued the run
+	ProgramId string
+
+	// time the run was due, it starts later if the lane is busy
+	Due time.Time
 }
 
 type ZoneRunData struct {
@@ -22,6 +50,34 @@ type ZoneRunData struct {
 	// We always store the hardware pin of the running zone,
 	// to be able to stop it even if it's deleted
 	actor driver.WireActor
+
+	// queue keys: what started the run, its priority and the
+	//  order of the queueing; index is maintained by the heap
+	kind     string
+	priority int
+	queueId  uint64
+	seq      uint64
+	index    int
+
+	// schedule slot a scheduled run is for
+	slot time.Time
+
+	// skip reason known when the run is queued, checked again once the
+	//  master would go on for it and at the start. The master is not
+	//  switched on for a skipped run
+	skip          string
+	skipRechecked bool
+
+	// the valve reports the start later, it fails if it's not open by then
+	confirmBy time.Time
+
+	// flow meter reading at the start of the run
+	meter       *flowMeter
+	startPulses uint64
+
+	// flow meter reading at the last no flow check
+	flowCheckTime   time.Time
+	flowCheckPulses uint64
 }
 
 type ZoneRuntimeState struct {
@@ -30,20 +86,55 @@ type ZoneRuntimeState struct {
 	State   model.ZoneState
 	enabled bool
 	actor   driver.WireActor
-	weekSch schedule.WeeklySchedule
+	sch     schedule.Schedule
+
+	// nominal flow per minute, to estimate the run volume
+	flowRate float64
+	meter    *flowMeter
+
+	budget *model.WaterBudget
+
+	// cycle and soak
+	maxCycle time.Duration
+	minSoak  time.Duration
+
+	// what happens to the rest of the run, when it's preempted
+	onPreempt string
+
+	// whether and how late a missed run is caught up
+	catchUp       string
+	catchUpWindow time.Duration
+
+	// evapotranspiration mode settings and the last failed deficit update
+	et          *model.ETSettings
+	etRetryTime time.Time
+
+	// last schedule slot, which was started, skipped or cancelled
+	handledSlot time.Time
 }
 
 // Lane
 type Lane struct {
 	Name   string
+	clock  clock.Clock
 	zones  map[ZoneIdType]*ZoneRuntimeState
 	hwPins map[string]driver.WireActor
+	meters map[string]driver.PulseSensor
 
 	// currently running zone
 	runningZone *ZoneRunData
 
-	// cached next run, which we are waiting for
-	nextRun *ZoneRunData
+	// pending runs, the next scheduled run of every zone, manual
+	//  starts, preempted leftovers and the remainders of cycles
+	queue    runQueue
+	queueSeq uint64
+
+	programs map[string]*ProgramRuntimeState
+
+	// master valve held by the lane, since when, and when it goes off
+	masterHeld  string
+	masterSince time.Time
+	masterOffAt time.Time
 
 	OobStopC  chan ZoneIdType
 	ScheduleC chan *ZoneRun
@@ -51,9 +142,43 @@ type Lane struct {
 	// Channel to update the whole zone info
 	ResetC chan []*model.ZoneInfo
 
+	// Channel to disable a zone due to a fault detected outside of the lane
+	FaultC chan *model.Alert
+
+	// Channel to inspect and change the pending runs
+	QueueC chan *queueRequest
+
+	// Channel to update the lane programs
+	ProgramC chan []*model.Program
+
 	// callbacks for upper level
 	OnZoneFinish func(ZoneRun)
 	UpdateZoneState func(ZoneIdType, model.ZoneState)
+	OnAlert func(model.Alert)
+
+	// returns a reason to skip the scheduled run of the lane, if any
+	SkipRun func(string, time.Time) string
+
+	// returns the water budget percent for the zone at the time
+	BudgetPercent func(*model.WaterBudget, time.Time) int
+
+	// returns reference evapotranspiration and precipitation for the day
+	DailyWater func(time.Time) (float64, float64, error)
+
+	// returns the site location for the sun relative schedules
+	Site func() model.SiteLocation
+
+	// returns the master valve of the lane, and holds or releases it
+	Master       func(string) *model.MasterValve
+	SwitchMaster func(string, string, bool)
+}
+
+func (lane *Lane) site() model.SiteLocation {
+	if lane.Site == nil {
+		return model.SiteLocation{}
+	}
+
+	return lane.Site()
 }
 
 func (lane *Lane) Shutdown() {
@@ -65,15 +190,24 @@ func getDuration(data interface{}) time.Duration {
 }
 
 func (lane *Lane) NextZoneRun(zone *ZoneRuntimeState, t time.Time) *ZoneRunData {
+	if zone.et != nil {
+		return lane.nextETRun(zone, t)
+	}
+
 	// We cannot just pass last run here as t because
 	// we want the next run to happen right after the current time
 
-	lt, rt := zone.weekSch.GetNearest(t)
+	lt, rt := zone.sch.GetNearest(t)
 
 	if lt.Time.IsZero() || rt.Time.IsZero() {
 		return nil
 	}
 
+	if rt.Time.Equal(zone.handledSlot) {
+		// The slot is cancelled ahead of time, wait for the one after it
+		_, rt = zone.sch.GetNearest(rt.Time.Add(time.Second))
+	}
+
 	result := &ZoneRunData{
 		ZoneRun: ZoneRun{
 			StartTime: rt.Time,
@@ -81,96 +215,213 @@ func (lane *Lane) NextZoneRun(zone *ZoneRuntimeState, t time.Time) *ZoneRunData
 			ZoneId:    zone.Id,
 		},
 		actor: zone.actor,
+		slot:  rt.Time,
 	}
 
-	if zone.State.LastRun.Before(lt.Time) {
+	if zone.State.LastRun.Before(lt.Time) && !lt.Time.Equal(zone.handledSlot) {
 		// We skipped one or more runs, so schedule one immediately
-		result.StartTime = t
-		result.Duration = getDuration(lt.Data)
+		//  if the policy allows, the rest goes to the history
+		missed := zone.missedSlots(lt)
+
+		if zone.catchUpAllowed(lt.Time, t) {
+			result.StartTime = t
+			result.Duration = getDuration(lt.Data)
+			result.slot = lt.Time
+
+			missed = missed[:len(missed) - 1]
+		}
+
+		lane.recordMissed(zone, missed)
 	}
 
-	if zone.State.NextRun == nil || !zone.State.NextRun.Equal(result.StartTime) {
-		tt := result.StartTime
-		zone.State.NextRun = &tt
-		lane.UpdateZoneState(zone.Id, zone.State)
+	nominal := result.Duration
+	result.Duration = lane.adjustDuration(zone, nominal, result.StartTime)
+
+	if result.slot.Equal(rt.Time) {
+		result.StartTime = finishStart(rt.Time, rt.Data, zone.runLength(result.Duration), t)
 	}
 
+	lane.updateNextRunState(zone, result, nominal)
+
 	return result
 }
 
-func (lane *Lane) nextActionIn(t time.Time) time.Duration {
-	// Next default check
-	timeout := time.Minute
+// updateNextRunState publish the next run in the zone state, if it changed
+func (lane *Lane) updateNextRunState(zone *ZoneRuntimeState, run *ZoneRunData, nominal time.Duration) {
+	if run == nil {
+		if zone.State.NextRun != nil {
+			zone.State.NextRun = nil
+			zone.State.NextRunNominal = 0
+			zone.State.NextRunFor = 0
+			lane.UpdateZoneState(zone.Id, zone.State)
+		}
+
+		return
+	}
+
+	if zone.State.NextRun == nil ||
+		!zone.State.NextRun.Equal(run.StartTime) ||
+		zone.State.NextRunNominal != nominal ||
+		zone.State.NextRunFor != run.Duration {
+		tt := run.StartTime
+		zone.State.NextRun = &tt
+		zone.State.NextRunNominal = nominal
+		zone.State.NextRunFor = run.Duration
+		lane.UpdateZoneState(zone.Id, zone.State)
+	}
+}
+
+// adjustDuration applies the seasonal water budget to the scheduled duration
+func (lane *Lane) adjustDuration(zone *ZoneRuntimeState, d time.Duration, t time.Time) time.Duration {
+	if lane.BudgetPercent == nil || zone.et != nil {
+		return d
+	}
 
+	return d * time.Duration(lane.BudgetPercent(zone.budget, t)) / 100
+}
+
+// nextEventIn the time until the next finish, start or master switch,
+//  false when nothing is due
+func (lane *Lane) nextEventIn(t time.Time) (time.Duration, bool) {
 	if lane.runningZone != nil {
 		// Next finish. Make sure we end that.
 		// We cannot do anything while something is running, so exit
 
-		return lane.runningZone.StartTime.
+		timeout := lane.runningZone.StartTime.
 			Add(lane.runningZone.Duration).
 			Sub(t)
+
+		if !lane.runningZone.confirmBy.IsZero() {
+			// Next start check
+			tt := startCheckInterval
+
+			if tt < timeout {
+				timeout = tt
+			}
+		}
+
+		if lane.runningZone.meter != nil {
+			// Next flow check
+			tt := lane.runningZone.flowCheckTime.Add(flowCheckInterval).Sub(t)
+
+			if tt < timeout {
+				timeout = tt
+			}
+		}
+
+		return timeout, true
 	}
 
-	if lane.nextRun != nil {
+	m := lane.masterValve()
+	next := lane.queue.peek()
+
+	var timeout time.Duration
+	found := false
+
+	if next != nil {
 		// Next start time
-		tt := lane.nextRun.StartTime.Sub(t)
+		timeout = lane.runStartAt(m, next, t).Sub(t)
+		found = true
+	}
 
-		if tt < timeout {
-			timeout = tt
-		}
+	if tt, ok := lane.masterActionIn(m, next, t); ok && (!found || tt < timeout) {
+		timeout = tt
+		found = true
+	}
+
+	return timeout, found
+}
+
+func (lane *Lane) nextActionIn(t time.Time) time.Duration {
+	timeout, ok := lane.nextEventIn(t)
+
+	if lane.runningZone != nil {
+		return timeout
+	}
+
+	// Next default check
+	if !ok || timeout > time.Minute {
+		return time.Minute
 	}
 
 	return timeout
 }
 
 func (lane *Lane) LaneController() {
-	lane.setNext(time.Now())
+	lane.refill(lane.clock.Now())
 
 	for {
-		timeout := lane.nextActionIn(time.Now())
+		timeout := lane.nextActionIn(lane.clock.Now())
 
 		if timeout < 0 {
-			lane.LaneTick(time.Now())
+			lane.LaneTick(lane.clock.Now())
 			continue
 		}
 
+		timer := lane.clock.NewTimer(timeout)
+
 		select {
-		case <-time.After(timeout):
+		case <-timer.C():
 		case x := <-lane.ScheduleC:
-			lane.preempt(x, time.Now())
+			lane.preempt(x, lane.clock.Now())
 		case zone := <-lane.OobStopC:
+			dropped := lane.dropZoneRuns(zone)
+
 			if lane.runningZone != nil && zone == lane.runningZone.ZoneId {
 				log.Printf("Stop zone request: %s", zone)
-				lane.preempt(nil, time.Now())
+				lane.preempt(nil, lane.clock.Now())
+			} else if dropped {
+				log.Printf("Stop zone request: %s, pending runs cancelled", zone)
 			} else {
 				log.Printf("Stop zone request ignored (not running): %s", zone)
 			}
 
+		case alert := <-lane.FaultC:
+			lane.disableZone(alert, lane.clock.Now())
+
+		case req := <-lane.QueueC:
+			lane.handleQueueRequest(req, lane.clock.Now())
+
+		case programs := <-lane.ProgramC:
+			lane.resetPrograms(programs, lane.clock.Now())
+
 		case newZones, ok := <-lane.ResetC:
 			if !ok || newZones == nil {
-				lane.stopZone(time.Now())
+				timer.Stop()
+				lane.stopZone(lane.clock.Now())
+				lane.releaseMaster()
 				return
 			}
 
-			lane.reset(newZones)
+			lane.reset(newZones, lane.clock.Now())
 		}
 
-		lane.LaneTick(time.Now())
+		timer.Stop()
+		lane.LaneTick(lane.clock.Now())
 	}
 }
 
-func (lane *Lane) reset(zones []*model.ZoneInfo) {
+func (lane *Lane) reset(zones []*model.ZoneInfo, t time.Time) {
 	lane.zones = make(map[ZoneIdType]*ZoneRuntimeState)
 
 	for _, zone := range zones {
 		zoneRun := makeZoneRunState(
 			lane.hwPins[zone.HardwareId],
+			lane.meters[zone.FlowSensor],
 			zone)
 
+		zoneRun.UpdateSchedule(zone.Schedule, "Local", lane.site())
 		lane.zones[zoneRun.Id] = zoneRun
 	}
 
-	lane.setNext(time.Now())
+	// Scheduled runs are recalculated from the new schedules,
+	//  other pending runs are kept as long as the zone exists
+	lane.removeRuns(func(run *ZoneRunData) bool {
+		_, ok := lane.zones[run.ZoneId]
+		return run.kind == RunScheduled || !ok
+	})
+
+	lane.refill(t)
 
 	for id, zoneRun := range lane.zones {
 		// update state
@@ -184,36 +435,72 @@ func (lane *Lane) reset(zones []*model.ZoneInfo) {
 	}
 }
 
-func makeZoneRunState(actor driver.WireActor, zoneInfo *model.ZoneInfo) *ZoneRuntimeState {
+func makeZoneRunState(
+	actor driver.WireActor,
+	sensor driver.PulseSensor,
+	zoneInfo *model.ZoneInfo) *ZoneRuntimeState {
 	return &ZoneRuntimeState{
 		Id:      ZoneIdType(zoneInfo.Id),
 		State:   zoneInfo.ZoneState, // copy
 
-		// We only rely on static state for now, so every
-		//  change will reset it && !zoneInfo.Disabled,
-		enabled: zoneInfo.IsEnabled,
+		// Faults keep the zone disabled until it's enabled
+		//  again through the static config
+		enabled: zoneInfo.IsEnabled && !zoneInfo.Disabled,
 		actor:   actor,
-		weekSch: schedule.WeeklySchedule{},
+		sch:     schedule.Schedule{},
+
+		flowRate: zoneInfo.FlowRate,
+		meter:    newFlowMeter(sensor, zoneInfo.PulsesPerUnit),
+
+		budget: zoneInfo.Budget,
+		et:     zoneETSettings(&zoneInfo.ZoneInfoStatic),
+
+		maxCycle: zoneInfo.MaxCycle,
+		minSoak:  zoneInfo.MinSoak,
+
+		onPreempt: zoneInfo.OnPreempt,
+
+		catchUp:       zoneInfo.CatchUp,
+		catchUpWindow: zoneInfo.CatchUpWindow,
 	}
 }
 
-func NewLane(gc *GardenController, name string) *Lane {
+// makeLane a lane with its channels, the callbacks are up to the caller
+func makeLane(name string, clk clock.Clock) *Lane {
 	return &Lane{
 		Name  : name,
-		hwPins : gc.actorById,
+		clock : clk,
 
 		ScheduleC: make(chan *ZoneRun, 64),
 		OobStopC:  make(chan ZoneIdType, 64),
 		ResetC:    make(chan []*model.ZoneInfo, 64),
+		FaultC:    make(chan *model.Alert, 64),
+		QueueC:    make(chan *queueRequest, 64),
+		ProgramC:  make(chan []*model.Program, 64),
 
 		runningZone: nil,
-		nextRun:     nil,
-
-		OnZoneFinish: gc.ZoneFinish,
-		UpdateZoneState: gc.UpdateZoneState,
 	}
 }
 
+func NewLane(gc *GardenController, name string) *Lane {
+	lane := makeLane(name, gc.clock)
+
+	lane.hwPins = gc.actorById
+	lane.meters = gc.sensorById
+
+	lane.OnZoneFinish = gc.ZoneFinish
+	lane.UpdateZoneState = gc.UpdateZoneState
+	lane.OnAlert = gc.RaiseAlert
+	lane.SkipRun = gc.skipReason
+	lane.BudgetPercent = gc.budgetPercent
+	lane.DailyWater = gc.dailyWater
+	lane.Site = gc.site
+	lane.Master = gc.masterValve
+	lane.SwitchMaster = gc.switchMaster
+
+	return lane
+}
+
 // internalStop
 func (lane *Lane) stopZone(stopTime time.Time) {
 	zone := lane.runningZone
@@ -226,11 +513,19 @@ func (lane *Lane) stopZone(stopTime time.Time) {
 
 	run := zone.ZoneRun
 	run.Duration = stopTime.Sub(run.StartTime)
-
-	lane.OnZoneFinish(run)
+	run.LaneId = lane.Name
 
 	zoneData, ok := lane.zones[run.ZoneId]
 
+	if zone.meter != nil {
+		run.Volume = zone.meter.volume(zone.startPulses)
+		run.Measured = true
+	} else if ok {
+		run.Volume = zoneData.flowRate * run.Duration.Minutes()
+	}
+
+	lane.OnZoneFinish(run)
+
 	if !ok {
 		// Zone was removed from the lane
 		return
@@ -239,6 +534,10 @@ func (lane *Lane) stopZone(stopTime time.Time) {
 	zoneData.State.Runtime += run.Duration
 	zoneData.State.IsRunning = false
 
+	if zoneData.et != nil {
+		zoneData.applyWater(run.Duration)
+	}
+
 	log.Printf(
 		"Zone finished: zone %s, run for %.2f minutes",
 		zone.ZoneId,
@@ -256,6 +555,21 @@ func (lane *Lane) startZone(run *ZoneRunData, t time.Time) bool {
 	}
 
 	zone.State.LastRun = t
+	zone.State.SkipReason = ""
+
+	if run.kind == RunScheduled {
+		zone.handledSlot = run.slot
+	}
+
+	lane.splitCycle(zone, run, t)
+
+	if zone.meter != nil {
+		run.meter = zone.meter
+		run.startPulses = zone.meter.sensor.Pulses()
+		run.flowCheckTime = t
+		run.flowCheckPulses = run.startPulses
+	}
+
 	zone.actor.Start()
 	lane.runningZone = run
 
@@ -265,12 +579,27 @@ func (lane *Lane) startZone(run *ZoneRunData, t time.Time) bool {
 		run.Duration.Minutes())
 
 	running := zone.actor.IsRunning()
+
+	if !running && isPending(zone.actor) {
+		// See checkStart
+		run.confirmBy = t.Add(startConfirmTimeout)
+		return true
+	}
+
 	zone.State.IsRunning = running
 
 	if !running {
 		log.Printf("Failed to start: zone %s, disabling", zone.Id)
 		zone.enabled = false
 		zone.State.Disabled = true
+		zone.State.Fault = model.AlertStartFailed
+
+		lane.OnAlert(model.Alert{
+			Time:    t,
+			Kind:    model.AlertStartFailed,
+			ZoneId:  string(zone.Id),
+			Message: "valve did not open",
+		})
 	}
 
 	lane.UpdateZoneState(zone.Id, zone.State)
@@ -279,89 +608,316 @@ func (lane *Lane) startZone(run *ZoneRunData, t time.Time) bool {
 
 }
 
+func isPending(actor driver.WireActor) bool {
+	confirmed, ok := actor.(driver.ConfirmedActor)
+	return ok && confirmed.IsPending()
+}
+
+// checkStart returns false if the valve did not report it's open in time
+func (lane *Lane) checkStart(run *ZoneRunData, t time.Time) bool {
+	if run.confirmBy.IsZero() {
+		return true
+	}
+
+	zone, ok := lane.zones[run.ZoneId]
+
+	if !ok {
+		// Zone was removed from the lane, stopped as usual
+		return true
+	}
+
+	if zone.actor.IsRunning() {
+		run.confirmBy = time.Time{}
+		zone.State.IsRunning = true
+		lane.UpdateZoneState(zone.Id, zone.State)
+		return true
+	}
+
+	return t.Before(run.confirmBy)
+}
+
+func (lane *Lane) skipReason(run *ZoneRunData, t time.Time) string {
+	if (run.kind != RunScheduled && run.kind != RunProgram) || lane.SkipRun == nil {
+		return ""
+	}
+
+	return lane.SkipRun(lane.Name, t)
+}
+
+// skipZone mark the scheduled run as done without watering
+func (lane *Lane) skipZone(run *ZoneRunData, reason string, t time.Time) {
+	zone, ok := lane.zones[run.ZoneId]
+
+	if !ok {
+		return
+	}
+
+	log.Printf("Skipping: zone %s, %s", zone.Id, reason)
+
+	// The slot counts as handled, so that it is not caught up later
+	if run.kind == RunScheduled {
+		zone.State.LastRun = t
+		zone.handledSlot = run.slot
+	}
+
+	lane.recordSkipped(zone, run, reason)
+}
+
+// recordSkipped write the run to the history as skipped
+func (lane *Lane) recordSkipped(zone *ZoneRuntimeState, run *ZoneRunData, reason string) {
+	skipped := run.ZoneRun
+	skipped.Duration = 0
+	skipped.LaneId = lane.Name
+	skipped.Skipped = reason
+	lane.OnZoneFinish(skipped)
+
+	zone.State.SkipReason = reason
+	lane.UpdateZoneState(zone.Id, zone.State)
+}
+
 // Update update schedule
-func (zone *ZoneRuntimeState) UpdateSchedule(specs []model.ZoneScheduleSpec, defaultLocation string) {
-	zone.weekSch = schedule.WeeklySchedule{}
+func (zone *ZoneRuntimeState) UpdateSchedule(
+	specs []*model.ZoneScheduleSpec,
+	defaultLocation string,
+	site model.SiteLocation) {
+	zone.sch = buildSchedule(specs, defaultLocation, site)
+}
+
+func buildSchedule(
+	specs []*model.ZoneScheduleSpec,
+	defaultLocation string,
+	site model.SiteLocation) schedule.Schedule {
+	result := schedule.Schedule{}
 
 	for _, sch := range specs {
 		spec := schedule.Spec{
+			Type:       sch.Type,
 			DaysOfWeek: sch.DaysOfWeek,
+			Interval:   sch.Interval,
+			StartDate:  sch.StartDate,
 			Hours:      sch.Hours,
 			Minutes:    sch.Minutes,
 			AtTimeZone: sch.AtTimeZone,
-			Data:       &sch,
+			Data:       sch,
+
+			Anchor:    sch.Anchor,
+			Offset:    sch.Offset,
+
+			SeasonStart: sch.SeasonStart,
+			SeasonEnd:   sch.SeasonEnd,
+
+			Latitude:  site.Latitude,
+			Longitude: site.Longitude,
+		}
+
+		if sch.Finish {
+			// The slot is the start of the nominal run,
+			//  see finishStart for the actual one
+			spec.Offset -= sch.Duration
+		}
+
+		if spec.Anchor != "" && site == (model.SiteLocation{}) {
+			log.Printf("Cannot build schedule from spec %+v : site location is not set", spec)
+			continue
 		}
 
 		if spec.AtTimeZone == "" {
 			spec.AtTimeZone = defaultLocation
 		}
 
-		err := zone.weekSch.AddSpec(spec)
+		err := result.AddSpec(spec)
 		if err != nil {
 			log.Printf("Cannot build schedule from spec %+v : %s", spec, err.Error())
 		}
 	}
+
+	return result
 }
 
-// preempt add a
+// finishStart the start of the run, which ends at the finish by time of
+//  the spec. The run may be longer or shorter than the nominal one due to
+//  the budget, the et mode and the soaks, but it never starts before t
+func finishStart(slot time.Time, data interface{}, length time.Duration, t time.Time) time.Time {
+	spec, ok := data.(*model.ZoneScheduleSpec)
+
+	if !ok || !spec.Finish {
+		return slot
+	}
+
+	start := slot.Add(spec.Duration - length)
+
+	if start.Before(t) {
+		return t
+	}
+
+	return start
+}
+
+func validatePreemptPolicy(zone *model.ZoneInfoStatic) error {
+	switch zone.OnPreempt {
+	case "", model.PreemptDrop, model.PreemptResume, model.PreemptRestart:
+		return nil
+	}
+
+	return fmt.Errorf("unknown preempt policy: %s", zone.OnPreempt)
+}
+
+// preemptPolicy the zone policy for the rest of the run, resume by default
+func (lane *Lane) preemptPolicy(id ZoneIdType) string {
+	zone, ok := lane.zones[id]
+
+	if !ok {
+		// Zone was removed from the lane
+		return model.PreemptDrop
+	}
+
+	if zone.onPreempt == "" {
+		return model.PreemptResume
+	}
+
+	return zone.onPreempt
+}
+
+// requeueRest queue the rest of the preempted run to start after
+//  the manual run, it keeps the run id and the cycle, so that both
+//  parts can be matched in the history
+func (lane *Lane) requeueRest(active *ZoneRunData, policy string, t time.Time) {
+	var rest time.Duration
+
+	switch policy {
+	case model.PreemptResume:
+		rest = active.StartTime.Add(active.Duration).Sub(t)
+	case model.PreemptRestart:
+		rest = active.Duration
+	}
+
+	if rest <= 0 {
+		return
+	}
+
+	lane.enqueue(&ZoneRunData{
+		ZoneRun: ZoneRun{
+			StartTime: t,
+			Duration:  rest,
+			ZoneId:    active.ZoneId,
+			RunId:     active.RunId,
+			Cycle:     active.Cycle,
+			ProgramId: active.ProgramId,
+		},
+		actor: active.actor,
+	}, RunResume)
+}
+
+// preempt stop the running zone and queue the manual run in front of
+//  the pending ones. The rest of the stopped run is handled according
+//  to the zone policy, unless the same zone is started again
 func (lane *Lane) preempt(run *ZoneRun, t time.Time) {
 	active := lane.runningZone
+	policy := ""
+
+	if active != nil && run != nil && active.ZoneId != run.ZoneId {
+		policy = lane.preemptPolicy(active.ZoneId)
+		active.Interrupted = policy
+	}
 
 	if active != nil {
 		lane.stopZone(t)
-		// TODO: Maybe schedule leftovers of this run for later?
+	}
+
+	if policy != "" {
+		lane.requeueRest(active, policy, t)
 	}
 
 	if run == nil {
-		lane.setNext(t)
 		return
 	}
 
-	run.StartTime = t
 	zone := lane.zones[run.ZoneId]
 
-	if zone != nil {
-		lane.nextRun = &ZoneRunData{
-			ZoneRun: *run,
-			actor:   zone.actor,
-		}
+	if zone == nil {
+		return
 	}
-}
 
-func (lane * Lane) setNext(t time.Time) {
-	lane.nextRun = lane.findNext(t)
+	run.StartTime = t
 
-	if lane.nextRun == nil {
-		return
+	lane.enqueue(&ZoneRunData{
+		ZoneRun: *run,
+		actor:   zone.actor,
+	}, RunManual)
+}
+
+// checkFlow returns false if the running zone had no flow since the last check
+func (lane *Lane) checkFlow(run *ZoneRunData, t time.Time) bool {
+	if run.meter == nil || t.Sub(run.flowCheckTime) < flowCheckInterval {
+		return true
 	}
 
-	log.Printf("Next run: zone %s, at %s for %.2f minutes",
-		lane.nextRun.ZoneId,
-		lane.nextRun.StartTime.Format(time.RFC3339),
-		lane.nextRun.Duration.Minutes())
+	pulses := run.meter.sensor.Pulses()
+	ok := pulses - run.flowCheckPulses >= noFlowPulses
+
+	run.flowCheckTime = t
+	run.flowCheckPulses = pulses
+
+	return ok
 }
 
-func (lane * Lane) findNext(t time.Time) *ZoneRunData {
-	var min *ZoneRunData = nil
+// disableZone stop the zone if it's running and keep it disabled due to the fault
+func (lane *Lane) disableZone(alert *model.Alert, t time.Time) {
+	id := ZoneIdType(alert.ZoneId)
 
-	for _, z := range lane.zones {
-		if !z.enabled || z.State.IsRunning || z.weekSch.Len() == 0 {
-			continue
-		}
+	if lane.runningZone != nil && lane.runningZone.ZoneId == id {
+		lane.stopZone(t)
+	}
 
-		next := lane.NextZoneRun(z, t)
+	log.Printf("Zone fault: zone %s, %s, disabling", id, alert.Message)
+	lane.OnAlert(*alert)
 
-		if next != nil && (min == nil || next.StartTime.Before(min.StartTime)) {
-			min = next
-		}
+	zone, ok := lane.zones[id]
+
+	if !ok {
+		return
 	}
 
-	return min
+	// For a stuck valve, at least try to close it again
+	zone.actor.Stop()
+
+	zone.enabled = false
+	zone.State.Disabled = true
+	zone.State.Fault = alert.Kind
+	lane.UpdateZoneState(zone.Id, zone.State)
+
+	lane.removeRuns(func(run *ZoneRunData) bool {
+		return run.ZoneId == id
+	})
+	lane.updateNextRunState(zone, nil, 0)
 }
 
 func (lane * Lane) LaneTick(t time.Time) bool {
 	active := lane.runningZone
 
+	if active != nil && !lane.checkStart(active, t) {
+		lane.disableZone(&model.Alert{
+			Time:    t,
+			Kind:    model.AlertStartFailed,
+			ZoneId:  string(active.ZoneId),
+			Message: "valve did not report it's open",
+		}, t)
+
+		active = nil
+	}
+
+	if active != nil && !lane.checkFlow(active, t) {
+		lane.disableZone(&model.Alert{
+			Time:    t,
+			Kind:    model.AlertNoFlow,
+			ZoneId:  string(active.ZoneId),
+			Sensor:  active.meter.sensor.GetID(),
+			Message: "no flow while the valve is open",
+		}, t)
+
+		active = nil
+	}
+
 	if active != nil {
 		if t.Sub(active.StartTime) < active.Duration {
 			// Lane is still running
@@ -372,25 +928,39 @@ func (lane * Lane) LaneTick(t time.Time) bool {
 	}
 
 	lane.runningZone = nil
+	lane.refill(t)
 
-	if lane.nextRun == nil {
-		lane.setNext(t)
-	}
+	m := lane.masterValve()
+	next := lane.queue.peek()
 
-	if lane.nextRun == nil {
+	lane.recheckSkip(m, next, t)
+	lane.updateMaster(m, next, t)
+
+	if next == nil {
 		// End lane, nothing to run
 		return false
 	}
 
-	/**
-	 * TODO : replace nextRun with heap, so that the
-	 *  previous next run is not lost when we replace it
-	 */
-	if !lane.nextRun.StartTime.After(t) {
-		next := lane.nextRun
+	if !lane.runStartAt(m, next, t).After(t) {
+		heap.Pop(&lane.queue)
+		next.Due = next.StartTime
 		next.StartTime = t
-		lane.startZone(next, t)
-		lane.setNext(t)
+
+		next.skip = lane.skipReason(next, t)
+
+		if next.skip != "" {
+			lane.skipZone(next, next.skip, t)
+		} else {
+			lane.startZone(next, t)
+		}
+
+		lane.refill(t)
+
+		if lane.runningZone == nil {
+			next = lane.queue.peek()
+			lane.recheckSkip(m, next, t)
+			lane.updateMaster(m, next, t)
+		}
 	}
 
 	return true
@@ -0,0 +1,401 @@
+package controller
+
+import (
+	"geck/clock"
+	"geck/driver"
+	"geck/model"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type laneFixture struct {
+	t     *testing.T
+	clock *clock.Fake
+	lane  *Lane
+
+	mu   sync.Mutex
+	runs []ZoneRun
+}
+
+// Monday
+var laneTestStart = time.Date(2021, 5, 24, 5, 0, 0, 0, time.Local)
+
+func newLaneFixture(t *testing.T, zones []*model.ZoneInfo, options ...func(*Lane)) *laneFixture {
+	f := &laneFixture{
+		t:     t,
+		clock: clock.NewFake(laneTestStart),
+	}
+
+	actors := make(map[string]driver.WireActor)
+
+	for _, zone := range zones {
+		actors[zone.HardwareId] = &previewActor{id: zone.HardwareId}
+	}
+
+	f.lane = makeLane("0", f.clock)
+	f.lane.hwPins = actors
+	f.lane.meters = map[string]driver.PulseSensor{}
+	f.lane.OnZoneFinish = func(run ZoneRun) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		f.runs = append(f.runs, run)
+	}
+	f.lane.UpdateZoneState = func(ZoneIdType, model.ZoneState) {}
+	f.lane.OnAlert = func(model.Alert) {}
+
+	for _, option := range options {
+		option(f.lane)
+	}
+
+	f.lane.reset(zones, laneTestStart)
+
+	f.do(func() {
+		go f.lane.LaneController()
+	})
+
+	t.Cleanup(func() {
+		close(f.lane.ResetC)
+	})
+
+	return f
+}
+
+// do the action and wait for the lane to go to wait for its next timer
+func (f *laneFixture) do(action func()) {
+	n := f.clock.Created()
+	action()
+	require.NoError(f.t, f.clock.WaitCreated(n + 1, time.Second))
+}
+
+// advanceTo move the clock to t, one lane timer at a time
+func (f *laneFixture) advanceTo(t time.Time) {
+	for {
+		now := f.clock.Now()
+		next, ok := f.clock.NextDeadline()
+
+		if !ok || next.After(t) {
+			next = t
+		}
+
+		n := f.clock.Created()
+
+		if f.clock.Advance(next.Sub(now)) > 0 {
+			require.NoError(f.t, f.clock.WaitCreated(n + 1, time.Second))
+		}
+
+		if !next.Before(t) {
+			return
+		}
+	}
+}
+
+// start a manual run of the zone at the current time
+func (f *laneFixture) start(zone ZoneIdType, d time.Duration) {
+	f.do(func() {
+		f.lane.ScheduleC <- &ZoneRun{
+			StartTime: f.clock.Now(),
+			Duration:  d,
+			ZoneId:    zone,
+		}
+	})
+}
+
+// queueRequest pass the request to the lane and wait for the reply
+func (f *laneFixture) queueRequest(req *queueRequest) ([]QueuedRun, error) {
+	req.reply = make(chan queueReply, 1)
+
+	f.do(func() {
+		f.lane.QueueC <- req
+	})
+
+	reply := <-req.reply
+	return reply.runs, reply.err
+}
+
+func (f *laneFixture) finished() []ZoneRun {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]ZoneRun{}, f.runs...)
+}
+
+func laneTestZone(id string, schedule ...*model.ZoneScheduleSpec) *model.ZoneInfo {
+	return &model.ZoneInfo{
+		ZoneInfoStatic: model.ZoneInfoStatic{
+			Id:         id,
+			IsEnabled:  true,
+			HardwareId: "hw-" + id,
+			Lane:       "0",
+			Schedule:   schedule,
+		},
+		ZoneState: model.ZoneState{
+			LastRun: laneTestStart,
+		},
+	}
+}
+
+var mondayMorning = &model.ZoneScheduleSpec{
+	DaysOfWeek: []time.Weekday{time.Monday},
+	Hours:      6,
+	Duration:   10 * time.Minute,
+}
+
+func TestLaneScheduledRun(t *testing.T) {
+	f := newLaneFixture(t, []*model.ZoneInfo{
+		laneTestZone("roses", mondayMorning),
+	})
+
+	f.advanceTo(laneTestStart.Add(time.Hour + 5 * time.Minute))
+	require.Empty(t, f.finished())
+	require.True(t, f.lane.hwPins["hw-roses"].IsRunning())
+
+	f.advanceTo(laneTestStart.Add(2 * time.Hour))
+
+	runs := f.finished()
+	require.Len(t, runs, 1)
+	require.Equal(t, ZoneIdType("roses"), runs[0].ZoneId)
+	require.True(t, laneTestStart.Add(time.Hour).Equal(runs[0].StartTime))
+	require.Equal(t, 10 * time.Minute, runs[0].Duration)
+	require.False(t, f.lane.hwPins["hw-roses"].IsRunning())
+}
+
+func TestLanePreemption(t *testing.T) {
+	f := newLaneFixture(t, []*model.ZoneInfo{
+		laneTestZone("roses", mondayMorning),
+		laneTestZone("lawn"),
+	})
+
+	at := laneTestStart.Add(time.Hour + 4 * time.Minute)
+	f.advanceTo(at)
+
+	f.do(func() {
+		f.lane.ScheduleC <- &ZoneRun{
+			StartTime: at,
+			Duration:  5 * time.Minute,
+			ZoneId:    "lawn",
+		}
+	})
+
+	f.advanceTo(laneTestStart.Add(2 * time.Hour))
+
+	runs := f.finished()
+	require.Len(t, runs, 3)
+
+	require.Equal(t, ZoneIdType("roses"), runs[0].ZoneId)
+	require.Equal(t, 4 * time.Minute, runs[0].Duration)
+	require.Equal(t, model.PreemptResume, runs[0].Interrupted)
+
+	require.Equal(t, ZoneIdType("lawn"), runs[1].ZoneId)
+	require.True(t, at.Equal(runs[1].StartTime))
+	require.Equal(t, 5 * time.Minute, runs[1].Duration)
+
+	require.Equal(t, ZoneIdType("roses"), runs[2].ZoneId)
+	require.True(t, at.Add(5 * time.Minute).Equal(runs[2].StartTime))
+	require.Equal(t, 6 * time.Minute, runs[2].Duration)
+	require.Equal(t, runs[0].RunId, runs[2].RunId)
+	require.Equal(t, "", runs[2].Interrupted)
+}
+
+func TestLaneStopRequest(t *testing.T) {
+	f := newLaneFixture(t, []*model.ZoneInfo{
+		laneTestZone("roses", mondayMorning),
+	})
+
+	f.advanceTo(laneTestStart.Add(time.Hour + 2 * time.Minute))
+
+	f.do(func() {
+		f.lane.OobStopC <- "roses"
+	})
+
+	runs := f.finished()
+	require.Len(t, runs, 1)
+	require.Equal(t, 2 * time.Minute, runs[0].Duration)
+	require.False(t, f.lane.hwPins["hw-roses"].IsRunning())
+
+	// Nothing else until the next week
+	f.advanceTo(laneTestStart.Add(24 * time.Hour))
+	require.Len(t, f.finished(), 1)
+}
+
+func TestLaneMissedRunCatchUp(t *testing.T) {
+	zone := laneTestZone("roses", mondayMorning)
+	zone.LastRun = laneTestStart.AddDate(0, 0, -8)
+
+	f := newLaneFixture(t, []*model.ZoneInfo{zone})
+
+	f.advanceTo(laneTestStart.Add(20 * time.Minute))
+
+	runs := f.finished()
+	require.Len(t, runs, 1)
+	require.True(t, laneTestStart.Equal(runs[0].StartTime))
+	require.Equal(t, 10 * time.Minute, runs[0].Duration)
+
+	// The regular run still happens
+	f.advanceTo(laneTestStart.Add(2 * time.Hour))
+	require.Len(t, f.finished(), 2)
+}
+
+func TestLaneMissedRunOutsideWindow(t *testing.T) {
+	// Missed the runs of the last two Mondays
+	zone := laneTestZone("roses", mondayMorning)
+	zone.LastRun = laneTestStart.AddDate(0, 0, -15)
+	zone.CatchUp = model.CatchUpWithin
+	zone.CatchUpWindow = 12 * time.Hour
+
+	f := newLaneFixture(t, []*model.ZoneInfo{zone})
+
+	runs := f.finished()
+	require.Len(t, runs, 2)
+
+	for i, run := range runs {
+		require.Equal(t, "missed", run.Skipped)
+		require.Equal(t, time.Duration(0), run.Duration)
+		require.True(t, laneTestStart.Add(time.Hour).AddDate(0, 0, 7 * (i - 2)).Equal(run.StartTime))
+	}
+
+	// The regular run still happens
+	f.advanceTo(laneTestStart.Add(2 * time.Hour))
+
+	runs = f.finished()
+	require.Len(t, runs, 3)
+	require.Equal(t, "", runs[2].Skipped)
+	require.Equal(t, 10 * time.Minute, runs[2].Duration)
+}
+
+func TestLaneMissedRunWithinWindow(t *testing.T) {
+	zone := laneTestZone("roses", mondayMorning)
+	zone.LastRun = laneTestStart.AddDate(0, 0, -15)
+	zone.CatchUp = model.CatchUpWithin
+	zone.CatchUpWindow = 8 * 24 * time.Hour
+
+	f := newLaneFixture(t, []*model.ZoneInfo{zone})
+	f.advanceTo(laneTestStart.Add(20 * time.Minute))
+
+	// The older one is recorded, the latest one is caught up
+	runs := f.finished()
+	require.Len(t, runs, 2)
+	require.Equal(t, "missed", runs[0].Skipped)
+	require.Equal(t, "", runs[1].Skipped)
+	require.True(t, laneTestStart.Equal(runs[1].StartTime))
+}
+
+func TestLaneMasterValve(t *testing.T) {
+	type masterSwitch struct {
+		t  time.Time
+		on bool
+	}
+
+	var switches []masterSwitch
+	var f *laneFixture
+
+	f = newLaneFixture(t, []*model.ZoneInfo{
+		laneTestZone("roses", mondayMorning),
+		laneTestZone("lawn", mondayMorning),
+	}, func(lane *Lane) {
+		lane.Master = func(string) *model.MasterValve {
+			return &model.MasterValve{
+				HardwareId: "pump",
+				Lead:       30 * time.Second,
+				Lag:        2 * time.Minute,
+			}
+		}
+		lane.SwitchMaster = func(laneId string, hwId string, on bool) {
+			require.Equal(t, "pump", hwId)
+			switches = append(switches, masterSwitch{f.clock.Now(), on})
+		}
+	})
+
+	f.advanceTo(laneTestStart.Add(2 * time.Hour))
+
+	// Both zones run back to back with the master on all the time
+	runs := f.finished()
+	require.Len(t, runs, 2)
+	require.True(t, laneTestStart.Add(time.Hour).Equal(runs[0].StartTime))
+	require.True(t, laneTestStart.Add(time.Hour + 10 * time.Minute).Equal(runs[1].StartTime))
+
+	require.Len(t, switches, 2)
+	require.True(t, switches[0].on)
+	require.True(t, laneTestStart.Add(time.Hour - 30 * time.Second).Equal(switches[0].t))
+	require.False(t, switches[1].on)
+	require.True(t, laneTestStart.Add(time.Hour + 22 * time.Minute).Equal(switches[1].t))
+}
+
+func TestLaneMasterSkippedRun(t *testing.T) {
+	var switches int
+
+	f := newLaneFixture(t, []*model.ZoneInfo{
+		laneTestZone("roses", mondayMorning),
+		laneTestZone("lawn", mondayAt(7, 0, 5 * time.Minute)),
+	}, func(lane *Lane) {
+		lane.Master = func(string) *model.MasterValve {
+			return &model.MasterValve{HardwareId: "pump", Lead: 30 * time.Second}
+		}
+		lane.SwitchMaster = func(string, string, bool) {
+			switches++
+		}
+		lane.SkipRun = func(laneId string, t time.Time) string {
+			return "rain"
+		}
+	})
+
+	f.advanceTo(laneTestStart.Add(3 * time.Hour))
+
+	runs := f.finished()
+	require.Len(t, runs, 2)
+	require.Equal(t, "rain", runs[0].Skipped)
+	require.Equal(t, "rain", runs[1].Skipped)
+
+	// The master stays off
+	require.Equal(t, 0, switches)
+}
+
+func TestLaneMasterSkipClears(t *testing.T) {
+	type masterSwitch struct {
+		t  time.Time
+		on bool
+	}
+
+	var switches []masterSwitch
+	var f *laneFixture
+	raining := int32(1)
+
+	f = newLaneFixture(t, []*model.ZoneInfo{
+		laneTestZone("roses", mondayMorning),
+	}, func(lane *Lane) {
+		lane.Master = func(string) *model.MasterValve {
+			return &model.MasterValve{HardwareId: "pump", Lead: 30 * time.Second}
+		}
+		lane.SwitchMaster = func(laneId string, hwId string, on bool) {
+			switches = append(switches, masterSwitch{f.clock.Now(), on})
+		}
+		lane.SkipRun = func(laneId string, t time.Time) string {
+			if atomic.LoadInt32(&raining) == 1 {
+				return "rain"
+			}
+
+			return ""
+		}
+	})
+
+	// The run is queued in the rain, which is over before it's due
+	f.advanceTo(laneTestStart.Add(30 * time.Minute))
+	atomic.StoreInt32(&raining, 0)
+
+	f.advanceTo(laneTestStart.Add(2 * time.Hour))
+
+	runs := f.finished()
+	require.Len(t, runs, 1)
+	require.Equal(t, "", runs[0].Skipped)
+	require.True(t, laneTestStart.Add(time.Hour).Equal(runs[0].StartTime))
+	require.Equal(t, 10 * time.Minute, runs[0].Duration)
+
+	// The master is on for the lead time before the run
+	require.Len(t, switches, 2)
+	require.True(t, switches[0].on)
+	require.True(t, laneTestStart.Add(time.Hour - 30 * time.Second).Equal(switches[0].t))
+	require.False(t, switches[1].on)
+}
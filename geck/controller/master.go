@@ -0,0 +1,304 @@
+package controller
+
+import (
+	"fmt"
+	"geck/driver"
+	"geck/model"
+	"log"
+	"sort"
+	"time"
+)
+
+// masterRelay a master valve or a pump relay, which can be shared
+//  by several lanes. It's on as long as any of the lanes holds it
+type masterRelay struct {
+	actor   driver.WireActor
+	holders map[string]bool
+
+	// switched on, the relay may report it later
+	on bool
+}
+
+// MasterState a public representation of a master valve
+type MasterState struct {
+	HardwareId string   `json:"hw_id"`
+	IsRunning  bool     `json:"is_running"`
+	HeldBy     []string `json:"held_by"`
+}
+
+func (gc *GardenController) validateMasters(settings *model.GardenSettings) error {
+	masters := []*model.MasterValve{settings.Master}
+
+	for _, m := range settings.LaneMasters {
+		masters = append(masters, m)
+	}
+
+	for _, m := range masters {
+		if err := m.Validate(); err != nil {
+			return err
+		}
+
+		if m == nil {
+			continue
+		}
+
+		if _, ok := gc.actorById[m.HardwareId]; !ok {
+			return fmt.Errorf("master valve hardware id not found: %s", m.HardwareId)
+		}
+
+		for _, zone := range gc.zoneMap() {
+			if zone.info.HardwareId == m.HardwareId {
+				return fmt.Errorf("master valve is used by zone: %s", zone.info.Id)
+			}
+		}
+	}
+
+	return nil
+}
+
+// validateZoneMaster a zone must not be on the hardware of a master valve,
+//  the master would be switched off under it
+func (gc *GardenController) validateZoneMaster(zone *model.ZoneInfoStatic) error {
+	settings := gc.GetSettings()
+	masters := []*model.MasterValve{settings.Master}
+
+	for _, m := range settings.LaneMasters {
+		masters = append(masters, m)
+	}
+
+	for _, m := range masters {
+		if m != nil && m.HardwareId == zone.HardwareId {
+			return fmt.Errorf("hardware element is a master valve: %+v", *zone)
+		}
+	}
+
+	return nil
+}
+
+// masterValve the master of the lane if it's set, the global one otherwise
+func (gc *GardenController) masterValve(laneId string) *model.MasterValve {
+	gc.mutex.Lock()
+	defer gc.mutex.Unlock()
+
+	m := gc.settings.LaneMasters[laneId]
+
+	if m == nil {
+		m = gc.settings.Master
+	}
+
+	if m == nil {
+		return nil
+	}
+
+	result := *m
+	return &result
+}
+
+// switchMaster hold or release the master relay for the lane
+func (gc *GardenController) switchMaster(laneId string, hwId string, on bool) {
+	gc.masterMutex.Lock()
+	defer gc.masterMutex.Unlock()
+
+	relay, ok := gc.masters[hwId]
+
+	if !ok {
+		actor, found := gc.actorById[hwId]
+
+		if !found {
+			log.Printf("Master valve not found: %s", hwId)
+			return
+		}
+
+		relay = &masterRelay{
+			actor:   actor,
+			holders: make(map[string]bool),
+		}
+
+		gc.masters[hwId] = relay
+	}
+
+	if on {
+		relay.holders[laneId] = true
+	} else {
+		delete(relay.holders, laneId)
+	}
+
+	running := len(relay.holders) > 0
+
+	if running && !relay.on {
+		log.Printf("Master on: %s, lane %s", hwId, laneId)
+		relay.actor.Start()
+		relay.on = true
+	} else if !running {
+		// Also when the relay did not report it's on, it may still be
+		log.Printf("Master off: %s, lane %s", hwId, laneId)
+		relay.actor.Stop()
+		relay.on = false
+	}
+}
+
+// GetMasters the state of the master valves, which are configured or in use
+func (gc *GardenController) GetMasters() []MasterState {
+	settings := gc.GetSettings()
+	ids := make(map[string]bool)
+
+	if settings.Master != nil {
+		ids[settings.Master.HardwareId] = true
+	}
+
+	for _, m := range settings.LaneMasters {
+		ids[m.HardwareId] = true
+	}
+
+	gc.masterMutex.Lock()
+	defer gc.masterMutex.Unlock()
+
+	for hwId := range gc.masters {
+		ids[hwId] = true
+	}
+
+	result := make([]MasterState, 0, len(ids))
+
+	for hwId := range ids {
+		state := MasterState{
+			HardwareId: hwId,
+			HeldBy:     make([]string, 0),
+		}
+
+		if actor, ok := gc.actorById[hwId]; ok {
+			state.IsRunning = actor.IsRunning()
+		}
+
+		if relay, ok := gc.masters[hwId]; ok {
+			for laneId := range relay.holders {
+				state.HeldBy = append(state.HeldBy, laneId)
+			}
+
+			sort.Strings(state.HeldBy)
+		}
+
+		result = append(result, state)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].HardwareId < result[j].HardwareId
+	})
+
+	return result
+}
+
+func (lane *Lane) masterValve() *model.MasterValve {
+	if lane.Master == nil {
+		return nil
+	}
+
+	return lane.Master(lane.Name)
+}
+
+func (lane *Lane) holdMaster(m *model.MasterValve, t time.Time) {
+	lane.masterOffAt = time.Time{}
+
+	if lane.masterHeld == m.HardwareId {
+		return
+	}
+
+	lane.releaseMaster()
+
+	lane.SwitchMaster(lane.Name, m.HardwareId, true)
+	lane.masterHeld = m.HardwareId
+	lane.masterSince = t
+}
+
+func (lane *Lane) releaseMaster() {
+	if lane.masterHeld == "" {
+		return
+	}
+
+	lane.SwitchMaster(lane.Name, lane.masterHeld, false)
+	lane.masterHeld = ""
+	lane.masterOffAt = time.Time{}
+}
+
+// recheckSkip check the skip of the next run again, once the master would
+//  go on for it. The rain, the pauses and the blackouts known when it was
+//  queued may be over, or new ones may have come up
+func (lane *Lane) recheckSkip(m *model.MasterValve, next *ZoneRunData, t time.Time) {
+	if m == nil || next == nil || next.skipRechecked || next.StartTime.Add(-m.Lead).After(t) {
+		return
+	}
+
+	next.skip = lane.skipReason(next, next.StartTime)
+	next.skipRechecked = true
+}
+
+// masterOnAt the time the master goes on for the run, zero if it does not
+func (lane *Lane) masterOnAt(m *model.MasterValve, next *ZoneRunData) time.Time {
+	if m == nil || next == nil || next.skip != "" {
+		return time.Time{}
+	}
+
+	return next.StartTime.Add(-m.Lead)
+}
+
+// runStartAt the time the run can start, once the master is on for the lead time
+func (lane *Lane) runStartAt(m *model.MasterValve, next *ZoneRunData, t time.Time) time.Time {
+	if lane.masterOnAt(m, next).IsZero() {
+		return next.StartTime
+	}
+
+	ready := t.Add(m.Lead)
+
+	if lane.masterHeld == m.HardwareId {
+		ready = lane.masterSince.Add(m.Lead)
+	}
+
+	if ready.After(next.StartTime) {
+		return ready
+	}
+
+	return next.StartTime
+}
+
+// updateMaster switch the master on ahead of the next run, or off
+//  once the lag after the last run has passed
+func (lane *Lane) updateMaster(m *model.MasterValve, next *ZoneRunData, t time.Time) {
+	if m == nil || (lane.masterHeld != "" && lane.masterHeld != m.HardwareId) {
+		// The master is not used anymore, or replaced
+		lane.releaseMaster()
+	}
+
+	if on := lane.masterOnAt(m, next); !on.IsZero() && !on.After(t) {
+		lane.holdMaster(m, t)
+		return
+	}
+
+	if lane.masterHeld == "" {
+		return
+	}
+
+	if lane.masterOffAt.IsZero() {
+		lane.masterOffAt = t.Add(m.Lag)
+	}
+
+	if !lane.masterOffAt.After(t) {
+		lane.releaseMaster()
+	}
+}
+
+// masterActionIn time to the next master switch, when the lane is idle
+func (lane *Lane) masterActionIn(m *model.MasterValve, next *ZoneRunData, t time.Time) (time.Duration, bool) {
+	if lane.masterHeld != "" && !lane.masterOffAt.IsZero() {
+		return lane.masterOffAt.Sub(t), true
+	}
+
+	if m == nil || next == nil || lane.masterHeld != "" {
+		return 0, false
+	}
+
+	// A skipped run is checked again at the time the master would go on
+	if on := next.StartTime.Add(-m.Lead); on.After(t) && (next.skip == "" || !next.skipRechecked) {
+		return on.Sub(t), true
+	}
+
+	return 0, false
+}
@@ -0,0 +1,153 @@
+package controller
+
+import (
+	"fmt"
+	"geck/model"
+	"log"
+	"time"
+)
+
+// How often the expired pauses are cleared from the settings
+const pauseExpireInterval = time.Minute
+
+// activePause the controller wide pause, or the lane pause if there is none
+func (gc *GardenController) activePause(laneId string, t time.Time) *model.Pause {
+	gc.mutex.Lock()
+	defer gc.mutex.Unlock()
+
+	if gc.settings.Pause.Active(t) {
+		return gc.settings.Pause
+	}
+
+	if p := gc.settings.LanePauses[laneId]; p.Active(t) {
+		return p
+	}
+
+	return nil
+}
+
+func (gc *GardenController) pauseReason(laneId string, t time.Time) string {
+	p := gc.activePause(laneId, t)
+
+	if p == nil {
+		return ""
+	}
+
+	return fmt.Sprintf("paused until %s", p.Until.In(gc.location).Format(time.RFC3339))
+}
+
+// manualAllowed returns an error if the pause blocks the manual starts
+func (gc *GardenController) manualAllowed(laneId string, t time.Time) error {
+	if p := gc.activePause(laneId, t); p != nil && p.BlockManual {
+		return fmt.Errorf("watering is paused until %s", p.Until.In(gc.location).Format(time.RFC3339))
+	}
+
+	return nil
+}
+
+// SetPause pause the scheduled runs of the lane, or of all the lanes for an empty lane id
+func (gc *GardenController) SetPause(laneId string, pause *model.Pause) error {
+	if !pause.Active(gc.clock.Now()) {
+		return fmt.Errorf("pause must end in the future: %s", pause.Until.Format(time.RFC3339))
+	}
+
+	if _, ok := gc.laneMap()[laneId]; laneId != "" && !ok {
+		return fmt.Errorf("lane not found : %s", laneId)
+	}
+
+	err := gc.changeSettings(func(settings *model.GardenSettings) (bool, error) {
+		if laneId == "" {
+			settings.Pause = pause
+		} else {
+			settings.LanePauses[laneId] = pause
+		}
+
+		return true, nil
+	})
+
+	if err == nil {
+		log.Printf("Paused: lane %q, until %s", laneId, pause.Until.Format(time.RFC3339))
+	}
+
+	return err
+}
+
+// ClearPause resume the lane, or all the lanes for an empty lane id
+func (gc *GardenController) ClearPause(laneId string) error {
+	err := gc.changeSettings(func(settings *model.GardenSettings) (bool, error) {
+		if laneId == "" {
+			settings.Pause = nil
+		} else {
+			delete(settings.LanePauses, laneId)
+		}
+
+		return true, nil
+	})
+
+	if err == nil {
+		log.Printf("Resumed: lane %q", laneId)
+	}
+
+	return err
+}
+
+// GetPauses returns the active controller wide and lane pauses
+func (gc *GardenController) GetPauses() (*model.Pause, map[string]*model.Pause) {
+	settings := gc.GetSettings()
+	t := gc.clock.Now()
+
+	if !settings.Pause.Active(t) {
+		settings.Pause = nil
+	}
+
+	for laneId, p := range settings.LanePauses {
+		if !p.Active(t) {
+			delete(settings.LanePauses, laneId)
+		}
+	}
+
+	return settings.Pause, settings.LanePauses
+}
+
+// ExpirePauses clear the expired pauses from the stored settings
+func (gc *GardenController) ExpirePauses() {
+	for {
+		timer := gc.clock.NewTimer(pauseExpireInterval)
+
+		select {
+		case <-gc.stopC:
+			timer.Stop()
+			return
+		case t := <-timer.C():
+			gc.expirePauses(t)
+		}
+	}
+}
+
+func (gc *GardenController) expirePauses(t time.Time) {
+	err := gc.changeSettings(func(settings *model.GardenSettings) (bool, error) {
+		expired := false
+
+		if settings.Pause != nil && !settings.Pause.Active(t) {
+			settings.Pause = nil
+			expired = true
+		}
+
+		for laneId, p := range settings.LanePauses {
+			if !p.Active(t) {
+				delete(settings.LanePauses, laneId)
+				expired = true
+			}
+		}
+
+		if expired {
+			log.Printf("Pause expired, resuming")
+		}
+
+		return expired, nil
+	})
+
+	if err != nil {
+		log.Printf("Cannot clear the expired pause : %s", err.Error())
+	}
+}
@@ -0,0 +1,113 @@
+package controller
+
+import (
+	"fmt"
+	"geck/model"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// history the stored runs of the first test week
+func (f *controllerFixture) history() []*model.ZoneRun {
+	runs, err := f.storage.GetHistory(laneTestStart, laneTestStart.AddDate(0, 0, 7))
+	require.NoError(f.t, err)
+	return runs
+}
+
+func TestPauseResumeExpire(t *testing.T) {
+	f := newControllerFixture(t,
+		testZone("roses", "gpio0"),
+		testZone("tulips", "gpio1"))
+
+	require.NoError(t, f.gc.UpdateZone(&model.ZoneInfoStatic{Id: "roses", Schedule: []*model.ZoneScheduleSpec{mondayMorning}}, false))
+	require.NoError(t, f.gc.UpdateZone(&model.ZoneInfoStatic{Id: "tulips", Schedule: []*model.ZoneScheduleSpec{mondayAt(8, 0, 5 * time.Minute)}}, false))
+
+	require.Error(t, f.gc.SetPause("", &model.Pause{Until: laneTestStart}))
+	require.Error(t, f.gc.SetPause("9", &model.Pause{Until: laneTestStart.Add(time.Hour)}))
+	require.NoError(t, f.gc.SetPause("", &model.Pause{Until: laneTestStart.Add(2 * time.Hour)}))
+
+	pause, _ := f.gc.GetPauses()
+	require.NotNil(t, pause)
+
+	// The scheduled run is skipped while paused
+	f.advanceTo(laneTestStart.Add(90 * time.Minute))
+	f.eventually(func() bool { return len(f.history()) == 1 })
+	require.True(t, strings.HasPrefix(f.history()[0].Skipped, "paused until"), f.history()[0].Skipped)
+
+	// and the pause is removed from the settings, once it expires
+	f.advanceTo(laneTestStart.Add(2 * time.Hour + 5 * time.Minute))
+	f.eventually(func() bool {
+		settings, _ := f.storage.LoadSettings()
+		return settings.Pause == nil
+	})
+
+	pause, _ = f.gc.GetPauses()
+	require.Nil(t, pause)
+
+	// The manual runs may be blocked too, until the lane is resumed
+	require.NoError(t, f.gc.SetPause("0", &model.Pause{Until: laneTestStart.AddDate(0, 0, 1), BlockManual: true}))
+	require.Error(t, f.gc.StartZone("tulips", time.Minute, false))
+
+	_, lanes := f.gc.GetPauses()
+	require.Len(t, lanes, 1)
+
+	require.NoError(t, f.gc.ClearPause("0"))
+
+	_, lanes = f.gc.GetPauses()
+	require.Empty(t, lanes)
+
+	f.advanceTo(laneTestStart.Add(3 * time.Hour + 10 * time.Minute))
+	f.eventually(func() bool { return len(f.history()) == 2 })
+
+	run := f.history()[1]
+	require.Equal(t, "tulips", run.Id)
+	require.Equal(t, "", run.Skipped)
+	require.Equal(t, 5 * time.Minute, run.Duration)
+}
+
+func TestPauseConcurrentChanges(t *testing.T) {
+	lawn := testZone("lawn", "gpio1")
+	lawn.Lane = "1"
+
+	f := newControllerFixture(t, testZone("roses", "gpio0"), lawn)
+
+	var wg sync.WaitGroup
+
+	run := func(change func() error) {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			require.NoError(t, change())
+		}()
+	}
+
+	until := laneTestStart.AddDate(0, 0, 1)
+
+	for i := 0; i < 10; i++ {
+		id := fmt.Sprintf("night-%d", i)
+
+		run(func() error { return f.gc.SetPause("", &model.Pause{Until: until}) })
+		run(func() error { return f.gc.SetPause("0", &model.Pause{Until: until}) })
+		run(func() error { return f.gc.SetPause("1", &model.Pause{Until: until}) })
+		run(func() error { return f.gc.ClearPause("1") })
+		run(func() error { return f.gc.SaveBlackout(&model.Blackout{Id: id, From: "23:00", To: "23:30"}) })
+	}
+
+	wg.Wait()
+	require.NoError(t, f.gc.SetPause("1", &model.Pause{Until: until}))
+
+	// None of the changes is lost, the stored settings are the current ones
+	settings, err := f.storage.LoadSettings()
+	require.NoError(t, err)
+	require.Len(t, settings.Blackouts, 10)
+	require.NotNil(t, settings.Pause)
+	require.Len(t, settings.LanePauses, 2)
+
+	current := f.gc.GetSettings()
+	require.Len(t, current.Blackouts, 10)
+	require.Len(t, current.LanePauses, 2)
+}
@@ -0,0 +1,258 @@
+package controller
+
+import (
+	"fmt"
+	"geck/driver"
+	"geck/model"
+	"sort"
+	"time"
+)
+
+const maxPreviewDays = 60
+
+// previewActor a valve, which only exists in the simulation
+type previewActor struct {
+	id      string
+	running bool
+}
+
+func (a *previewActor) GetID() string {
+	return a.id
+}
+
+func (a *previewActor) IsRunning() bool {
+	return a.running
+}
+
+func (a *previewActor) Start() {
+	a.running = true
+}
+
+func (a *previewActor) Stop() {
+	a.running = false
+}
+
+var _ driver.WireActor = &previewActor{}
+
+// PreviewRun a projected run of a zone
+type PreviewRun struct {
+	ZoneId   string        `json:"zone_id"`
+	Program  string        `json:"program,omitempty"`
+	Due      time.Time     `json:"due"`
+	Start    time.Time     `json:"start"`
+	Duration time.Duration `json:"for"`
+	Skipped  string        `json:"skipped,omitempty"`
+}
+
+func (r *PreviewRun) end() time.Time {
+	return r.Start.Add(r.Duration)
+}
+
+// PreviewConflict a run, which waits for another run of the lane
+type PreviewConflict struct {
+	ZoneId string        `json:"zone_id"`
+	Due    time.Time     `json:"due"`
+	Start  time.Time     `json:"start"`
+	Delay  time.Duration `json:"delay"`
+	With   []string      `json:"with"`
+}
+
+// LanePreview the projected timeline of a lane
+type LanePreview struct {
+	Runs      []*PreviewRun      `json:"runs"`
+	Conflicts []*PreviewConflict `json:"conflicts"`
+}
+
+// plannedSkipReason the skip reasons known ahead, the rain is not
+func (gc *GardenController) plannedSkipReason(laneId string, t time.Time) string {
+	if reason := gc.pauseReason(laneId, t); reason != "" {
+		return reason
+	}
+
+	return gc.blackoutReason(t)
+}
+
+// simulateLane runs a copy of the lane with simulated valves from start to end
+func (gc *GardenController) simulateLane(
+	laneId string,
+	zones []*model.ZoneInfo,
+	programs []*model.Program,
+	start, end time.Time) *LanePreview {
+	result := &LanePreview{
+		Runs:      make([]*PreviewRun, 0),
+		Conflicts: make([]*PreviewConflict, 0),
+	}
+
+	actors := make(map[string]driver.WireActor)
+
+	for _, zone := range zones {
+		actors[zone.HardwareId] = &previewActor{id: zone.HardwareId}
+	}
+
+	lane := &Lane{
+		Name:   laneId,
+		clock:  gc.clock,
+		hwPins: actors,
+		meters: map[string]driver.PulseSensor{},
+
+		OnZoneFinish: func(run ZoneRun) {
+			result.Runs = append(result.Runs, &PreviewRun{
+				ZoneId:   string(run.ZoneId),
+				Program:  run.ProgramId,
+				Due:      run.Due,
+				Start:    run.StartTime,
+				Duration: run.Duration,
+				Skipped:  run.Skipped,
+			})
+		},
+		UpdateZoneState: func(ZoneIdType, model.ZoneState) {},
+		OnAlert: func(model.Alert) {},
+		SkipRun: gc.plannedSkipReason,
+		BudgetPercent: gc.budgetPercent,
+		Site: gc.site,
+	}
+
+	lane.reset(zones, start)
+	lane.resetPrograms(programs, start)
+
+	// Nothing changes the lane from outside, so it jumps from one event to the next
+	for t := start; t.Before(end); {
+		lane.LaneTick(t)
+
+		timeout, ok := lane.nextEventIn(t)
+
+		if !ok {
+			break
+		}
+
+		if timeout <= 0 {
+			timeout = time.Second
+		}
+
+		t = t.Add(timeout)
+	}
+
+	if run := lane.runningZone; run != nil {
+		// Runs started within the preview are shown in full
+		lane.stopZone(run.StartTime.Add(run.Duration))
+	}
+
+	result.Conflicts = findConflicts(result.Runs)
+
+	return result
+}
+
+// findConflicts the runs, which start later than due, because other runs
+//  of the lane are in the way. Zones of one program waiting for each other
+//  are not conflicts
+func findConflicts(runs []*PreviewRun) []*PreviewConflict {
+	result := make([]*PreviewConflict, 0)
+
+	for _, run := range runs {
+		if run.Skipped != "" || !run.Start.After(run.Due) {
+			continue
+		}
+
+		var with []string
+
+		for _, other := range runs {
+			if other == run || other.Skipped != "" ||
+				!other.Start.Before(run.Start) || !other.end().After(run.Due) {
+				continue
+			}
+
+			if run.Program != "" && other.Program == run.Program && other.Due.Equal(run.Due) {
+				continue
+			}
+
+			with = append(with, other.ZoneId)
+		}
+
+		if len(with) == 0 {
+			continue
+		}
+
+		result = append(result, &PreviewConflict{
+			ZoneId: run.ZoneId,
+			Due:    run.Due,
+			Start:  run.Start,
+			Delay:  run.Start.Sub(run.Due),
+			With:   with,
+		})
+	}
+
+	return result
+}
+
+// PreviewSchedule projects the runs of every lane over the next days. A proposed
+//  zone replaces the current zone with the same id, or is added as a new one
+func (gc *GardenController) PreviewSchedule(
+	proposed *model.ZoneInfoStatic,
+	start time.Time,
+	days int) (map[string]*LanePreview, error) {
+	if days <= 0 || days > maxPreviewDays {
+		return nil, fmt.Errorf("preview days must be within 1..%d: %d", maxPreviewDays, days)
+	}
+
+	zones := gc.GetZoneInfo("")
+
+	if proposed != nil {
+		if err := proposed.Budget.Validate(); err != nil {
+			return nil, err
+		}
+
+		if err := validateZoneMode(proposed); err != nil {
+			return nil, err
+		}
+
+		found := false
+
+		for _, zone := range zones {
+			if zone.Id == proposed.Id {
+				zone.ZoneInfoStatic = *proposed
+				found = true
+			}
+		}
+
+		if !found {
+			zones = append(zones, &model.ZoneInfo{ZoneInfoStatic: *proposed})
+		}
+	}
+
+	programs, err := gc.storage.LoadPrograms()
+	if err != nil {
+		return nil, err
+	}
+
+	byLane := make(map[string][]*model.ZoneInfo)
+	zoneLane := make(map[string]string)
+
+	for _, zone := range zones {
+		byLane[zone.Lane] = append(byLane[zone.Lane], zone)
+		zoneLane[zone.Id] = zone.Lane
+	}
+
+	programsByLane := make(map[string][]*model.Program)
+
+	for _, p := range programs {
+		if len(p.Steps) > 0 {
+			laneId := zoneLane[p.Steps[0].ZoneId]
+			programsByLane[laneId] = append(programsByLane[laneId], p)
+		}
+	}
+
+	end := start.AddDate(0, 0, days)
+	result := make(map[string]*LanePreview)
+
+	for laneId, laneZones := range byLane {
+		preview := gc.simulateLane(laneId, laneZones, programsByLane[laneId], start, end)
+
+		sort.Slice(preview.Runs, func(i, j int) bool {
+			return preview.Runs[i].Start.Before(preview.Runs[j].Start)
+		})
+
+		result[laneId] = preview
+	}
+
+	return result, nil
+}
@@ -0,0 +1,102 @@
+package controller
+
+import (
+	"geck/model"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestPreviewMatchesLane(t *testing.T) {
+	roses := testZone("roses", "gpio0")
+	roses.Schedule = []*model.ZoneScheduleSpec{mondayMorning}
+	roses.MaxCycle = 4 * time.Minute
+	roses.MinSoak = 5 * time.Minute
+
+	tulips := testZone("tulips", "gpio1")
+	tulips.Schedule = []*model.ZoneScheduleSpec{mondayAt(6, 5, 5 * time.Minute)}
+
+	f := newControllerFixture(t, roses, tulips)
+	f.reload(func() error {
+		return f.gc.SaveBlackout(&model.Blackout{Id: "noon", From: "12:00", To: "13:00"})
+	})
+
+	lawn := &model.ZoneInfoStatic{
+		Id:         "lawn",
+		Name:       "lawn",
+		HardwareId: "gpio2",
+		Lane:       "0",
+		IsEnabled:  true,
+		Schedule:   []*model.ZoneScheduleSpec{mondayAt(12, 30, 5 * time.Minute)},
+	}
+
+	preview, err := f.gc.PreviewSchedule(lawn, laneTestStart, 1)
+	require.NoError(t, err)
+
+	// The proposed zone is not saved by the preview
+	require.Empty(t, f.gc.GetZoneInfo("lawn"))
+	f.reload(func() error { return f.gc.UpdateZone(lawn, true) })
+
+	f.advanceTo(laneTestStart.AddDate(0, 0, 1))
+
+	runs := preview["0"].Runs
+	f.eventually(func() bool { return len(f.history()) == len(runs) })
+
+	for i, run := range f.history() {
+		require.Equal(t, run.Id, runs[i].ZoneId, "run %d", i)
+		require.True(t, run.Started.Equal(runs[i].Start), "run %d at %s", i, runs[i].Start)
+		require.Equal(t, run.Duration, runs[i].Duration, "run %d", i)
+		require.Equal(t, run.Skipped, runs[i].Skipped, "run %d", i)
+	}
+
+	require.Equal(t, "lawn", runs[len(runs) - 1].ZoneId)
+	require.NotEmpty(t, runs[len(runs) - 1].Skipped)
+
+	// The tulips run during the soak and delay the next cycle of the roses
+	conflicts := preview["0"].Conflicts
+	require.Len(t, conflicts, 1)
+	require.Equal(t, "roses", conflicts[0].ZoneId)
+	require.Equal(t, time.Minute, conflicts[0].Delay)
+	require.Equal(t, []string{"tulips"}, conflicts[0].With)
+}
+
+func TestFindConflicts(t *testing.T) {
+	due := laneTestStart.Add(time.Hour)
+
+	runs := []*PreviewRun{
+		{ZoneId: "roses", Program: "morning", Due: due, Start: due, Duration: 10 * time.Minute},
+		{ZoneId: "tulips", Program: "morning", Due: due, Start: due.Add(10 * time.Minute), Duration: 5 * time.Minute},
+		{ZoneId: "lawn", Due: due.Add(5 * time.Minute), Start: due.Add(15 * time.Minute), Duration: 5 * time.Minute},
+		{ZoneId: "hedge", Due: due.Add(20 * time.Minute), Start: due.Add(20 * time.Minute), Skipped: "blackout"},
+		{ZoneId: "beds", Due: due.Add(25 * time.Minute), Start: due.Add(30 * time.Minute), Duration: 5 * time.Minute},
+	}
+
+	// The steps of one program do not conflict, the lawn waits for both of them.
+	//  A late run with nothing in the way is not a conflict
+	conflicts := findConflicts(runs)
+	require.Len(t, conflicts, 1)
+	require.Equal(t, "lawn", conflicts[0].ZoneId)
+	require.Equal(t, 10 * time.Minute, conflicts[0].Delay)
+	require.Equal(t, []string{"roses", "tulips"}, conflicts[0].With)
+}
+
+func TestPreviewHorizon(t *testing.T) {
+	roses := testZone("roses", "gpio0")
+	roses.Schedule = []*model.ZoneScheduleSpec{mondayMorning}
+
+	gc := newControllerFixture(t, roses).gc
+
+	_, err := gc.PreviewSchedule(nil, laneTestStart, 0)
+	require.Error(t, err)
+
+	_, err = gc.PreviewSchedule(nil, laneTestStart, maxPreviewDays + 1)
+	require.Error(t, err)
+
+	// Every Monday within the horizon, the next one is past the end
+	preview, err := gc.PreviewSchedule(nil, laneTestStart, maxPreviewDays)
+	require.NoError(t, err)
+
+	runs := preview["0"].Runs
+	require.Len(t, runs, 9)
+	require.True(t, laneTestStart.Add(time.Hour).AddDate(0, 0, 56).Equal(runs[8].Start))
+}
@@ -0,0 +1,225 @@
+package controller
+
+import (
+	"fmt"
+	"geck/model"
+	"geck/schedule"
+	"log"
+	"time"
+)
+
+// ProgramRuntimeState a program of the lane with its schedule
+type ProgramRuntimeState struct {
+	info    *model.Program
+	sch     schedule.Schedule
+
+	// last schedule slot, which was cancelled
+	handledSlot time.Time
+}
+
+// programSpecs the program schedule, with the duration of the whole
+//  program, so that the finish by specs work for the last zone
+func programSpecs(p *model.Program) []*model.ZoneScheduleSpec {
+	var total time.Duration
+
+	for _, step := range p.Steps {
+		total += step.Duration
+	}
+
+	result := make([]*model.ZoneScheduleSpec, len(p.Schedule))
+
+	for i, sch := range p.Schedule {
+		spec := *sch
+		spec.Duration = total
+		result[i] = &spec
+	}
+
+	return result
+}
+
+func (lane *Lane) hasProgram(id string) bool {
+	for _, run := range lane.queue {
+		if run.ProgramId == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+// resetPrograms replace the lane programs. The programs, which have
+//  already started, run to the end unless they are deleted
+func (lane *Lane) resetPrograms(programs []*model.Program, t time.Time) {
+	lane.programs = make(map[string]*ProgramRuntimeState)
+
+	for _, p := range programs {
+		lane.programs[p.Id] = &ProgramRuntimeState{
+			info:    p,
+			sch:     buildSchedule(programSpecs(p), "Local", lane.site()),
+		}
+	}
+
+	lane.removeRuns(func(run *ZoneRunData) bool {
+		_, ok := lane.programs[run.ProgramId]
+		return run.kind == RunProgram && (!ok || run.StartTime.After(t))
+	})
+
+	lane.refill(t)
+}
+
+// queueProgram queue the zones of the program at its next start,
+//  they have the same start time, so they run back to back in order
+func (lane *Lane) queueProgram(p *ProgramRuntimeState, t time.Time) {
+	_, rt := p.sch.GetNearest(t)
+
+	if rt.Time.Equal(p.handledSlot) {
+		_, rt = p.sch.GetNearest(rt.Time.Add(time.Second))
+	}
+
+	if rt.Time.IsZero() {
+		return
+	}
+
+	for _, step := range p.info.Steps {
+		if _, ok := lane.zones[ZoneIdType(step.ZoneId)]; !ok {
+			// Zones are not loaded yet, try on the next refill
+			return
+		}
+	}
+
+	// The other zones run during the soaks, so the program takes
+	//  at least as long as all the zones or the longest of them
+	var runs []*ZoneRunData
+	var total, length time.Duration
+
+	for _, step := range p.info.Steps {
+		zone := lane.zones[ZoneIdType(step.ZoneId)]
+
+		if !zone.enabled {
+			continue
+		}
+
+		run := &ZoneRunData{
+			ZoneRun: ZoneRun{
+				Duration:  lane.adjustDuration(zone, step.Duration, rt.Time),
+				ZoneId:    zone.Id,
+				ProgramId: p.info.Id,
+			},
+			actor: zone.actor,
+			slot:  rt.Time,
+		}
+
+		total += run.Duration
+
+		if d := zone.runLength(run.Duration); d > length {
+			length = d
+		}
+
+		runs = append(runs, run)
+	}
+
+	if total > length {
+		length = total
+	}
+
+	start := finishStart(rt.Time, rt.Data, length, t)
+
+	for _, run := range runs {
+		run.StartTime = start
+		lane.enqueue(run, RunProgram)
+	}
+}
+
+// refillPrograms queue the next start of every program, which is not queued
+func (lane *Lane) refillPrograms(t time.Time) {
+	for _, p := range lane.programs {
+		if !p.info.IsEnabled || p.sch.Len() == 0 || lane.hasProgram(p.info.Id) {
+			continue
+		}
+
+		lane.queueProgram(p, t)
+	}
+}
+
+func (lane *Lane) ResetPrograms(programs []*model.Program) {
+	lane.ProgramC <- programs
+}
+
+// programLane all zones of a program must be on the same lane
+func (gc *GardenController) programLane(p *model.Program) (string, error) {
+	laneId := ""
+
+	for i, step := range p.Steps {
+		zone, ok := gc.zoneMap()[step.ZoneId]
+
+		if !ok {
+			return "", fmt.Errorf("zone not found: %s", step.ZoneId)
+		}
+
+		if i > 0 && zone.info.Lane != laneId {
+			return "", fmt.Errorf("program zones must be on the same lane: %s", p.Id)
+		}
+
+		laneId = zone.info.Lane
+	}
+
+	return laneId, nil
+}
+
+// reloadPrograms pass the programs to their lanes
+func (gc *GardenController) reloadPrograms() error {
+	programs, err := gc.storage.LoadPrograms()
+	if err != nil {
+		return err
+	}
+
+	byLane := make(map[string][]*model.Program)
+
+	for _, p := range programs {
+		laneId, err := gc.programLane(p)
+
+		if err != nil {
+			log.Printf("Program disabled: %s : %s", p.Id, err.Error())
+			continue
+		}
+
+		byLane[laneId] = append(byLane[laneId], p)
+	}
+
+	for laneId, lane := range gc.laneMap() {
+		lane.ResetPrograms(byLane[laneId])
+	}
+
+	return nil
+}
+
+// GetPrograms returns all the programs
+func (gc *GardenController) GetPrograms() ([]*model.Program, error) {
+	return gc.storage.LoadPrograms()
+}
+
+// SaveProgram add or replace the program
+func (gc *GardenController) SaveProgram(p *model.Program) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+
+	if _, err := gc.programLane(p); err != nil {
+		return err
+	}
+
+	if err := gc.storage.SaveProgram(p); err != nil {
+		return err
+	}
+
+	return gc.reloadPrograms()
+}
+
+// DeleteProgram remove the program, its queued zones are cancelled
+func (gc *GardenController) DeleteProgram(id string) error {
+	if err := gc.storage.DeleteProgram(id); err != nil {
+		return err
+	}
+
+	return gc.reloadPrograms()
+}
@@ -0,0 +1,77 @@
+package controller
+
+import (
+	"geck/model"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestLaneProgram(t *testing.T) {
+	f := newLaneFixture(t, []*model.ZoneInfo{
+		laneTestZone("roses"),
+		laneTestZone("lawn"),
+		cycleZone("tulips"),
+		laneTestZone("hedge"),
+	})
+
+	f.do(func() {
+		f.lane.ProgramC <- []*model.Program{{
+			Id:        "morning",
+			IsEnabled: true,
+			Schedule:  []*model.ZoneScheduleSpec{mondayMorning},
+			Steps: []*model.ProgramStep{
+				{ ZoneId: "roses", Duration: 5 * time.Minute },
+				{ ZoneId: "lawn", Duration: 4 * time.Minute },
+				{ ZoneId: "tulips", Duration: 6 * time.Minute },
+			},
+		}}
+	})
+
+	// The manual run stops the program zone, the rest of it runs
+	//  before the next zones of the program. The cycles of a zone
+	//  belong to the program too
+	f.advanceTo(laneTestStart.Add(time.Hour + 7 * time.Minute))
+	f.start("hedge", 2 * time.Minute)
+
+	f.advanceTo(laneTestStart.Add(time.Hour + 16 * time.Minute))
+
+	queue, err := f.queueRequest(&queueRequest{})
+	require.NoError(t, err)
+	queuedId(t, queue, "tulips", RunCycle)
+
+	for _, run := range queue {
+		require.Equal(t, "morning", run.Program, "%+v", run)
+	}
+
+	f.advanceTo(laneTestStart.Add(2 * time.Hour))
+
+	runs := f.finished()
+	requireRuns(t, []expectedRun{
+		{ "roses", time.Hour, 5 * time.Minute, 1 },
+		{ "lawn", time.Hour + 5 * time.Minute, 2 * time.Minute, 1 },
+		{ "hedge", time.Hour + 7 * time.Minute, 2 * time.Minute, 1 },
+		{ "lawn", time.Hour + 9 * time.Minute, 2 * time.Minute, 1 },
+		{ "tulips", time.Hour + 11 * time.Minute, 4 * time.Minute, 1 },
+		{ "tulips", time.Hour + 20 * time.Minute, 2 * time.Minute, 2 },
+	}, runs)
+
+	require.Equal(t, model.PreemptResume, runs[1].Interrupted)
+	require.Equal(t, runs[1].RunId, runs[3].RunId)
+	require.Equal(t, "", runs[2].ProgramId)
+
+	for _, i := range []int{0, 1, 3, 4, 5} {
+		require.Equal(t, "morning", runs[i].ProgramId, "run %d", i)
+	}
+
+	// The program is queued again for the next week, in order
+	queue, err = f.queueRequest(&queueRequest{})
+	require.NoError(t, err)
+	require.Len(t, queue, 3)
+
+	for i, zone := range []string{"roses", "lawn", "tulips"} {
+		require.Equal(t, zone, queue[i].ZoneId)
+		require.Equal(t, RunProgram, queue[i].Kind)
+		require.True(t, laneTestStart.AddDate(0, 0, 7).Add(time.Hour).Equal(queue[i].StartTime))
+	}
+}
@@ -0,0 +1,340 @@
+package controller
+
+import (
+	"container/heap"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+const (
+	RunScheduled = "scheduled"
+	RunManual    = "manual"
+	RunResume    = "resume"
+	RunCycle     = "cycle"
+	RunProgram   = "program"
+)
+
+// Manual runs and the leftovers they preempt are due as soon as
+//  they are queued, so they go first. Scheduled runs and cycles wait
+//  for their start time
+var runPriority = map[string]int{
+	RunManual:    2,
+	RunResume:    1,
+	RunScheduled: 0,
+	RunCycle:     0,
+	RunProgram:   0,
+}
+
+// QueuedRun a public representation of a pending run of a lane
+type QueuedRun struct {
+	Id        uint64        `json:"id"`
+	ZoneId    string        `json:"zone_id"`
+	Kind      string        `json:"kind"`
+	StartTime time.Time     `json:"start"`
+	Duration  time.Duration `json:"for"`
+	RunId     string        `json:"run_id,omitempty"`
+	Cycle     int           `json:"cycle,omitempty"`
+	Program   string        `json:"program,omitempty"`
+}
+
+// runQueue priority queue of the pending runs, implements heap.Interface
+type runQueue []*ZoneRunData
+
+func (q runQueue) Len() int {
+	return len(q)
+}
+
+func (q runQueue) Less(i, j int) bool {
+	return q[i].before(q[j])
+}
+
+func (q runQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *runQueue) Push(x interface{}) {
+	run := x.(*ZoneRunData)
+	run.index = len(*q)
+	*q = append(*q, run)
+}
+
+func (q *runQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	run := old[n-1]
+	old[n-1] = nil
+	run.index = -1
+	*q = old[:n-1]
+	return run
+}
+
+func (q runQueue) peek() *ZoneRunData {
+	if len(q) == 0 {
+		return nil
+	}
+
+	return q[0]
+}
+
+func (run *ZoneRunData) before(other *ZoneRunData) bool {
+	if run.priority != other.priority {
+		return run.priority > other.priority
+	}
+
+	if !run.StartTime.Equal(other.StartTime) {
+		return run.StartTime.Before(other.StartTime)
+	}
+
+	return run.seq < other.seq
+}
+
+func (run *ZoneRunData) public() QueuedRun {
+	return QueuedRun{
+		Id:        run.queueId,
+		ZoneId:    string(run.ZoneId),
+		Kind:      run.kind,
+		StartTime: run.StartTime,
+		Duration:  run.Duration,
+		RunId:     run.RunId,
+		Cycle:     run.Cycle,
+		Program:   run.ProgramId,
+	}
+}
+
+// enqueue add a pending run to the lane
+func (lane *Lane) enqueue(run *ZoneRunData, kind string) {
+	lane.queueSeq++
+
+	run.kind = kind
+	run.priority = runPriority[kind]
+	run.queueId = lane.queueSeq
+	run.seq = lane.queueSeq
+	run.skip = lane.skipReason(run, run.StartTime)
+
+	heap.Push(&lane.queue, run)
+
+	log.Printf("Queued %s run: zone %s, at %s for %.2f minutes",
+		kind,
+		run.ZoneId,
+		run.StartTime.Format(time.RFC3339),
+		run.Duration.Minutes())
+}
+
+// removeRuns drop the pending runs matching the filter
+func (lane *Lane) removeRuns(match func(run *ZoneRunData) bool) []*ZoneRunData {
+	var removed []*ZoneRunData
+	kept := lane.queue[:0]
+
+	for _, run := range lane.queue {
+		if match(run) {
+			removed = append(removed, run)
+			continue
+		}
+
+		kept = append(kept, run)
+	}
+
+	for i := len(kept); i < len(lane.queue); i++ {
+		lane.queue[i] = nil
+	}
+
+	lane.queue = kept
+
+	for i, run := range lane.queue {
+		run.index = i
+	}
+
+	heap.Init(&lane.queue)
+
+	return removed
+}
+
+// dropZoneRuns cancel the manual, resumed and cycle runs of the zone
+func (lane *Lane) dropZoneRuns(id ZoneIdType) bool {
+	removed := lane.removeRuns(func(run *ZoneRunData) bool {
+		return run.ZoneId == id && run.kind != RunScheduled
+	})
+
+	return len(removed) > 0
+}
+
+func (lane *Lane) hasScheduled(id ZoneIdType) bool {
+	for _, run := range lane.queue {
+		if run.ZoneId == id && run.kind == RunScheduled {
+			return true
+		}
+	}
+
+	return false
+}
+
+// refill queue the next scheduled run for every zone, which has none
+func (lane *Lane) refill(t time.Time) {
+	for _, z := range lane.zones {
+		if !z.enabled || z.State.IsRunning || z.sch.Len() == 0 || lane.hasScheduled(z.Id) {
+			continue
+		}
+
+		if next := lane.NextZoneRun(z, t); next != nil {
+			lane.enqueue(next, RunScheduled)
+		}
+	}
+
+	lane.refillPrograms(t)
+}
+
+// queueSnapshot the pending runs in the order they will start
+func (lane *Lane) queueSnapshot() []QueuedRun {
+	runs := make([]*ZoneRunData, len(lane.queue))
+	copy(runs, lane.queue)
+
+	sort.Slice(runs, func(i, j int) bool {
+		return runs[i].before(runs[j])
+	})
+
+	result := make([]QueuedRun, len(runs))
+
+	for i, run := range runs {
+		result[i] = run.public()
+	}
+
+	return result
+}
+
+// cancelRun drop the pending run, a scheduled slot is recorded as skipped
+func (lane *Lane) cancelRun(id uint64, t time.Time) error {
+	removed := lane.removeRuns(func(run *ZoneRunData) bool {
+		return run.queueId == id
+	})
+
+	if len(removed) == 0 {
+		return fmt.Errorf("queued run not found: %d", id)
+	}
+
+	run := removed[0]
+	log.Printf("Cancelled %s run: zone %s", run.kind, run.ZoneId)
+
+	if zone, ok := lane.zones[run.ZoneId]; ok && run.kind == RunScheduled {
+		zone.handledSlot = run.slot
+		lane.recordSkipped(zone, run, "cancelled")
+	}
+
+	if p, ok := lane.programs[run.ProgramId]; ok && run.kind == RunProgram {
+		p.handledSlot = run.slot
+	}
+
+	return nil
+}
+
+// reorderRuns the listed runs swap their places in the queue,
+//  so that they start in the requested order
+func (lane *Lane) reorderRuns(ids []uint64) error {
+	byId := make(map[uint64]*ZoneRunData)
+
+	for _, run := range lane.queue {
+		byId[run.queueId] = run
+	}
+
+	runs := make([]*ZoneRunData, len(ids))
+
+	for i, id := range ids {
+		run, ok := byId[id]
+
+		if !ok {
+			return fmt.Errorf("queued run not found: %d", id)
+		}
+
+		runs[i] = run
+		delete(byId, id)
+	}
+
+	// Places in the queue, in the current order
+	slots := make([]ZoneRunData, len(runs))
+
+	for i, run := range runs {
+		slots[i] = *run
+	}
+
+	sort.Slice(slots, func(i, j int) bool {
+		return slots[i].before(&slots[j])
+	})
+
+	for i, run := range runs {
+		run.priority = slots[i].priority
+		run.StartTime = slots[i].StartTime
+		run.seq = slots[i].seq
+		run.skip = lane.skipReason(run, run.StartTime)
+	}
+
+	heap.Init(&lane.queue)
+
+	return nil
+}
+
+type queueRequest struct {
+	cancel []uint64
+	order  []uint64
+	reply  chan queueReply
+}
+
+type queueReply struct {
+	runs []QueuedRun
+	err  error
+}
+
+func (lane *Lane) handleQueueRequest(req *queueRequest, t time.Time) {
+	var err error
+
+	for _, id := range req.cancel {
+		if err = lane.cancelRun(id, t); err != nil {
+			break
+		}
+	}
+
+	if err == nil && len(req.order) > 0 {
+		err = lane.reorderRuns(req.order)
+	}
+
+	req.reply <- queueReply{
+		runs: lane.queueSnapshot(),
+		err:  err,
+	}
+}
+
+// laneQueueRequest pass the request to the lane goroutine and wait for the reply
+func (gc *GardenController) laneQueueRequest(laneId string, req *queueRequest) ([]QueuedRun, error) {
+	lane, ok := gc.laneMap()[laneId]
+
+	if !ok {
+		return nil, fmt.Errorf("lane not found : %s", laneId)
+	}
+
+	req.reply = make(chan queueReply, 1)
+	lane.QueueC <- req
+
+	select {
+	case reply := <-req.reply:
+		return reply.runs, reply.err
+	case <-time.After(5 * time.Second):
+		return nil, fmt.Errorf("lane is not responding : %s", laneId)
+	}
+}
+
+// GetLaneQueue returns the pending runs of the lane in the start order
+func (gc *GardenController) GetLaneQueue(laneId string) ([]QueuedRun, error) {
+	return gc.laneQueueRequest(laneId, &queueRequest{})
+}
+
+// CancelQueuedRun remove the pending run from the lane
+func (gc *GardenController) CancelQueuedRun(laneId string, id uint64) ([]QueuedRun, error) {
+	return gc.laneQueueRequest(laneId, &queueRequest{cancel: []uint64{id}})
+}
+
+// ReorderLaneQueue make the listed pending runs start in the given order
+func (gc *GardenController) ReorderLaneQueue(laneId string, ids []uint64) ([]QueuedRun, error) {
+	return gc.laneQueueRequest(laneId, &queueRequest{order: ids})
+}
@@ -0,0 +1,182 @@
+package controller
+
+import (
+	"geck/model"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func mondayAt(h, m uint8, d time.Duration) *model.ZoneScheduleSpec {
+	return &model.ZoneScheduleSpec{
+		DaysOfWeek: []time.Weekday{time.Monday},
+		Hours:      h,
+		Minutes:    m,
+		Duration:   d,
+	}
+}
+
+// queuedId the id of the first pending run of the zone and kind
+func queuedId(t *testing.T, queue []QueuedRun, zone string, kind string) uint64 {
+	for _, run := range queue {
+		if run.ZoneId == zone && run.Kind == kind {
+			return run.Id
+		}
+	}
+
+	require.Fail(t, "run not queued", "%s %s in %+v", zone, kind, queue)
+	return 0
+}
+
+func TestLaneQueueCancel(t *testing.T) {
+	f := newLaneFixture(t, []*model.ZoneInfo{
+		laneTestZone("roses", mondayMorning),
+		laneTestZone("lawn", mondayAt(6, 30, 5 * time.Minute)),
+	})
+
+	queue, err := f.queueRequest(&queueRequest{})
+	require.NoError(t, err)
+	require.Len(t, queue, 2)
+	require.Equal(t, "roses", queue[0].ZoneId)
+
+	_, err = f.queueRequest(&queueRequest{cancel: []uint64{1000}})
+	require.Error(t, err)
+
+	queue, err = f.queueRequest(&queueRequest{cancel: []uint64{queuedId(t, queue, "roses", RunScheduled)}})
+	require.NoError(t, err)
+	require.Len(t, queue, 1)
+
+	// The next run is the one of the next week
+	queue, err = f.queueRequest(&queueRequest{})
+	require.NoError(t, err)
+	require.Len(t, queue, 2)
+	require.Equal(t, "lawn", queue[0].ZoneId)
+	require.True(t, laneTestStart.AddDate(0, 0, 7).Add(time.Hour).Equal(queue[1].StartTime))
+
+	f.advanceTo(laneTestStart.Add(2 * time.Hour))
+
+	runs := f.finished()
+	require.Len(t, runs, 2)
+	require.Equal(t, ZoneIdType("roses"), runs[0].ZoneId)
+	require.Equal(t, "cancelled", runs[0].Skipped)
+	require.True(t, laneTestStart.Add(time.Hour).Equal(runs[0].StartTime))
+	require.Equal(t, ZoneIdType("lawn"), runs[1].ZoneId)
+	require.Equal(t, "", runs[1].Skipped)
+}
+
+func TestLaneQueueReorder(t *testing.T) {
+	f := newLaneFixture(t, []*model.ZoneInfo{
+		laneTestZone("roses", mondayMorning),
+		laneTestZone("lawn"),
+		laneTestZone("tulips", mondayAt(6, 30, 5 * time.Minute)),
+	})
+
+	f.advanceTo(laneTestStart.Add(time.Hour + 4 * time.Minute))
+	f.start("lawn", 3 * time.Minute)
+
+	queue, err := f.queueRequest(&queueRequest{})
+	require.NoError(t, err)
+	require.Len(t, queue, 3)
+	require.Equal(t, RunResume, queue[0].Kind)
+	require.Equal(t, "tulips", queue[1].ZoneId)
+
+	// The scheduled run takes the place of the resumed one, and the other way round
+	queue, err = f.queueRequest(&queueRequest{order: []uint64{
+		queuedId(t, queue, "tulips", RunScheduled),
+		queuedId(t, queue, "roses", RunResume),
+	}})
+	require.NoError(t, err)
+	require.Equal(t, "tulips", queue[0].ZoneId)
+	require.True(t, laneTestStart.Add(time.Hour + 4 * time.Minute).Equal(queue[0].StartTime))
+	require.Equal(t, "roses", queue[1].ZoneId)
+	require.True(t, laneTestStart.Add(time.Hour + 30 * time.Minute).Equal(queue[1].StartTime))
+
+	_, err = f.queueRequest(&queueRequest{order: []uint64{1000}})
+	require.Error(t, err)
+
+	f.advanceTo(laneTestStart.Add(2 * time.Hour))
+
+	requireRuns(t, []expectedRun{
+		{ "roses", time.Hour, 4 * time.Minute, 1 },
+		{ "lawn", time.Hour + 4 * time.Minute, 3 * time.Minute, 1 },
+		{ "tulips", time.Hour + 7 * time.Minute, 5 * time.Minute, 1 },
+		{ "roses", time.Hour + 30 * time.Minute, 6 * time.Minute, 1 },
+	}, f.finished())
+}
+
+func TestLaneQueueRefillAfterReload(t *testing.T) {
+	f := newLaneFixture(t, []*model.ZoneInfo{
+		laneTestZone("roses", mondayMorning),
+		laneTestZone("lawn"),
+		laneTestZone("tulips", mondayAt(6, 30, 5 * time.Minute)),
+	})
+
+	f.advanceTo(laneTestStart.Add(time.Hour + 4 * time.Minute))
+	f.start("lawn", 3 * time.Minute)
+	f.advanceTo(laneTestStart.Add(time.Hour + 5 * time.Minute))
+
+	// The new schedule replaces the scheduled runs, the resumed one is kept.
+	//  The runs of the removed zone are dropped
+	f.do(func() {
+		f.lane.ResetC <- []*model.ZoneInfo{
+			laneTestZone("roses", mondayAt(7, 0, 10 * time.Minute)),
+			laneTestZone("lawn", mondayAt(6, 50, 5 * time.Minute)),
+		}
+	})
+
+	queue, err := f.queueRequest(&queueRequest{})
+	require.NoError(t, err)
+	require.Len(t, queue, 3)
+	require.Equal(t, RunResume, queue[0].Kind)
+	require.Equal(t, "lawn", queue[1].ZoneId)
+	require.Equal(t, "roses", queue[2].ZoneId)
+
+	f.advanceTo(laneTestStart.Add(3 * time.Hour))
+
+	requireRuns(t, []expectedRun{
+		{ "roses", time.Hour, 4 * time.Minute, 1 },
+		{ "lawn", time.Hour + 4 * time.Minute, 3 * time.Minute, 1 },
+		{ "roses", time.Hour + 7 * time.Minute, 6 * time.Minute, 1 },
+		{ "lawn", time.Hour + 50 * time.Minute, 5 * time.Minute, 1 },
+		{ "roses", 2 * time.Hour, 10 * time.Minute, 1 },
+	}, f.finished())
+}
+
+func TestLaneQueuePreemptionKeepsRuns(t *testing.T) {
+	f := newLaneFixture(t, []*model.ZoneInfo{
+		laneTestZone("roses", mondayMorning),
+		laneTestZone("lawn"),
+		laneTestZone("tulips", mondayAt(6, 5, 5 * time.Minute)),
+	})
+
+	f.advanceTo(laneTestStart.Add(time.Hour + 2 * time.Minute))
+	f.start("lawn", 3 * time.Minute)
+
+	// The resumed run is stopped again
+	f.advanceTo(laneTestStart.Add(time.Hour + 8 * time.Minute))
+	f.start("lawn", time.Minute)
+
+	f.advanceTo(laneTestStart.Add(2 * time.Hour))
+
+	runs := f.finished()
+	requireRuns(t, []expectedRun{
+		{ "roses", time.Hour, 2 * time.Minute, 1 },
+		{ "lawn", time.Hour + 2 * time.Minute, 3 * time.Minute, 1 },
+		{ "roses", time.Hour + 5 * time.Minute, 3 * time.Minute, 1 },
+		{ "lawn", time.Hour + 8 * time.Minute, time.Minute, 1 },
+		{ "roses", time.Hour + 9 * time.Minute, 5 * time.Minute, 1 },
+		{ "tulips", time.Hour + 14 * time.Minute, 5 * time.Minute, 1 },
+	}, runs)
+
+	require.Equal(t, model.PreemptResume, runs[0].Interrupted)
+	require.Equal(t, model.PreemptResume, runs[2].Interrupted)
+	require.Equal(t, runs[0].RunId, runs[4].RunId)
+
+	queue, err := f.queueRequest(&queueRequest{})
+	require.NoError(t, err)
+	require.Len(t, queue, 2)
+
+	for _, run := range queue {
+		require.Equal(t, RunScheduled, run.Kind)
+	}
+}
@@ -0,0 +1,139 @@
+package controller
+
+import (
+	"geck/clock"
+	"geck/driver"
+	"geck/model"
+	"geck/mqtt"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// relayDevice a Tasmota device with the yard relays, which reports the
+//  switched relays back. The commands of the other topics are only recorded
+type relayDevice struct {
+	client *mqtt.Client
+
+	mu       sync.Mutex
+	commands []string
+}
+
+func newRelayDevice(t *testing.T, broker *mqtt.Broker) *relayDevice {
+	device := &relayDevice{}
+	client, err := mqtt.Connect(mqtt.Options{
+		Address:   broker.Addr(),
+		ClientId:  "tasmota_yard",
+		OnMessage: device.handle,
+	})
+	require.NoError(t, err)
+	require.NoError(t, client.Subscribe("cmnd/#"))
+
+	device.client = client
+	return device
+}
+
+func (d *relayDevice) handle(msg mqtt.Message) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.commands = append(d.commands, msg.Topic + " " + string(msg.Payload))
+
+	if strings.HasPrefix(msg.Topic, "cmnd/yard/") {
+		stat := mqtt.Message{Topic: "stat/" + strings.TrimPrefix(msg.Topic, "cmnd/"), Payload: msg.Payload}
+		go d.client.Publish(stat)
+	}
+}
+
+func (d *relayDevice) received(command string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, c := range d.commands {
+		if c == command {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (d *relayDevice) reset() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.commands = nil
+}
+
+func TestMQTTRelays(t *testing.T) {
+	broker, err := mqtt.NewBroker("127.0.0.1:0")
+	require.NoError(t, err)
+	defer broker.Close()
+
+	device := newRelayDevice(t, broker)
+
+	drv := driver.NewMQTTDriver(&driver.MQTTConfig{
+		Broker: broker.Addr(),
+		Relays: []driver.TasmotaRelay{
+			{ Id: "yard1", Topic: "yard", Relay: 1 },
+			{ Id: "shed", Topic: "shed" },
+			{ Id: "pump", Topic: "pump" },
+		},
+	})
+	require.NoError(t, drv.Startup())
+	defer drv.Shutdown()
+
+	// The relays are switched off once the driver is connected
+	require.Eventually(t, func() bool { return device.received("cmnd/pump/POWER OFF") }, time.Second, time.Millisecond)
+	device.reset()
+
+	storage := &memoryStorage{
+		zones:    []*model.ZoneInfo{testZone("roses", "yard1"), testZone("lawn", "shed")},
+		settings: &model.GardenSettings{Master: &model.MasterValve{HardwareId: "pump"}},
+	}
+
+	f := startControllerFixture(t, clock.NewFake(laneTestStart), drv, storage)
+
+	start := func(zone string) {
+		n := f.clock.Created()
+		require.NoError(t, f.gc.StartZone(zone, 5 * time.Minute, false))
+		require.NoError(t, f.clock.WaitCreated(n + 1, time.Second))
+	}
+
+	// The zone runs, until the device reports the relay is on
+	start("roses")
+	require.False(t, f.gc.GetZoneInfo("roses")[0].Disabled)
+
+	yard := drv.AvailableActors()[0]
+	f.eventually(yard.IsRunning)
+
+	f.advanceTo(laneTestStart.Add(startCheckInterval))
+	f.eventually(func() bool { return f.gc.GetZoneInfo("roses")[0].IsRunning })
+
+	// The master is switched off, although the device never reported it's on
+	f.advanceTo(laneTestStart.Add(6 * time.Minute))
+	f.eventually(func() bool { return len(f.history()) == 1 })
+	require.Equal(t, 5 * time.Minute, f.history()[0].Duration)
+
+	f.eventually(func() bool { return device.received("cmnd/pump/POWER ON") && device.received("cmnd/pump/POWER OFF") })
+	require.Empty(t, storage.alertsOf(model.AlertStartFailed))
+
+	// The zone without a device fails, once the start is not confirmed in time
+	device.reset()
+	start("lawn")
+
+	f.advanceTo(laneTestStart.Add(6 * time.Minute + startConfirmTimeout))
+	f.eventually(func() bool { return len(storage.alertsOf(model.AlertStartFailed)) == 1 })
+	require.Equal(t, "lawn", storage.alertsOf(model.AlertStartFailed)[0].ZoneId)
+
+	lawn := f.gc.GetZoneInfo("lawn")[0]
+	require.True(t, lawn.Disabled)
+	require.False(t, lawn.IsRunning)
+
+	f.eventually(func() bool { return len(f.history()) == 2 })
+	require.Equal(t, startConfirmTimeout, f.history()[1].Duration)
+
+	f.eventually(func() bool { return device.received("cmnd/shed/POWER OFF") && device.received("cmnd/pump/POWER OFF") })
+}
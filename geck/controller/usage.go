@@ -0,0 +1,121 @@
+package controller
+
+import (
+	"fmt"
+	"geck/model"
+	"sort"
+	"time"
+)
+
+const (
+	UsageByDay   = "day"
+	UsageByWeek  = "week"
+	UsageByMonth = "month"
+)
+
+// UsageTotals water volume and run time for one zone or lane
+type UsageTotals struct {
+	Volume  float64       `json:"volume"`
+	Runtime time.Duration `json:"runtime"`
+}
+
+func (u *UsageTotals) add(run *model.ZoneRun) {
+	u.Volume += run.Volume
+	u.Runtime += run.Duration
+}
+
+// UsagePeriod water usage within one day, week or month
+type UsagePeriod struct {
+	Start time.Time               `json:"start"`
+	Total UsageTotals             `json:"total"`
+	Zones map[string]*UsageTotals `json:"zones"`
+	Lanes map[string]*UsageTotals `json:"lanes"`
+}
+
+// periodStart truncates the time to the beginning of the
+//  day, week (Monday) or month in the given location
+func periodStart(t time.Time, period string, loc *time.Location) (time.Time, error) {
+	t = t.In(loc)
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+
+	switch period {
+	case UsageByDay:
+		return day, nil
+	case UsageByWeek:
+		return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7)), nil
+	case UsageByMonth:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, loc), nil
+	}
+
+	return t, fmt.Errorf("unknown usage period: %s", period)
+}
+
+func addTotals(totals map[string]*UsageTotals, key string, run *model.ZoneRun) {
+	item, ok := totals[key]
+
+	if !ok {
+		item = &UsageTotals{}
+		totals[key] = item
+	}
+
+	item.add(run)
+}
+
+// GetWaterUsage aggregates run history into per zone and per lane
+//  totals for every day, week or month in the [start, end) range
+func (gc *GardenController) GetWaterUsage(start, end time.Time, period string) ([]*UsagePeriod, error) {
+	if _, err := periodStart(start, period, gc.location); err != nil {
+		return nil, err
+	}
+
+	runs, err := gc.storage.GetHistory(start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	zones := gc.zoneMap()
+	result := make([]*UsagePeriod, 0)
+	byStart := make(map[int64]*UsagePeriod)
+
+	// History is written in the order runs finish, so it is
+	//  not strictly ordered by start time across lanes
+	for _, run := range runs {
+		pStart, _ := periodStart(run.Started, period, gc.location)
+		current, ok := byStart[pStart.Unix()]
+
+		if !ok {
+			current = &UsagePeriod{
+				Start: pStart,
+				Zones: make(map[string]*UsageTotals),
+				Lanes: make(map[string]*UsageTotals),
+			}
+
+			byStart[pStart.Unix()] = current
+			result = append(result, current)
+		}
+
+		r := *run
+
+		// Records written before volume and lane were tracked
+		//  are attributed using the current zone configuration
+		if zone, ok := zones[r.Id]; ok {
+			if r.Volume == 0 && !r.Measured {
+				r.Volume = zone.info.VolumeFor(r.Duration)
+			}
+
+			if r.Lane == "" {
+				r.Lane = zone.info.Lane
+			}
+		}
+
+		current.Total.add(&r)
+		addTotals(current.Zones, r.Id, &r)
+		addTotals(current.Lanes, r.Lane, &r)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Start.Before(result[j].Start)
+	})
+
+	return result, nil
+}
@@ -0,0 +1,347 @@
+package driver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+)
+
+/// ExpanderFile name of the I2C expanders config in the data directory
+const ExpanderFile = "expanders.json"
+
+/// Supported I2C expanders
+const (
+	ExpanderMCP23017 = "mcp23017"
+	ExpanderPCF8574  = "pcf8574"
+)
+
+/// I2CBus transfers to the devices on an I2C bus, the tests use a fake bus
+type I2CBus interface {
+	Write(addr uint16, data []byte) error
+	Close() error
+}
+
+/// ExpanderPin an output of the expander. The MCP23017 has two banks,
+///  a and b, of 8 pins each, the PCF8574 has a single bank of 8 pins
+type ExpanderPin struct {
+	Id         string `json:"id"`
+	Bank       string `json:"bank,omitempty"`
+	Pin        int    `json:"pin"`
+	ActiveHigh bool   `json:"active_high,omitempty"`
+}
+
+/// Expander an I2C expander chip, the address is the 7 bit bus address,
+///  e.g. 32 (0x20) for an MCP23017 with all the address pins low
+type Expander struct {
+	Type    string        `json:"type"`
+	Bus     int           `json:"bus"`
+	Address uint16        `json:"address"`
+	Pins    []ExpanderPin `json:"pins"`
+}
+
+/// ExpanderConfig the expanders of the system
+type ExpanderConfig struct {
+	Expanders []Expander `json:"expanders"`
+}
+
+func (e *Expander) banks() int {
+	if e.Type == ExpanderMCP23017 {
+		return 2
+	}
+
+	return 1
+}
+
+func (p *ExpanderPin) bank() int {
+	if p.Bank == "b" {
+		return 1
+	}
+
+	return 0
+}
+
+/// Validate check the expander types, the pins and that the ids are unique
+func (c *ExpanderConfig) Validate() error {
+	ids := make(map[string]bool)
+	addresses := make(map[[2]int]bool)
+
+	for _, e := range c.Expanders {
+		if e.Type != ExpanderMCP23017 && e.Type != ExpanderPCF8574 {
+			return fmt.Errorf("unknown expander type: %s", e.Type)
+		}
+
+		if e.Address > 0x7f {
+			return fmt.Errorf("invalid expander address: %+v", e)
+		}
+
+		key := [2]int{e.Bus, int(e.Address)}
+
+		if addresses[key] {
+			return fmt.Errorf("duplicate expander address: bus %d, address %d", e.Bus, e.Address)
+		}
+
+		addresses[key] = true
+		pins := make(map[[2]int]bool)
+
+		for _, p := range e.Pins {
+			if p.Id == "" {
+				return fmt.Errorf("expander pin without id: %+v", p)
+			}
+
+			if ids[p.Id] {
+				return fmt.Errorf("duplicate pin id: %s", p.Id)
+			}
+
+			if p.Bank != "" && p.Bank != "a" && (p.Bank != "b" || e.banks() < 2) {
+				return fmt.Errorf("invalid bank of %s: %s", e.Type, p.Bank)
+			}
+
+			if p.Pin < 0 || p.Pin > 7 {
+				return fmt.Errorf("expander pin number out of range: %+v", p)
+			}
+
+			if pins[[2]int{p.bank(), p.Pin}] {
+				return fmt.Errorf("expander pin is used twice: %+v", p)
+			}
+
+			ids[p.Id] = true
+			pins[[2]int{p.bank(), p.Pin}] = true
+		}
+	}
+
+	return nil
+}
+
+/// LoadExpanderConfig read the expanders, nil if the file does not exist
+func LoadExpanderConfig(path string) (*ExpanderConfig, error) {
+	data, err := ioutil.ReadFile(path)
+
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var result ExpanderConfig
+
+	if err = json.Unmarshal(data, &result); err != nil {
+		return nil, fmt.Errorf("invalid expander config %s: %s", path, err.Error())
+	}
+
+	if err = result.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid expander config %s: %s", path, err.Error())
+	}
+
+	return &result, nil
+}
+
+/// expanderChip the output latch of an expander, written a bank at a time
+type expanderChip struct {
+	mu      sync.Mutex
+	bus     I2CBus
+	kind    string
+	address uint16
+
+	// output levels and the pins, which are outputs
+	latch   [2]byte
+	outputs [2]byte
+}
+
+/// MCP23017 registers with IOCON.BANK = 0 (the power on default)
+const (
+	mcp23017IODIRA = 0x00
+	mcp23017OLATA  = 0x14
+)
+
+func (c *expanderChip) writeBank(bank int) error {
+	switch c.kind {
+	case ExpanderMCP23017:
+		return c.bus.Write(c.address, []byte{byte(mcp23017OLATA + bank), c.latch[bank]})
+	default:
+		// The PCF8574 pins are quasi bidirectional, the unused
+		//  ones are written high, so they stay inputs
+		return c.bus.Write(c.address, []byte{c.latch[0] | ^c.outputs[0]})
+	}
+}
+
+/// init write the off levels and then make the pins outputs
+func (c *expanderChip) init(banks int) error {
+	for bank := 0; bank < banks; bank++ {
+		if err := c.writeBank(bank); err != nil {
+			return err
+		}
+
+		if c.kind != ExpanderMCP23017 {
+			continue
+		}
+
+		iodir := []byte{byte(mcp23017IODIRA + bank), ^c.outputs[bank]}
+
+		if err := c.bus.Write(c.address, iodir); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *expanderChip) set(bank int, pin int, level bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	previous := c.latch[bank]
+
+	if level {
+		c.latch[bank] |= 1 << uint(pin)
+	} else {
+		c.latch[bank] &^= 1 << uint(pin)
+	}
+
+	if err := c.writeBank(bank); err != nil {
+		c.latch[bank] = previous
+		return err
+	}
+
+	return nil
+}
+
+/// ExpanderActor an expander output
+type ExpanderActor struct {
+	id         string
+	chip       *expanderChip
+	bank       int
+	pin        int
+	activeHigh bool
+	isOn       bool
+}
+
+/// GetID get pin name
+func (a *ExpanderActor) GetID() string {
+	return a.id
+}
+
+/// IsRunning get pin running
+func (a *ExpanderActor) IsRunning() bool {
+	return a.isOn
+}
+
+/// Start - activate the pin
+func (a *ExpanderActor) Start() {
+	if err := a.chip.set(a.bank, a.pin, a.activeHigh); err != nil {
+		log.Printf("Unable to start %s : %s", a.id, err.Error())
+		return
+	}
+
+	a.isOn = true
+}
+
+/// Stop - deactivate the pin
+func (a *ExpanderActor) Stop() {
+	if err := a.chip.set(a.bank, a.pin, !a.activeHigh); err != nil {
+		log.Printf("Unable to stop %s : %s", a.id, err.Error())
+		return
+	}
+
+	a.isOn = false
+}
+
+/// ExpanderDriver relays on I2C expanders
+type ExpanderDriver struct {
+	config *ExpanderConfig
+	open   func(bus int) (I2CBus, error)
+
+	buses  map[int]I2CBus
+	actors []*ExpanderActor
+}
+
+/// NewExpanderDriver the driver for the expanders on /dev/i2c-N
+func NewExpanderDriver(config *ExpanderConfig) *ExpanderDriver {
+	return newExpanderDriver(config, openI2CBus)
+}
+
+func newExpanderDriver(config *ExpanderConfig, open func(bus int) (I2CBus, error)) *ExpanderDriver {
+	return &ExpanderDriver{
+		config: config,
+		open:   open,
+		buses:  make(map[int]I2CBus),
+	}
+}
+
+/// AvailableActors enumerate the expander outputs
+func (d *ExpanderDriver) AvailableActors() []WireActor {
+	result := make([]WireActor, len(d.actors))
+
+	for i, actor := range d.actors {
+		result[i] = actor
+	}
+
+	return result
+}
+
+/// Startup open the buses and set all the outputs off
+func (d *ExpanderDriver) Startup() error {
+	for _, e := range d.config.Expanders {
+		bus, ok := d.buses[e.Bus]
+
+		if !ok {
+			var err error
+
+			if bus, err = d.open(e.Bus); err != nil {
+				return fmt.Errorf("unable to open i2c bus %d : %s", e.Bus, err.Error())
+			}
+
+			d.buses[e.Bus] = bus
+		}
+
+		chip := &expanderChip{
+			bus:     bus,
+			kind:    e.Type,
+			address: e.Address,
+		}
+
+		for _, p := range e.Pins {
+			actor := &ExpanderActor{
+				id:         p.Id,
+				chip:       chip,
+				bank:       p.bank(),
+				pin:        p.Pin,
+				activeHigh: p.ActiveHigh,
+			}
+
+			chip.outputs[actor.bank] |= 1 << uint(p.Pin)
+
+			if !p.ActiveHigh {
+				chip.latch[actor.bank] |= 1 << uint(p.Pin)
+			}
+
+			d.actors = append(d.actors, actor)
+		}
+
+		if err := chip.init(e.banks()); err != nil {
+			return fmt.Errorf("unable to init %s at bus %d, address %d : %s",
+				e.Type, e.Bus, e.Address, err.Error())
+		}
+
+		log.Printf("Expander %s : bus %d, address %d, %d pins", e.Type, e.Bus, e.Address, len(e.Pins))
+	}
+
+	return nil
+}
+
+/// Shutdown set all the outputs off and close the buses
+func (d *ExpanderDriver) Shutdown() {
+	for _, actor := range d.actors {
+		actor.Stop()
+	}
+
+	for _, bus := range d.buses {
+		_ = bus.Close()
+	}
+}
+
+var _ WireDriver = &ExpanderDriver{}
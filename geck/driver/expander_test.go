@@ -0,0 +1,179 @@
+package driver
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+)
+
+// fakeI2CBus MCP23017 registers and PCF8574 ports by the device address
+type fakeI2CBus struct {
+	mu        sync.Mutex
+	registers map[uint16]map[byte]byte
+	ports     map[uint16]byte
+	kinds     map[uint16]string
+	writes    int
+	fail      bool
+	closed    bool
+}
+
+func newFakeI2CBus() *fakeI2CBus {
+	return &fakeI2CBus{
+		registers: make(map[uint16]map[byte]byte),
+		ports:     make(map[uint16]byte),
+		kinds:     make(map[uint16]string),
+	}
+}
+
+func (b *fakeI2CBus) attach(addr uint16, kind string) {
+	b.kinds[addr] = kind
+
+	if kind == ExpanderMCP23017 {
+		// Power on: all the pins are inputs
+		b.registers[addr] = map[byte]byte{0x00: 0xff, 0x01: 0xff}
+	} else {
+		b.ports[addr] = 0xff
+	}
+}
+
+func (b *fakeI2CBus) Write(addr uint16, data []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.fail {
+		return fmt.Errorf("nack")
+	}
+
+	b.writes++
+
+	switch b.kinds[addr] {
+	case ExpanderMCP23017:
+		// Register address, then the values of the registers after it
+		for i, value := range data[1:] {
+			b.registers[addr][data[0] + byte(i)] = value
+		}
+	case ExpanderPCF8574:
+		b.ports[addr] = data[len(data) - 1]
+	default:
+		return fmt.Errorf("no device at %d", addr)
+	}
+
+	return nil
+}
+
+func (b *fakeI2CBus) Close() error {
+	b.closed = true
+	return nil
+}
+
+func (b *fakeI2CBus) register(addr uint16, reg byte) byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.registers[addr][reg]
+}
+
+var testExpanders = &ExpanderConfig{
+	Expanders: []Expander{
+		{
+			Type:    ExpanderMCP23017,
+			Bus:     1,
+			Address: 0x20,
+			Pins: []ExpanderPin{
+				{ Id: "zone8", Pin: 0 },
+				{ Id: "zone9", Pin: 7 },
+				{ Id: "zone10", Bank: "b", Pin: 1 },
+				{ Id: "pump", Bank: "b", Pin: 7, ActiveHigh: true },
+			},
+		},
+		{
+			Type:    ExpanderPCF8574,
+			Bus:     1,
+			Address: 0x38,
+			Pins: []ExpanderPin{
+				{ Id: "zone11", Pin: 2 },
+			},
+		},
+	},
+}
+
+func TestExpanderDriver(t *testing.T) {
+	require.NoError(t, testExpanders.Validate())
+
+	bus := newFakeI2CBus()
+	bus.attach(0x20, ExpanderMCP23017)
+	bus.attach(0x38, ExpanderPCF8574)
+
+	opened := 0
+	drv := newExpanderDriver(testExpanders, func(n int) (I2CBus, error) {
+		require.Equal(t, 1, n)
+		opened++
+		return bus, nil
+	})
+
+	require.NoError(t, drv.Startup())
+	require.Equal(t, 1, opened)
+
+	// Only the used pins are outputs, the active low ones are high
+	require.Equal(t, byte(0x7e), bus.register(0x20, mcp23017IODIRA))
+	require.Equal(t, byte(0x7d), bus.register(0x20, mcp23017IODIRA + 1))
+	require.Equal(t, byte(0x81), bus.register(0x20, mcp23017OLATA))
+	require.Equal(t, byte(0x02), bus.register(0x20, mcp23017OLATA + 1))
+	require.Equal(t, byte(0xff), bus.ports[0x38])
+
+	actors := drv.AvailableActors()
+	require.Len(t, actors, 5)
+
+	actors[1].Start()
+	require.True(t, actors[1].IsRunning())
+	require.Equal(t, byte(0x01), bus.register(0x20, mcp23017OLATA))
+
+	actors[3].Start()
+	actors[2].Start()
+	require.Equal(t, byte(0x80), bus.register(0x20, mcp23017OLATA + 1))
+
+	actors[4].Start()
+	require.Equal(t, byte(0xfb), bus.ports[0x38])
+
+	actors[1].Stop()
+	require.False(t, actors[1].IsRunning())
+	require.Equal(t, byte(0x81), bus.register(0x20, mcp23017OLATA))
+
+	// A failed write keeps the pin state
+	bus.fail = true
+	actors[0].Start()
+	require.False(t, actors[0].IsRunning())
+	bus.fail = false
+
+	drv.Shutdown()
+	require.Equal(t, byte(0x02), bus.register(0x20, mcp23017OLATA + 1))
+	require.Equal(t, byte(0xff), bus.ports[0x38])
+	require.True(t, bus.closed)
+}
+
+func TestExpanderConfigValidate(t *testing.T) {
+	invalid := []Expander{
+		{ Type: "mcp9999", Address: 0x20 },
+		{ Type: ExpanderPCF8574, Address: 0x20, Pins: []ExpanderPin{{ Id: "x", Bank: "b", Pin: 0 }} },
+		{ Type: ExpanderMCP23017, Address: 0x20, Pins: []ExpanderPin{{ Id: "x", Pin: 8 }} },
+		{ Type: ExpanderMCP23017, Address: 0x80 },
+	}
+
+	for _, e := range invalid {
+		config := ExpanderConfig{Expanders: []Expander{e}}
+		require.Error(t, config.Validate(), "%+v", e)
+	}
+
+	duplicate := ExpanderConfig{Expanders: []Expander{
+		{ Type: ExpanderMCP23017, Address: 0x20, Pins: []ExpanderPin{{ Id: "x", Pin: 0 }} },
+		{ Type: ExpanderMCP23017, Address: 0x21, Pins: []ExpanderPin{{ Id: "x", Pin: 0 }} },
+	}}
+	require.Error(t, duplicate.Validate())
+
+	config, err := LoadExpanderConfig("testdata/expanders.json")
+	require.NoError(t, err)
+	require.Len(t, config.Expanders, 2)
+	require.Equal(t, uint16(0x20), config.Expanders[0].Address)
+	require.Equal(t, "b", config.Expanders[0].Pins[1].Bank)
+}
@@ -0,0 +1,457 @@
+package driver
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+/// gpioSys the system calls of the gpio character device driver, the
+///  tests replace them with a fake chip
+type gpioSys interface {
+	Open(path string) (int, error)
+	Ioctl(fd int, req uintptr, arg unsafe.Pointer) error
+	Read(fd int, p []byte) (int, error)
+	SetNonblock(fd int) error
+	Close(fd int) error
+}
+
+/// Structures and ioctls of the gpio uAPI v2, see linux/gpio.h
+
+const (
+	gpioLinesMax    = 64
+	gpioMaxNameSize = 32
+	gpioAttrsMax    = 10
+)
+
+const (
+	gpioLineFlagActiveLow   = 1 << 1
+	gpioLineFlagInput       = 1 << 2
+	gpioLineFlagOutput      = 1 << 3
+	gpioLineFlagEdgeFalling = 1 << 5
+	gpioLineFlagBiasPullUp  = 1 << 8
+)
+
+const gpioLineAttrOutputValues = 2
+
+type gpioChipInfo struct {
+	Name  [gpioMaxNameSize]byte
+	Label [gpioMaxNameSize]byte
+	Lines uint32
+}
+
+type gpioLineAttribute struct {
+	Id      uint32
+	Padding uint32
+	Value   uint64 // flags, output values or debounce period
+}
+
+type gpioLineConfigAttribute struct {
+	Attr gpioLineAttribute
+	Mask uint64
+}
+
+type gpioLineConfig struct {
+	Flags    uint64
+	NumAttrs uint32
+	Padding  [5]uint32
+	Attrs    [gpioAttrsMax]gpioLineConfigAttribute
+}
+
+type gpioLineRequest struct {
+	Offsets         [gpioLinesMax]uint32
+	Consumer        [gpioMaxNameSize]byte
+	Config          gpioLineConfig
+	NumLines        uint32
+	EventBufferSize uint32
+	Padding         [5]uint32
+	Fd              int32
+}
+
+type gpioLineValues struct {
+	Bits uint64
+	Mask uint64
+}
+
+type gpioLineEvent struct {
+	TimestampNs uint64
+	Id          uint32
+	Offset      uint32
+	Seqno       uint32
+	LineSeqno   uint32
+	Padding     [6]uint32
+}
+
+const (
+	iocWrite = 1
+	iocRead  = 2
+)
+
+func gpioIoctl(dir uintptr, nr uintptr, size uintptr) uintptr {
+	return dir << 30 | size << 16 | 0xB4 << 8 | nr
+}
+
+var (
+	gpioGetChipInfoIoctl   = gpioIoctl(iocRead, 0x01, unsafe.Sizeof(gpioChipInfo{}))
+	gpioGetLineIoctl       = gpioIoctl(iocRead | iocWrite, 0x07, unsafe.Sizeof(gpioLineRequest{}))
+	gpioLineSetValuesIoctl = gpioIoctl(iocRead | iocWrite, 0x0F, unsafe.Sizeof(gpioLineValues{}))
+)
+
+/// Events are buffered by the kernel, so they are read in batches
+const gpiodEventPollInterval = 50 * time.Millisecond
+
+/// Suggested kernel event buffer, the kernel caps it at 1024
+const gpiodEventBufferSize = 1024
+
+const gpiodConsumer = "geck"
+
+/// gpiodLines lines requested together, their values are set with one ioctl
+type gpiodLines struct {
+	mu  sync.Mutex
+	sys gpioSys
+	fd  int
+}
+
+func (l *gpiodLines) set(index int, on bool) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	values := gpioLineValues{Mask: 1 << uint(index)}
+
+	if on {
+		values.Bits = values.Mask
+	}
+
+	return l.sys.Ioctl(l.fd, gpioLineSetValuesIoctl, unsafe.Pointer(&values))
+}
+
+/// GpiodPin output line, the polarity is handled by the kernel
+type GpiodPin struct {
+	id    string
+	lines *gpiodLines
+	index int
+	isOn  bool
+}
+
+/// GetID get pin name
+func (p *GpiodPin) GetID() string {
+	return p.id
+}
+
+/// IsRunning get pin running
+func (p *GpiodPin) IsRunning() bool {
+	return p.isOn
+}
+
+/// Start - activate the pin
+func (p *GpiodPin) Start() {
+	if err := p.lines.set(p.index, true); err != nil {
+		log.Printf("Unable to start %s : %s", p.id, err.Error())
+		return
+	}
+
+	p.isOn = true
+}
+
+/// Stop - deactivate the pin
+func (p *GpiodPin) Stop() {
+	if err := p.lines.set(p.index, false); err != nil {
+		log.Printf("Unable to stop %s : %s", p.id, err.Error())
+		return
+	}
+
+	p.isOn = false
+}
+
+/// GpiodPulsePin pulse counting input line, counts the falling edges
+type GpiodPulsePin struct {
+	id    string
+	count uint64
+}
+
+/// GetID get pin name
+func (p *GpiodPulsePin) GetID() string {
+	return p.id
+}
+
+/// Pulses get the number of falling edges seen so far
+func (p *GpiodPulsePin) Pulses() uint64 {
+	return atomic.LoadUint64(&p.count)
+}
+
+/// GpiodDriver drives the pins through the linux gpio character device
+///  (uAPI v2), the pin numbers are the line offsets of the chip
+type GpiodDriver struct {
+	chipPath string
+	pins     *PinMap
+	sys      gpioSys
+
+	fds       []int
+	eventFd   int
+	pinMap    map[string]*GpiodPin
+	sensorMap map[uint32]*GpiodPulsePin
+	stopC     chan struct{}
+	doneC     chan struct{}
+	polling   bool
+}
+
+/// NewGpiodDriver the driver for the pins of the chip, e.g. /dev/gpiochip0
+func NewGpiodDriver(chipPath string, pins *PinMap) *GpiodDriver {
+	return newGpiodDriver(chipPath, pins, newGpioSys())
+}
+
+func newGpiodDriver(chipPath string, pins *PinMap, sys gpioSys) *GpiodDriver {
+	return &GpiodDriver{
+		chipPath:  chipPath,
+		pins:      pins,
+		sys:       sys,
+		eventFd:   -1,
+		pinMap:    map[string]*GpiodPin{},
+		sensorMap: map[uint32]*GpiodPulsePin{},
+		stopC:     make(chan struct{}),
+		doneC:     make(chan struct{}),
+	}
+}
+
+/// AvailableActors enumerate available pins
+func (d *GpiodDriver) AvailableActors() []WireActor {
+	result := make([]WireActor, 0, len(d.pinMap))
+
+	for _, p := range d.pins.Actors {
+		if pin, ok := d.pinMap[p.Id]; ok {
+			result = append(result, pin)
+		}
+	}
+
+	return result
+}
+
+/// AvailableSensors enumerate available pulse inputs
+func (d *GpiodDriver) AvailableSensors() []PulseSensor {
+	result := make([]PulseSensor, 0, len(d.sensorMap))
+
+	for _, p := range d.pins.Sensors {
+		if sensor, ok := d.sensorMap[uint32(p.Pin)]; ok {
+			result = append(result, sensor)
+		}
+	}
+
+	return result
+}
+
+func (d *GpiodDriver) request(chipFd int, pins []PinConfig, flags uint64) (int, error) {
+	req := gpioLineRequest{
+		NumLines: uint32(len(pins)),
+		Config:   gpioLineConfig{Flags: flags},
+	}
+
+	copy(req.Consumer[:], gpiodConsumer)
+
+	for i, p := range pins {
+		req.Offsets[i] = uint32(p.Pin)
+	}
+
+	if flags & gpioLineFlagInput != 0 {
+		req.EventBufferSize = gpiodEventBufferSize
+	}
+
+	if err := d.sys.Ioctl(chipFd, gpioGetLineIoctl, unsafe.Pointer(&req)); err != nil {
+		return -1, fmt.Errorf("unable to request lines %+v : %s", pins, err.Error())
+	}
+
+	d.fds = append(d.fds, int(req.Fd))
+
+	return int(req.Fd), nil
+}
+
+/// requestOutputs the lines of one polarity, in requests of at most 64 lines
+func (d *GpiodDriver) requestOutputs(chipFd int, activeHigh bool) error {
+	var pins []PinConfig
+
+	for _, p := range d.pins.Actors {
+		if p.ActiveHigh == activeHigh {
+			pins = append(pins, p)
+		}
+	}
+
+	flags := uint64(gpioLineFlagOutput)
+
+	if !activeHigh {
+		flags |= gpioLineFlagActiveLow
+	}
+
+	for start := 0; start < len(pins); start += gpioLinesMax {
+		chunk := pins[start:]
+
+		if len(chunk) > gpioLinesMax {
+			chunk = chunk[:gpioLinesMax]
+		}
+
+		fd, err := d.request(chipFd, chunk, flags)
+
+		if err != nil {
+			return err
+		}
+
+		lines := &gpiodLines{sys: d.sys, fd: fd}
+
+		for i, p := range chunk {
+			d.pinMap[p.Id] = &GpiodPin{
+				id:    p.Id,
+				lines: lines,
+				index: i,
+			}
+		}
+	}
+
+	return nil
+}
+
+func (d *GpiodDriver) requestSensors(chipFd int) error {
+	if len(d.pins.Sensors) == 0 {
+		return nil
+	}
+
+	if len(d.pins.Sensors) > gpioLinesMax {
+		return fmt.Errorf("too many sensors : %d", len(d.pins.Sensors))
+	}
+
+	fd, err := d.request(chipFd, d.pins.Sensors,
+		gpioLineFlagInput | gpioLineFlagEdgeFalling | gpioLineFlagBiasPullUp)
+
+	if err != nil {
+		return err
+	}
+
+	if err = d.sys.SetNonblock(fd); err != nil {
+		return err
+	}
+
+	d.eventFd = fd
+
+	for _, p := range d.pins.Sensors {
+		d.sensorMap[uint32(p.Pin)] = &GpiodPulsePin{id: p.Id}
+	}
+
+	return nil
+}
+
+/// Startup the driver
+func (d *GpiodDriver) Startup() error {
+	chipFd, err := d.sys.Open(d.chipPath)
+
+	if err != nil {
+		return fmt.Errorf("unable to open %s : %s", d.chipPath, err.Error())
+	}
+
+	// The line requests stay valid after the chip is closed
+	defer d.sys.Close(chipFd)
+
+	var info gpioChipInfo
+
+	if err = d.sys.Ioctl(chipFd, gpioGetChipInfoIoctl, unsafe.Pointer(&info)); err != nil {
+		return fmt.Errorf("unable to get chip info %s : %s", d.chipPath, err.Error())
+	}
+
+	log.Printf("GPIO chip : %s (%s), %d lines",
+		strings.TrimRight(string(info.Name[:]), "\x00"),
+		strings.TrimRight(string(info.Label[:]), "\x00"),
+		info.Lines)
+
+	for _, list := range [][]PinConfig{d.pins.Actors, d.pins.Sensors} {
+		for _, p := range list {
+			if uint32(p.Pin) >= info.Lines {
+				return fmt.Errorf("pin number out of range: %+v", p)
+			}
+		}
+	}
+
+	for _, activeHigh := range []bool{false, true} {
+		if err = d.requestOutputs(chipFd, activeHigh); err != nil {
+			return err
+		}
+	}
+
+	if err = d.requestSensors(chipFd); err != nil {
+		return err
+	}
+
+	d.polling = true
+	go d.pollEvents()
+
+	return nil
+}
+
+/// pollEvents count the buffered edge events of the sensors
+func (d *GpiodDriver) pollEvents() {
+	defer close(d.doneC)
+
+	if d.eventFd < 0 {
+		return
+	}
+
+	ticker := time.NewTicker(gpiodEventPollInterval)
+	defer ticker.Stop()
+
+	size := int(unsafe.Sizeof(gpioLineEvent{}))
+	buf := make([]byte, 64 * size)
+
+	for {
+		select {
+		case <-d.stopC:
+			return
+		case <-ticker.C:
+		}
+
+		for {
+			n, err := d.sys.Read(d.eventFd, buf)
+
+			if errors.Is(err, syscall.EAGAIN) {
+				break
+			}
+
+			if err != nil {
+				log.Printf("Unable to read gpio events : %s", err.Error())
+				break
+			}
+
+			if n <= 0 {
+				break
+			}
+
+			for i := 0; i + size <= n; i += size {
+				event := (*gpioLineEvent)(unsafe.Pointer(&buf[i]))
+
+				if sensor, ok := d.sensorMap[event.Offset]; ok {
+					atomic.AddUint64(&sensor.count, 1)
+				}
+			}
+		}
+	}
+}
+
+/// Shutdown driver
+func (d *GpiodDriver) Shutdown() {
+	close(d.stopC)
+
+	if d.polling {
+		<-d.doneC
+	}
+
+	for _, pin := range d.pinMap {
+		pin.Stop()
+	}
+
+	for _, fd := range d.fds {
+		_ = d.sys.Close(fd)
+	}
+}
+
+var _ WireDriver = &GpiodDriver{}
+var _ PulseDriver = &GpiodDriver{}
@@ -0,0 +1,43 @@
+//go:build linux
+// +build linux
+
+package driver
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+/// linuxGpioSys the system calls on the gpio character device
+type linuxGpioSys struct {
+}
+
+func newGpioSys() gpioSys {
+	return linuxGpioSys{}
+}
+
+func (linuxGpioSys) Open(path string) (int, error) {
+	return syscall.Open(path, syscall.O_RDWR|syscall.O_CLOEXEC, 0)
+}
+
+func (linuxGpioSys) Ioctl(fd int, req uintptr, arg unsafe.Pointer) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), req, uintptr(arg))
+
+	if errno != 0 {
+		return errno
+	}
+
+	return nil
+}
+
+func (linuxGpioSys) Read(fd int, p []byte) (int, error) {
+	return syscall.Read(fd, p)
+}
+
+func (linuxGpioSys) SetNonblock(fd int) error {
+	return syscall.SetNonblock(fd, true)
+}
+
+func (linuxGpioSys) Close(fd int) error {
+	return syscall.Close(fd)
+}
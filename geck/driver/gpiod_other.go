@@ -0,0 +1,39 @@
+//go:build !linux
+// +build !linux
+
+package driver
+
+import (
+	"fmt"
+	"unsafe"
+)
+
+/// unsupportedGpioSys the gpio character device is linux only
+type unsupportedGpioSys struct {
+}
+
+func newGpioSys() gpioSys {
+	return unsupportedGpioSys{}
+}
+
+var errGpioUnsupported = fmt.Errorf("gpio character device is only available on linux")
+
+func (unsupportedGpioSys) Open(path string) (int, error) {
+	return -1, errGpioUnsupported
+}
+
+func (unsupportedGpioSys) Ioctl(fd int, req uintptr, arg unsafe.Pointer) error {
+	return errGpioUnsupported
+}
+
+func (unsupportedGpioSys) Read(fd int, p []byte) (int, error) {
+	return 0, errGpioUnsupported
+}
+
+func (unsupportedGpioSys) SetNonblock(fd int) error {
+	return errGpioUnsupported
+}
+
+func (unsupportedGpioSys) Close(fd int) error {
+	return errGpioUnsupported
+}
@@ -0,0 +1,241 @@
+package driver
+
+import (
+	"github.com/stretchr/testify/require"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+type fakeLineRequest struct {
+	offsets []uint32
+	flags   uint64
+	events  []gpioLineEvent
+}
+
+// fakeGpioChip decodes the uAPI v2 ioctls and keeps the physical line levels
+type fakeGpioChip struct {
+	mu       sync.Mutex
+	lines    uint32
+	levels   map[uint32]bool
+	requests map[int]*fakeLineRequest
+	nextFd   int
+}
+
+func newFakeGpioChip(lines uint32) *fakeGpioChip {
+	return &fakeGpioChip{
+		lines:    lines,
+		levels:   make(map[uint32]bool),
+		requests: make(map[int]*fakeLineRequest),
+		nextFd:   100,
+	}
+}
+
+func (c *fakeGpioChip) Open(path string) (int, error) {
+	if path != "/dev/gpiochip0" {
+		return -1, syscall.ENOENT
+	}
+
+	return 3, nil
+}
+
+func (c *fakeGpioChip) Ioctl(fd int, req uintptr, arg unsafe.Pointer) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch req {
+	case gpioGetChipInfoIoctl:
+		info := (*gpioChipInfo)(arg)
+		copy(info.Name[:], "gpiochip0")
+		copy(info.Label[:], "fake")
+		info.Lines = c.lines
+
+	case gpioGetLineIoctl:
+		lr := (*gpioLineRequest)(arg)
+		request := &fakeLineRequest{flags: lr.Config.Flags}
+
+		for _, offset := range lr.Offsets[:lr.NumLines] {
+			if offset >= c.lines {
+				return syscall.EINVAL
+			}
+
+			request.offsets = append(request.offsets, offset)
+
+			// Output lines start inactive
+			if lr.Config.Flags & gpioLineFlagOutput != 0 {
+				c.levels[offset] = lr.Config.Flags & gpioLineFlagActiveLow != 0
+			}
+		}
+
+		// unless the initial values are set
+		for _, attr := range lr.Config.Attrs[:lr.Config.NumAttrs] {
+			if attr.Attr.Id != gpioLineAttrOutputValues {
+				continue
+			}
+
+			for i, offset := range request.offsets {
+				if attr.Mask & (1 << uint(i)) != 0 {
+					c.levels[offset] = attr.Attr.Value & (1 << uint(i)) != 0
+				}
+			}
+		}
+
+		c.nextFd++
+		c.requests[c.nextFd] = request
+		lr.Fd = int32(c.nextFd)
+
+	case gpioLineSetValuesIoctl:
+		request, ok := c.requests[fd]
+
+		if !ok || request.flags & gpioLineFlagOutput == 0 {
+			return syscall.EPERM
+		}
+
+		values := (*gpioLineValues)(arg)
+
+		for i, offset := range request.offsets {
+			if values.Mask & (1 << uint(i)) == 0 {
+				continue
+			}
+
+			active := values.Bits & (1 << uint(i)) != 0
+			c.levels[offset] = active != (request.flags & gpioLineFlagActiveLow != 0)
+		}
+
+	default:
+		return syscall.ENOTTY
+	}
+
+	return nil
+}
+
+func (c *fakeGpioChip) Read(fd int, p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	request, ok := c.requests[fd]
+
+	if !ok {
+		return 0, syscall.EBADF
+	}
+
+	size := int(unsafe.Sizeof(gpioLineEvent{}))
+	n := 0
+
+	for len(request.events) > 0 && n + size <= len(p) {
+		*(*gpioLineEvent)(unsafe.Pointer(&p[n])) = request.events[0]
+		request.events = request.events[1:]
+		n += size
+	}
+
+	if n == 0 {
+		return 0, syscall.EAGAIN
+	}
+
+	return n, nil
+}
+
+func (c *fakeGpioChip) SetNonblock(fd int) error {
+	return nil
+}
+
+func (c *fakeGpioChip) Close(fd int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.requests, fd)
+	return nil
+}
+
+// pulse queue falling edges on an input line
+func (c *fakeGpioChip) pulse(offset uint32, n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, request := range c.requests {
+		for _, o := range request.offsets {
+			if o == offset && request.flags & gpioLineFlagEdgeFalling != 0 {
+				for i := 0; i < n; i++ {
+					request.events = append(request.events, gpioLineEvent{Offset: offset})
+				}
+			}
+		}
+	}
+}
+
+func (c *fakeGpioChip) level(offset uint32) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.levels[offset]
+}
+
+func TestGpioUAPI(t *testing.T) {
+	// Sizes and ioctl numbers from linux/gpio.h
+	require.Equal(t, uintptr(592), unsafe.Sizeof(gpioLineRequest{}))
+	require.Equal(t, uintptr(48), unsafe.Sizeof(gpioLineEvent{}))
+	require.Equal(t, uintptr(0x8044b401), gpioGetChipInfoIoctl)
+	require.Equal(t, uintptr(0xc250b407), gpioGetLineIoctl)
+	require.Equal(t, uintptr(0xc010b40f), gpioLineSetValuesIoctl)
+}
+
+func TestGpiodDriver(t *testing.T) {
+	chip := newFakeGpioChip(32)
+	drv := newGpiodDriver("/dev/gpiochip0", &PinMap{
+		Actors: []PinConfig{
+			{ Id: "gpio0", Pin: 17 },
+			{ Id: "gpio1", Pin: 18 },
+			{ Id: "pump", Pin: 26, ActiveHigh: true },
+		},
+		Sensors: []PinConfig{
+			{ Id: "flow0", Pin: 7 },
+		},
+	}, chip)
+
+	require.NoError(t, drv.Startup())
+
+	actors := drv.AvailableActors()
+	require.Len(t, actors, 3)
+	require.Equal(t, "gpio0", actors[0].GetID())
+
+	// Active low lines are high while off
+	require.True(t, chip.level(17))
+	require.False(t, chip.level(26))
+
+	actors[1].Start()
+	require.True(t, actors[1].IsRunning())
+	require.False(t, chip.level(18))
+	require.True(t, chip.level(17))
+
+	actors[2].Start()
+	require.True(t, chip.level(26))
+
+	actors[1].Stop()
+	require.False(t, actors[1].IsRunning())
+	require.True(t, chip.level(18))
+
+	sensors := drv.AvailableSensors()
+	require.Len(t, sensors, 1)
+
+	chip.pulse(7, 100)
+	require.Eventually(t, func() bool {
+		return sensors[0].Pulses() == 100
+	}, time.Second, 10 * time.Millisecond)
+
+	drv.Shutdown()
+	require.False(t, chip.level(26))
+	require.Empty(t, chip.requests)
+}
+
+func TestGpiodDriverErrors(t *testing.T) {
+	pins := &PinMap{Actors: []PinConfig{{ Id: "gpio0", Pin: 40 }}}
+
+	drv := newGpiodDriver("/dev/gpiochip0", pins, newFakeGpioChip(32))
+	require.Error(t, drv.Startup())
+	drv.Shutdown()
+
+	drv = newGpiodDriver("/dev/gpiochip9", pins, newFakeGpioChip(64))
+	require.Error(t, drv.Startup())
+}
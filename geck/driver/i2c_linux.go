@@ -0,0 +1,61 @@
+//go:build linux
+// +build linux
+
+package driver
+
+import (
+	"fmt"
+	"sync"
+	"syscall"
+)
+
+/// I2C_SLAVE ioctl from linux/i2c-dev.h
+const i2cSlaveIoctl = 0x0703
+
+/// linuxI2CBus the /dev/i2c-N device
+type linuxI2CBus struct {
+	mu   sync.Mutex
+	fd   int
+	addr int
+}
+
+func openI2CBus(bus int) (I2CBus, error) {
+	fd, err := syscall.Open(fmt.Sprintf("/dev/i2c-%d", bus), syscall.O_RDWR|syscall.O_CLOEXEC, 0)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &linuxI2CBus{fd: fd, addr: -1}, nil
+}
+
+func (b *linuxI2CBus) Write(addr uint16, data []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if int(addr) != b.addr {
+		_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(b.fd), i2cSlaveIoctl, uintptr(addr))
+
+		if errno != 0 {
+			return errno
+		}
+
+		b.addr = int(addr)
+	}
+
+	n, err := syscall.Write(b.fd, data)
+
+	if err != nil {
+		return err
+	}
+
+	if n != len(data) {
+		return fmt.Errorf("short i2c write : %d of %d bytes", n, len(data))
+	}
+
+	return nil
+}
+
+func (b *linuxI2CBus) Close() error {
+	return syscall.Close(b.fd)
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package driver
+
+import (
+	"fmt"
+)
+
+func openI2CBus(bus int) (I2CBus, error) {
+	return nil, fmt.Errorf("i2c is only available on linux")
+}
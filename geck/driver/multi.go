@@ -0,0 +1,82 @@
+package driver
+
+import (
+	"fmt"
+	"geck/registry"
+)
+
+/// MultiDriver combines several drivers, e.g. the onboard pins and the
+///  expanders. The drivers start in order and shut down in reverse
+type MultiDriver struct {
+	drivers []WireDriver
+	started int
+}
+
+/// NewMultiDriver the drivers combined
+func NewMultiDriver(drivers ...WireDriver) *MultiDriver {
+	return &MultiDriver{drivers: drivers}
+}
+
+/// AvailableActors the pins of all the drivers
+func (md *MultiDriver) AvailableActors() []WireActor {
+	var result []WireActor
+
+	for _, drv := range md.drivers {
+		result = append(result, drv.AvailableActors()...)
+	}
+
+	return result
+}
+
+/// AvailableSensors the pulse inputs of the drivers, which have them
+func (md *MultiDriver) AvailableSensors() []PulseSensor {
+	var result []PulseSensor
+
+	for _, drv := range md.drivers {
+		if pulseDriver, ok := drv.(PulseDriver); ok {
+			result = append(result, pulseDriver.AvailableSensors()...)
+		}
+	}
+
+	return result
+}
+
+/// Startup start the drivers and check the pin ids are unique across them
+func (md *MultiDriver) Startup() error {
+	for _, drv := range md.drivers {
+		if service, ok := drv.(registry.Service); ok {
+			if err := service.Startup(); err != nil {
+				return err
+			}
+		}
+
+		md.started++
+	}
+
+	ids := make(map[string]bool)
+
+	for _, actor := range md.AvailableActors() {
+		if ids[actor.GetID()] {
+			return fmt.Errorf("pin id is used by several drivers: %s", actor.GetID())
+		}
+
+		ids[actor.GetID()] = true
+	}
+
+	return nil
+}
+
+/// Shutdown the started drivers
+func (md *MultiDriver) Shutdown() {
+	for i := md.started - 1; i >= 0; i-- {
+		if service, ok := md.drivers[i].(registry.Service); ok {
+			service.Shutdown()
+		}
+	}
+
+	md.started = 0
+}
+
+var _ WireDriver = &MultiDriver{}
+var _ PulseDriver = &MultiDriver{}
+var _ registry.Service = &MultiDriver{}
@@ -0,0 +1,38 @@
+package driver
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestMultiDriver(t *testing.T) {
+	bus := newFakeI2CBus()
+	bus.attach(0x20, ExpanderMCP23017)
+	bus.attach(0x38, ExpanderPCF8574)
+
+	expanders := newExpanderDriver(testExpanders, func(int) (I2CBus, error) {
+		return bus, nil
+	})
+
+	drv := NewMultiDriver(NewTestDriver(), expanders)
+	require.NoError(t, drv.Startup())
+
+	ids := make(map[string]bool)
+
+	for _, actor := range drv.AvailableActors() {
+		ids[actor.GetID()] = true
+	}
+
+	require.Len(t, ids, 13)
+	require.True(t, ids["gpio0"])
+	require.True(t, ids["zone11"])
+	require.Len(t, drv.AvailableSensors(), 1)
+
+	drv.Shutdown()
+	require.True(t, bus.closed)
+
+	// The same pin id on two drivers
+	drv = NewMultiDriver(NewTestDriver(), NewTestDriver())
+	require.Error(t, drv.Startup())
+	drv.Shutdown()
+}
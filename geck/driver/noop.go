@@ -2,13 +2,22 @@ package driver
 
 import (
 	"fmt"
+	"geck/clock"
 	"geck/registry"
 	"log"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type TestActor struct {
 	id      string
-	started bool
+	clock   clock.Clock
+	started int32
+
+	// simulated open valve time, for the flow sensor
+	startedAt int64
+	openTime  int64
 }
 
 func (t *TestActor) GetID() string {
@@ -16,21 +25,112 @@ func (t *TestActor) GetID() string {
 }
 
 func (t *TestActor) IsRunning() bool {
-	return t.started
+	return atomic.LoadInt32(&t.started) != 0
 }
 
 func (t *TestActor) Start() {
 	fmt.Printf("Started %s\n", t.id)
-	t.started = true
+	atomic.StoreInt32(&t.started, 1)
+	atomic.CompareAndSwapInt64(&t.startedAt, 0, t.clock.Now().UnixNano())
 }
 
 func (t *TestActor) Stop() {
 	fmt.Printf("Stopped %s\n", t.id)
-	t.started = false
+	atomic.StoreInt32(&t.started, 0)
+
+	if startedAt := atomic.SwapInt64(&t.startedAt, 0); startedAt != 0 {
+		atomic.AddInt64(&t.openTime, t.clock.Now().UnixNano() - startedAt)
+	}
+}
+
+// totalOpenTime time the simulated valve has been open so far
+func (t *TestActor) totalOpenTime(now time.Time) time.Duration {
+	result := atomic.LoadInt64(&t.openTime)
+
+	if startedAt := atomic.LoadInt64(&t.startedAt); startedAt != 0 {
+		result += now.UnixNano() - startedAt
+	}
+
+	return time.Duration(result)
+}
+
+// TestSensor simulates a flow meter on the main line, which
+//  gives a fixed number of pulses per second for every open valve
+type TestSensor struct {
+	id              string
+	clock           clock.Clock
+	pulsesPerSecond float64
+	actors          []*TestActor
+
+	// injected faults
+	mu        sync.Mutex
+	leakRate  float64
+	leakSince time.Time
+	leaked    float64
+	blocked   bool
+	frozen    uint64
+	offset    uint64
+}
+
+func (t *TestSensor) GetID() string {
+	return t.id
+}
+
+func (t *TestSensor) rawPulses(now time.Time) uint64 {
+	var open time.Duration
+
+	for _, actor := range t.actors {
+		open += actor.totalOpenTime(now)
+	}
+
+	leaked := t.leaked + now.Sub(t.leakSince).Seconds() * t.leakRate
+
+	return uint64(open.Seconds() * t.pulsesPerSecond + leaked)
+}
+
+func (t *TestSensor) Pulses() uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.blocked {
+		return t.frozen
+	}
+
+	return t.rawPulses(t.clock.Now()) - t.offset
+}
+
+// InjectLeak simulate flow while all the valves are closed
+func (t *TestSensor) InjectLeak(pulsesPerSecond float64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.clock.Now()
+	t.leaked += now.Sub(t.leakSince).Seconds() * t.leakRate
+	t.leakRate = pulsesPerSecond
+	t.leakSince = now
+}
+
+// InjectBlockage simulate no flow even if valves are open
+func (t *TestSensor) InjectBlockage(blocked bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	raw := t.rawPulses(t.clock.Now())
+
+	if blocked && !t.blocked {
+		t.frozen = raw - t.offset
+	}
+
+	if !blocked && t.blocked {
+		t.offset = raw - t.frozen
+	}
+
+	t.blocked = blocked
 }
 
 type TestDriver struct {
-	actors []*TestActor
+	actors  []*TestActor
+	sensors []*TestSensor
 }
 
 func (td * TestDriver) Startup() error {
@@ -52,18 +152,51 @@ func (td * TestDriver) AvailableActors() []WireActor {
 	return result
 }
 
-var testDriver = &TestDriver{
-	actors: []*TestActor{
-		{ id: "gpio7" },
-		{ id: "gpio0" },
-		{ id: "gpio1" },
-		{ id: "gpio2" },
-		{ id: "gpio3" },
-		{ id: "gpio4" },
-		{ id: "gpio5" },
-		{ id: "gpio6" },
-	},
+func (td * TestDriver) AvailableSensors() []PulseSensor {
+	result := make([]PulseSensor, len(td.sensors))
+
+	for i, sensor := range td.sensors {
+		result[i] = sensor
+	}
+
+	return result
+}
+
+func (td * TestDriver) GetSensor(id string) (*TestSensor, error) {
+	for _, sensor := range td.sensors {
+		if sensor.id == id {
+			return sensor, nil
+		}
+	}
+
+	return nil, fmt.Errorf("test sensor not found: %s", id)
 }
 
+// NewTestDriver console driver, which only prints pin changes
+func NewTestDriver() *TestDriver {
+	return NewTestDriverWithClock(clock.Real{})
+}
+
+// NewTestDriverWithClock the test driver, which simulates the flow
+//  by the given clock, e.g. a fake one in the controller tests
+func NewTestDriverWithClock(clk clock.Clock) *TestDriver {
+	var actors []*TestActor
+
+	for _, id := range []string{"gpio7", "gpio0", "gpio1", "gpio2", "gpio3", "gpio4", "gpio5", "gpio6"} {
+		actors = append(actors, &TestActor{id: id, clock: clk})
+	}
+
+	return &TestDriver{
+		actors: actors,
+		sensors: []*TestSensor{
+			// 7.5 pulses per second is 1 litre per minute for a YF-S201 meter
+			{ id: "flow0", clock: clk, pulsesPerSecond: 7.5, actors: actors, leakSince: clk.Now() },
+		},
+	}
+}
+
+var testDriver = NewTestDriver()
+
 var _ WireDriver = testDriver
+var _ PulseDriver = testDriver
 var _ registry.Service = testDriver
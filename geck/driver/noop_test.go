@@ -0,0 +1,43 @@
+package driver
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestTestSensorFaults(t *testing.T) {
+	drv := NewTestDriver()
+	sensor, err := drv.GetSensor("flow0")
+	require.NoError(t, err)
+
+	require.Equal(t, uint64(0), sensor.Pulses())
+
+	// Leak gives pulses with all the valves closed
+	sensor.InjectLeak(100000)
+	time.Sleep(10 * time.Millisecond)
+	sensor.InjectLeak(0)
+
+	leaked := sensor.Pulses()
+	require.True(t, leaked > 0)
+
+	time.Sleep(10 * time.Millisecond)
+	require.Equal(t, leaked, sensor.Pulses())
+
+	// Blockage gives no pulses with an open valve
+	sensor.InjectBlockage(true)
+	drv.actors[0].Start()
+	time.Sleep(10 * time.Millisecond)
+	require.Equal(t, leaked, sensor.Pulses())
+
+	sensor.InjectBlockage(false)
+	time.Sleep(200 * time.Millisecond)
+	drv.actors[0].Stop()
+
+	require.True(t, sensor.Pulses() > leaked)
+}
+
+func TestGetSensorNotFound(t *testing.T) {
+	_, err := NewTestDriver().GetSensor("flow9")
+	require.Error(t, err)
+}
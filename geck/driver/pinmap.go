@@ -0,0 +1,216 @@
+package driver
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+/// PinMapFile name of the pin map in the data directory
+const PinMapFile = "pins.json"
+
+/// PinConfig a logical pin id and its BCM pin number (the line offset of
+///  the gpio chip for the gpiod driver). Relay boards are mostly active
+///  low, so the output is low when the pin is on by default
+type PinConfig struct {
+	Id         string `json:"id"`
+	Pin        int    `json:"pin"`
+	ActiveHigh bool   `json:"active_high,omitempty"`
+}
+
+/// PinMap outputs for the valves and pulse inputs for the flow meters
+type PinMap struct {
+	Actors  []PinConfig `json:"actors"`
+	Sensors []PinConfig `json:"sensors,omitempty"`
+}
+
+/// Validate check the pin numbers and that the ids and pins are unique
+func (m *PinMap) Validate() error {
+	ids := make(map[string]bool)
+	pins := make(map[int]string)
+
+	for _, list := range [][]PinConfig{m.Actors, m.Sensors} {
+		for _, p := range list {
+			if p.Id == "" {
+				return fmt.Errorf("pin without id: %+v", p)
+			}
+
+			if p.Pin < 0 {
+				return fmt.Errorf("pin number out of range: %+v", p)
+			}
+
+			if ids[p.Id] {
+				return fmt.Errorf("duplicate pin id: %s", p.Id)
+			}
+
+			if other, ok := pins[p.Pin]; ok {
+				return fmt.Errorf("pin %d is used by %s and %s", p.Pin, other, p.Id)
+			}
+
+			ids[p.Id] = true
+			pins[p.Pin] = p.Id
+		}
+	}
+
+	return nil
+}
+
+/// LoadPinMap read the pin map, nil if the file does not exist
+func LoadPinMap(path string) (*PinMap, error) {
+	data, err := ioutil.ReadFile(path)
+
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var result PinMap
+
+	if err = json.Unmarshal(data, &result); err != nil {
+		return nil, fmt.Errorf("invalid pin map %s: %s", path, err.Error())
+	}
+
+	if err = result.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid pin map %s: %s", path, err.Error())
+	}
+
+	return &result, nil
+}
+
+/// DefaultPinMap the pins used on the board by default, nil for an unknown board.
+///  There are no flow meters, they are only in pins.json
+func DefaultPinMap(board int) *PinMap {
+	// The Rev 1 boards have BCM 21 where the later ones have BCM 27
+	pin2 := 27
+
+	switch board {
+	case DRIVER_PI1_A:
+		pin2 = 21
+	case DRIVER_PI1_B, DRIVER_PI_40PIN:
+	default:
+		return nil
+	}
+
+	return &PinMap{
+		Actors: []PinConfig{
+			{ Id: "gpio7", Pin: 4 },
+			{ Id: "gpio0", Pin: 17 },
+			{ Id: "gpio1", Pin: 18 },
+			{ Id: "gpio2", Pin: pin2 },
+			{ Id: "gpio3", Pin: 22 },
+			{ Id: "gpio4", Pin: 23 },
+			{ Id: "gpio5", Pin: 24 },
+			{ Id: "gpio6", Pin: 25 },
+		},
+	}
+}
+
+/// parseModel find the board by its model name, as in the cpuinfo
+///  model line or in the device tree model
+func parseModel(text string) (int, bool) {
+	text = strings.Trim(text, " \t\n\x00")
+
+	if !strings.HasPrefix(text, "Raspberry Pi") {
+		return UNKNOWN, false
+	}
+
+	log.Printf("Hardware model : %s", text)
+
+	switch {
+	case strings.HasPrefix(text, "Raspberry Pi Model B Rev 2"),
+		strings.HasPrefix(text, "Raspberry Pi Model A Rev 2"):
+		return DRIVER_PI1_B, true
+	case strings.HasPrefix(text, "Raspberry Pi Model B Rev 1"),
+		text == "Raspberry Pi Model B":
+		return DRIVER_PI1_A, true
+	}
+
+	// B+, A+, Zero, 2, 3, 4, 400 and the compute modules
+	return DRIVER_PI_40PIN, true
+}
+
+func parseModelLine(text string) (int, bool) {
+	if !strings.HasPrefix(strings.ToLower(text), "model") {
+		return UNKNOWN, false
+	}
+
+	i := strings.Index(text, ":")
+
+	if i < 0 {
+		return UNKNOWN, false
+	}
+
+	return parseModel(text[i+1:])
+}
+
+/// parseRevisionLine find the board by the cpuinfo revision code, see
+///  https://www.raspberrypi.com/documentation/computers/raspberry-pi.html#raspberry-pi-revision-codes
+func parseRevisionLine(text string) (int, bool) {
+	if !strings.HasPrefix(strings.ToLower(text), "revision") {
+		return UNKNOWN, false
+	}
+
+	i := strings.Index(text, ":")
+
+	if i < 0 {
+		return UNKNOWN, false
+	}
+
+	code, err := strconv.ParseUint(strings.TrimSpace(text[i+1:]), 16, 32)
+
+	if err != nil {
+		return UNKNOWN, false
+	}
+
+	if code & (1 << 23) != 0 {
+		// New style revision codes are all 40 pin boards
+		return DRIVER_PI_40PIN, true
+	}
+
+	// Old style, without the overvoltage warranty bit
+	switch code & 0xffffff {
+	case 0x2, 0x3:
+		return DRIVER_PI1_A, true
+	case 0x4, 0x5, 0x6, 0x7, 0x8, 0x9, 0xd, 0xe, 0xf:
+		return DRIVER_PI1_B, true
+	case 0x10, 0x11, 0x12, 0x13, 0x14, 0x15:
+		return DRIVER_PI_40PIN, true
+	}
+
+	return UNKNOWN, false
+}
+
+/// DetectBoard find the board from the cpuinfo, by the model line or
+///  by the revision code, or from the device tree model
+func DetectBoard(cpuinfo io.Reader, deviceTreeModel string) int {
+	revision := UNKNOWN
+
+	if cpuinfo != nil {
+		scanner := bufio.NewScanner(cpuinfo)
+
+		for scanner.Scan() {
+			if board, ok := parseModelLine(scanner.Text()); ok {
+				return board
+			}
+
+			if board, ok := parseRevisionLine(scanner.Text()); ok {
+				revision = board
+			}
+		}
+	}
+
+	if board, ok := parseModel(deviceTreeModel); ok {
+		return board
+	}
+
+	return revision
+}
@@ -0,0 +1,71 @@
+package driver
+
+import (
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func detectFixture(t *testing.T, cpuinfo string, model string) int {
+	f, err := os.Open(filepath.Join("testdata", cpuinfo))
+	require.NoError(t, err)
+	defer f.Close()
+
+	return DetectBoard(f, model)
+}
+
+func TestDetectBoard(t *testing.T) {
+	require.Equal(t, DRIVER_PI1_A, detectFixture(t, "cpuinfo-pi1-rev1", ""))
+	require.Equal(t, DRIVER_PI1_B, detectFixture(t, "cpuinfo-pi1-rev2", ""))
+	require.Equal(t, DRIVER_PI_40PIN, detectFixture(t, "cpuinfo-pi3", ""))
+	require.Equal(t, DRIVER_PI_40PIN, detectFixture(t, "cpuinfo-pi4", ""))
+	require.Equal(t, UNKNOWN, detectFixture(t, "cpuinfo-x86", ""))
+
+	model, err := ioutil.ReadFile("testdata/device-tree-model-zero")
+	require.NoError(t, err)
+
+	require.Equal(t, DRIVER_PI_40PIN, detectFixture(t, "cpuinfo-x86", string(model)))
+	require.Equal(t, DRIVER_PI_40PIN, DetectBoard(nil, string(model)))
+}
+
+func TestDefaultPinMap(t *testing.T) {
+	require.Nil(t, DefaultPinMap(UNKNOWN))
+
+	rev1 := DefaultPinMap(DRIVER_PI1_A)
+	require.NoError(t, rev1.Validate())
+	require.Equal(t, 21, rev1.Actors[3].Pin)
+
+	pins := DefaultPinMap(DRIVER_PI_40PIN)
+	require.NoError(t, pins.Validate())
+	require.Len(t, pins.Actors, 8)
+	require.Equal(t, 27, pins.Actors[3].Pin)
+	require.Empty(t, pins.Sensors)
+}
+
+func TestLoadPinMap(t *testing.T) {
+	pins, err := LoadPinMap("testdata/pins.json")
+	require.NoError(t, err)
+	require.Len(t, pins.Actors, 3)
+	require.Equal(t, 26, pins.Actors[2].Pin)
+	require.True(t, pins.Actors[2].ActiveHigh)
+	require.False(t, pins.Actors[0].ActiveHigh)
+	require.Equal(t, 16, pins.Sensors[0].Pin)
+
+	pins, err = LoadPinMap("testdata/missing.json")
+	require.NoError(t, err)
+	require.Nil(t, pins)
+
+	invalid := &PinMap{
+		Actors:  []PinConfig{{ Id: "gpio0", Pin: 5 }},
+		Sensors: []PinConfig{{ Id: "flow0", Pin: 5 }},
+	}
+	require.Error(t, invalid.Validate())
+
+	invalid.Sensors[0].Pin = -1
+	require.Error(t, invalid.Validate())
+
+	invalid.Sensors[0] = PinConfig{ Id: "gpio0", Pin: 6 }
+	require.Error(t, invalid.Validate())
+}
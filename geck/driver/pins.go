@@ -16,8 +16,35 @@ type WireActor interface {
 }
 
 
+/// ConfirmedActor is implemented by the actuators, which report their state
+///  later, e.g. over the network. IsRunning is the state they report
+type ConfirmedActor interface {
+	WireActor
+
+	/// The last switch is not confirmed yet
+	IsPending() bool
+}
+
+
 /// WireDriver is an interface for hardware part
 type WireDriver interface {
 	/// Enumerate the pins
 	AvailableActors() []WireActor
 }
+
+
+/// PulseSensor is an interface for a pulse counting input (e.g. hall effect flow meter)
+type PulseSensor interface {
+	/// Get sensor identifier
+	GetID() string
+
+	/// Total number of pulses counted since the driver startup
+	Pulses() uint64
+}
+
+
+/// PulseDriver is implemented by the drivers which have pulse inputs
+type PulseDriver interface {
+	/// Enumerate the pulse sensors
+	AvailableSensors() []PulseSensor
+}
@@ -1,20 +1,24 @@
 package driver
 
 import (
-	"bufio"
 	"fmt"
 	"github.com/stianeikeland/go-rpio"
+	"io"
+	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
 	"runtime"
-	"strings"
+	"sync/atomic"
+	"time"
 )
 
 /// RPIOPin pin implementation
 type RPIOPin struct {
-	Pin  rpio.Pin
-	id   string
-	isOn bool
+	Pin        rpio.Pin
+	id         string
+	activeHigh bool
+	isOn       bool
 }
 
 /// GetID get pin name
@@ -29,20 +33,61 @@ func (rpp * RPIOPin) IsRunning() bool {
 
 /// Start - activate the pin
 func (rpp * RPIOPin) Start() {
-	rpp.Pin.Low()
+	rpp.write(true)
 	rpp.isOn = true
 }
 
 /// Stop - deactivate the pin
 func (rpp * RPIOPin) Stop() {
-	rpp.Pin.High()
+	rpp.write(false)
 	rpp.isOn = false
 }
 
+func (rpp * RPIOPin) write(on bool) {
+	if on == rpp.activeHigh {
+		rpp.Pin.High()
+	} else {
+		rpp.Pin.Low()
+	}
+}
+
+/// RPIOPulsePin pulse counting input pin
+type RPIOPulsePin struct {
+	Pin   rpio.Pin
+	id    string
+	count uint64
+}
+
+/// GetID get pin name
+func (rpp * RPIOPulsePin) GetID() string {
+	return rpp.id
+}
+
+/// Pulses get the number of falling edges seen so far
+func (rpp * RPIOPulsePin) Pulses() uint64 {
+	return atomic.LoadUint64(&rpp.count)
+}
+
+/// poll check the edge detection flag, reading it also resets it
+func (rpp * RPIOPulsePin) poll() {
+	if rpp.Pin.EdgeDetected() {
+		atomic.AddUint64(&rpp.count, 1)
+	}
+}
+
+/// Highest BCM pin number of the Broadcom SoCs
+const maxBCMPin = 53
+
+/// Hall effect flow meters give up to a few hundred pulses per second,
+///  so the edge detection flag is polled well below the pulse period
+const pulsePollInterval = time.Millisecond
+
 /// Raspberry Pi Pin driver based on rpio
 type RaspberryDriver struct {
-	pinMap  map[string]WireActor
-	version int
+	pins      *PinMap
+	pinMap    map[string]WireActor
+	sensorMap map[string]*RPIOPulsePin
+	stopC     chan struct{}
 }
 
 /// AvailableActors enumerate available pins
@@ -58,105 +103,252 @@ func (rpiod *RaspberryDriver) AvailableActors() []WireActor {
 	return result
 }
 
+/// AvailableSensors enumerate available pulse inputs
+func (rpiod *RaspberryDriver) AvailableSensors() []PulseSensor {
+	result := make([]PulseSensor, 0, len(rpiod.sensorMap))
+
+	for _, sensor := range rpiod.sensorMap {
+		result = append(result, sensor)
+	}
+
+	return result
+}
+
+func (rpiod *RaspberryDriver) pollSensors() {
+	ticker := time.NewTicker(pulsePollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-rpiod.stopC:
+			return
+		case <-ticker.C:
+			for _, sensor := range rpiod.sensorMap {
+				sensor.poll()
+			}
+		}
+	}
+}
+
 const (
 	UNKNOWN = 0
 	DRIVER_PI1_A = 11
 	DRIVER_PI1_B = 12
+	DRIVER_PI_40PIN = 40
 )
 
-func parseModelLine(text string) (int, bool) {
-	if !strings.HasPrefix(strings.ToLower(text), "model") {
-		return 0, false
+/// Drivers to choose from
+const (
+	DriverAuto  = "auto"
+	DriverRPIO  = "rpio"
+	DriverGpiod = "gpiod"
+	DriverTest  = "test"
+)
+
+/// IOConfig the driver kind and where it finds its configuration
+type IOConfig struct {
+	Kind          string
+	DataDirectory string
+
+	// gpio character device for the gpiod driver
+	GpioChip string
+}
+
+/// CreateIODriver the driver of the kind for the onboard pins, combined
+///  with the I2C expanders, the shift registers and the MQTT relays, if
+///  they are set in the data directory
+func CreateIODriver(config IOConfig) (WireDriver, error) {
+	pinDriver, pins, err := createPinDriver(config)
+
+	if err != nil {
+		return pinDriver, err
 	}
 
-	i := strings.Index(text, ": ")
+	drivers := []WireDriver{pinDriver}
+
+	expanders, err := LoadExpanderConfig(filepath.Join(config.DataDirectory, ExpanderFile))
+
+	if err != nil {
+		return pinDriver, err
+	}
 
-	if i > 0 {
-		text = text[i+2:]
+	if expanders != nil {
+		drivers = append(drivers, NewExpanderDriver(expanders))
 	}
 
-	log.Printf("Hardware model : %s", text)
+	registers, err := LoadShiftRegisterConfig(filepath.Join(config.DataDirectory, ShiftRegisterFile))
 
-	if strings.Contains(text, "Raspberry Pi Model B") {
-		if strings.Contains(text, "Rev 2") {
-			return DRIVER_PI1_B, true
+	if err != nil {
+		return pinDriver, err
+	}
+
+	if registers != nil {
+		if err = checkLinesUnused(pins, registers.lineOffsets()); err != nil {
+			return pinDriver, err
 		}
 
-		return DRIVER_PI1_A, true
+		drivers = append(drivers, NewShiftRegisterDriver(config.GpioChip, registers))
+	}
+
+	relays, err := LoadMQTTConfig(filepath.Join(config.DataDirectory, MQTTFile))
+
+	if err != nil {
+		return pinDriver, err
+	}
+
+	if relays != nil {
+		drivers = append(drivers, NewMQTTDriver(relays))
+	}
+
+	if len(drivers) == 1 {
+		return pinDriver, nil
 	}
 
-	return 0, false
+	return NewMultiDriver(drivers...), nil
 }
 
-func CreateIODriver() (WireDriver, error) {
-	if runtime.GOARCH == "amd64" {
-		return testDriver, nil
+/// checkLinesUnused the lines driven by other means are not in the pin map
+func checkLinesUnused(pins *PinMap, offsets []int) error {
+	if pins == nil {
+		return nil
 	}
 
-	cpuinfo, err := os.Open("/proc/cpuinfo")
+	for _, list := range [][]PinConfig{pins.Actors, pins.Sensors} {
+		for _, p := range list {
+			for _, offset := range offsets {
+				if p.Pin == offset {
+					return fmt.Errorf("pin %d of %s is used by the shift registers", p.Pin, p.Id)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+/// createPinDriver the driver of the kind and its pins from the data
+///  directory, or the default pins of the board. The auto kind is the
+///  Raspberry Pi driver, which falls back to the console driver on an
+///  unknown board
+func createPinDriver(config IOConfig) (WireDriver, *PinMap, error) {
+	pins, err := LoadPinMap(filepath.Join(config.DataDirectory, PinMapFile))
 
 	if err != nil {
-		panic(fmt.Errorf("unable to determine Hardware version : %s", err.Error()))
+		return testDriver, nil, err
 	}
 
-	defer cpuinfo.Close()
+	switch config.Kind {
+	case DriverTest:
+		return testDriver, nil, nil
+
+	case DriverRPIO, DriverGpiod:
+		if pins == nil {
+			pins = DefaultPinMap(detectBoard())
+		}
 
-	scanner := bufio.NewScanner(cpuinfo)
+		if pins == nil {
+			return testDriver, nil, fmt.Errorf("unknown board, the pins must be set in %s", PinMapFile)
+		}
 
-	var driver = UNKNOWN
+		if config.Kind == DriverGpiod {
+			return NewGpiodDriver(config.GpioChip, pins), pins, nil
+		}
 
-	for scanner.Scan() {
-		var ok bool
+		return newRaspberryDriver(pins), pins, nil
 
-		if driver, ok = parseModelLine(scanner.Text()); ok {
-			break
+	case DriverAuto, "":
+		if pins == nil && runtime.GOARCH == "amd64" {
+			return testDriver, nil, nil
 		}
-	}
 
-	if driver == UNKNOWN {
-		log.Print("WARNING! Unable to find driver, using console noop driver!")
-		return testDriver, nil
+		if pins == nil {
+			pins = DefaultPinMap(detectBoard())
+		}
+
+		if pins == nil {
+			log.Print("WARNING! Unable to find driver, using console noop driver!")
+			return testDriver, nil, nil
+		}
+
+		return newRaspberryDriver(pins), pins, nil
 	}
 
+	return testDriver, nil, fmt.Errorf("unknown io driver : %s", config.Kind)
+}
+
+func newRaspberryDriver(pins *PinMap) *RaspberryDriver {
 	return &RaspberryDriver{
+		pins: pins,
 		pinMap: map[string]WireActor{},
-		version: driver,
-	}, nil
+		sensorMap: map[string]*RPIOPulsePin{},
+		stopC: make(chan struct{}),
+	}
+}
+
+func detectBoard() int {
+	var cpuinfo io.Reader
+
+	if f, err := os.Open("/proc/cpuinfo"); err == nil {
+		defer f.Close()
+		cpuinfo = f
+	} else {
+		log.Printf("Unable to read cpuinfo : %s", err.Error())
+	}
+
+	model, _ := ioutil.ReadFile("/proc/device-tree/model")
+
+	return DetectBoard(cpuinfo, string(model))
 }
 
 /// Startup the driver
 func (rpiod *RaspberryDriver) Startup() error {
+	for _, list := range [][]PinConfig{rpiod.pins.Actors, rpiod.pins.Sensors} {
+		for _, p := range list {
+			if p.Pin > maxBCMPin {
+				return fmt.Errorf("pin number out of range: %+v", p)
+			}
+		}
+	}
+
 	if err := rpio.Open(); err != nil {
 		return err
 	}
 
-	createPin := func(id string, pin int) {
+	createPin := func(p PinConfig) {
 		iopin := &RPIOPin{
-			id:   id,
-			Pin:  rpio.Pin(pin),
-			isOn: false,
+			id:         p.Id,
+			Pin:        rpio.Pin(p.Pin),
+			activeHigh: p.ActiveHigh,
+			isOn:       false,
 		}
 
-		rpiod.pinMap[id] = iopin
+		rpiod.pinMap[p.Id] = iopin
 		iopin.Pin.Output()
-		iopin.Pin.High()
+		iopin.Stop()
 	}
 
-	if rpiod.version == DRIVER_PI1_B || rpiod.version == DRIVER_PI1_A {
-		createPin("gpio7", 4)
-		createPin("gpio0", 17)
-		createPin("gpio1", 18)
-
-		if rpiod.version == DRIVER_PI1_A {
-			createPin("gpio2", 21)
-		} else {
-			createPin("gpio2", 27)
+	createSensor := func(id string, pin int) {
+		sensor := &RPIOPulsePin{
+			id:  id,
+			Pin: rpio.Pin(pin),
 		}
 
-		createPin("gpio3", 22)
-		createPin("gpio4", 23)
-		createPin("gpio5", 24)
-		createPin("gpio6", 25)
+		rpiod.sensorMap[id] = sensor
+		sensor.Pin.Input()
+		sensor.Pin.PullUp()
+		sensor.Pin.Detect(rpio.FallEdge)
+	}
+
+	for _, p := range rpiod.pins.Actors {
+		createPin(p)
+	}
+
+	for _, p := range rpiod.pins.Sensors {
+		createSensor(p.Id, p.Pin)
+	}
+
+	if len(rpiod.sensorMap) > 0 {
+		go rpiod.pollSensors()
 	}
 
 	return nil
@@ -165,10 +357,18 @@ func (rpiod *RaspberryDriver) Startup() error {
 
 /// Shutdown driver
 func (rpiod *RaspberryDriver) Shutdown() {
+	close(rpiod.stopC)
+
 	for _, actor := range rpiod.pinMap {
 		actor.Stop()
 	}
 
+	for _, sensor := range rpiod.sensorMap {
+		sensor.Pin.Detect(rpio.NoEdge)
+	}
+
 	_ = rpio.Close()
 }
 
+var _ WireDriver = &RaspberryDriver{}
+var _ PulseDriver = &RaspberryDriver{}
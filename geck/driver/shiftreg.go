@@ -0,0 +1,379 @@
+package driver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"unsafe"
+)
+
+/// ShiftRegisterFile name of the shift register config in the data directory
+const ShiftRegisterFile = "shiftregister.json"
+
+/// BitBangLine a single output line, which is toggled in software
+type BitBangLine interface {
+	Set(high bool) error
+}
+
+/// ShiftRegisterPin an output of the chain, bit 0 is the Q0 output of
+///  the register, which is the nearest to the controller
+type ShiftRegisterPin struct {
+	Id  string `json:"id"`
+	Bit int    `json:"bit"`
+}
+
+/// ShiftRegisterConfig daisy chained 74HC595 registers on the data (SER),
+///  clock (SRCLK) and latch (RCLK) lines, with an optional active low output
+///  enable (OE). The lines are the line offsets of the gpio chip. The
+///  outputs are active high, e.g. for the triac drivers of the sprinkler
+///  boards, unless active_low is set
+type ShiftRegisterConfig struct {
+	Data      int  `json:"data"`
+	Clock     int  `json:"clock"`
+	Latch     int  `json:"latch"`
+	Enable    *int `json:"enable,omitempty"`
+	Registers int  `json:"registers"`
+	ActiveLow bool `json:"active_low,omitempty"`
+
+	// Without pins every bit is a pin named sr<bit>
+	Pins []ShiftRegisterPin `json:"pins,omitempty"`
+}
+
+/// lineOffsets data, clock, latch and the output enable if it's set
+func (c *ShiftRegisterConfig) lineOffsets() []int {
+	result := []int{c.Data, c.Clock, c.Latch}
+
+	if c.Enable != nil {
+		result = append(result, *c.Enable)
+	}
+
+	return result
+}
+
+/// pins the configured pins, or all the bits
+func (c *ShiftRegisterConfig) pins() []ShiftRegisterPin {
+	if len(c.Pins) > 0 {
+		return c.Pins
+	}
+
+	result := make([]ShiftRegisterPin, 8 * c.Registers)
+
+	for bit := range result {
+		result[bit] = ShiftRegisterPin{Id: fmt.Sprintf("sr%d", bit), Bit: bit}
+	}
+
+	return result
+}
+
+/// Validate check the lines are distinct and the pins are within the chain
+func (c *ShiftRegisterConfig) Validate() error {
+	if c.Registers < 1 {
+		return fmt.Errorf("at least one shift register is required: %d", c.Registers)
+	}
+
+	lines := make(map[int]bool)
+
+	for _, offset := range c.lineOffsets() {
+		if offset < 0 || lines[offset] {
+			return fmt.Errorf("shift register lines must be distinct: %v", c.lineOffsets())
+		}
+
+		lines[offset] = true
+	}
+
+	ids := make(map[string]bool)
+	bits := make(map[int]bool)
+
+	for _, p := range c.Pins {
+		if p.Id == "" || ids[p.Id] {
+			return fmt.Errorf("shift register pin without id or with a duplicate id: %+v", p)
+		}
+
+		if p.Bit < 0 || p.Bit >= 8 * c.Registers || bits[p.Bit] {
+			return fmt.Errorf("shift register bit out of range or used twice: %+v", p)
+		}
+
+		ids[p.Id] = true
+		bits[p.Bit] = true
+	}
+
+	return nil
+}
+
+/// LoadShiftRegisterConfig read the shift registers, nil if the file does not exist
+func LoadShiftRegisterConfig(path string) (*ShiftRegisterConfig, error) {
+	data, err := ioutil.ReadFile(path)
+
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var result ShiftRegisterConfig
+
+	if err = json.Unmarshal(data, &result); err != nil {
+		return nil, fmt.Errorf("invalid shift register config %s: %s", path, err.Error())
+	}
+
+	if err = result.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid shift register config %s: %s", path, err.Error())
+	}
+
+	return &result, nil
+}
+
+/// ShiftRegisterActor an output bit of the chain
+type ShiftRegisterActor struct {
+	id     string
+	driver *ShiftRegisterDriver
+	bit    int
+	isOn   bool
+}
+
+/// GetID get pin name
+func (a *ShiftRegisterActor) GetID() string {
+	return a.id
+}
+
+/// IsRunning get pin running
+func (a *ShiftRegisterActor) IsRunning() bool {
+	return a.isOn
+}
+
+/// Start - activate the pin
+func (a *ShiftRegisterActor) Start() {
+	if err := a.driver.set(a.bit, true); err != nil {
+		log.Printf("Unable to start %s : %s", a.id, err.Error())
+		return
+	}
+
+	a.isOn = true
+}
+
+/// Stop - deactivate the pin
+func (a *ShiftRegisterActor) Stop() {
+	if err := a.driver.set(a.bit, false); err != nil {
+		log.Printf("Unable to stop %s : %s", a.id, err.Error())
+		return
+	}
+
+	a.isOn = false
+}
+
+/// ShiftRegisterDriver valves on daisy chained 74HC595 shift registers.
+///  The output state is buffered, every change shifts the whole chain
+///  out and latches it at once
+type ShiftRegisterDriver struct {
+	config *ShiftRegisterConfig
+	open   func(offsets []int, initial []bool) ([]BitBangLine, func() error, error)
+
+	mu     sync.Mutex
+	lines  []BitBangLine
+	close  func() error
+	state  []bool
+	actors []*ShiftRegisterActor
+}
+
+/// NewShiftRegisterDriver the driver for the registers on the lines of the chip
+func NewShiftRegisterDriver(chipPath string, config *ShiftRegisterConfig) *ShiftRegisterDriver {
+	sys := newGpioSys()
+
+	return newShiftRegisterDriver(config, func(offsets []int, initial []bool) ([]BitBangLine, func() error, error) {
+		return openGpiodLines(sys, chipPath, offsets, initial)
+	})
+}
+
+func newShiftRegisterDriver(
+	config *ShiftRegisterConfig,
+	open func(offsets []int, initial []bool) ([]BitBangLine, func() error, error)) *ShiftRegisterDriver {
+	return &ShiftRegisterDriver{
+		config: config,
+		open:   open,
+		state:  make([]bool, 8 * config.Registers),
+	}
+}
+
+/// AvailableActors enumerate the register outputs
+func (d *ShiftRegisterDriver) AvailableActors() []WireActor {
+	result := make([]WireActor, len(d.actors))
+
+	for i, actor := range d.actors {
+		result[i] = actor
+	}
+
+	return result
+}
+
+/// shiftOut clock the chain out from the last bit, so that bit 0 ends up
+///  in the first register, and latch it to the outputs
+func (d *ShiftRegisterDriver) shiftOut() error {
+	data, clock, latch := d.lines[0], d.lines[1], d.lines[2]
+
+	for bit := len(d.state) - 1; bit >= 0; bit-- {
+		if err := data.Set(d.state[bit] != d.config.ActiveLow); err != nil {
+			return err
+		}
+
+		if err := clock.Set(true); err != nil {
+			return err
+		}
+
+		if err := clock.Set(false); err != nil {
+			return err
+		}
+	}
+
+	if err := latch.Set(true); err != nil {
+		return err
+	}
+
+	return latch.Set(false)
+}
+
+func (d *ShiftRegisterDriver) set(bit int, on bool) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	previous := d.state[bit]
+	d.state[bit] = on
+
+	if err := d.shiftOut(); err != nil {
+		d.state[bit] = previous
+		return err
+	}
+
+	return nil
+}
+
+/// Startup latch all the outputs off, then enable the outputs
+func (d *ShiftRegisterDriver) Startup() error {
+	// The outputs stay disabled until the chain is cleared
+	offsets := d.config.lineOffsets()
+	initial := make([]bool, len(offsets))
+
+	if d.config.Enable != nil {
+		initial[3] = true
+	}
+
+	lines, closeLines, err := d.open(offsets, initial)
+
+	if err != nil {
+		return fmt.Errorf("unable to open the shift register lines : %s", err.Error())
+	}
+
+	d.lines = lines
+	d.close = closeLines
+
+	for _, p := range d.config.pins() {
+		d.actors = append(d.actors, &ShiftRegisterActor{
+			id:     p.Id,
+			driver: d,
+			bit:    p.Bit,
+		})
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err = d.shiftOut(); err != nil {
+		return err
+	}
+
+	if d.config.Enable != nil {
+		if err = d.lines[3].Set(false); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("Shift registers : %d, %d pins", d.config.Registers, len(d.actors))
+
+	return nil
+}
+
+/// Shutdown set all the outputs off and disable them
+func (d *ShiftRegisterDriver) Shutdown() {
+	if d.lines == nil {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for i := range d.state {
+		d.state[i] = false
+	}
+
+	if err := d.shiftOut(); err != nil {
+		log.Printf("Unable to clear the shift registers : %s", err.Error())
+	}
+
+	if d.config.Enable != nil {
+		_ = d.lines[3].Set(true)
+	}
+
+	_ = d.close()
+}
+
+/// gpiodLine an output line of a gpiod line request
+type gpiodLine struct {
+	lines *gpiodLines
+	index int
+}
+
+func (l *gpiodLine) Set(high bool) error {
+	return l.lines.set(l.index, high)
+}
+
+/// openGpiodLines request the output lines of the chip for bit banging,
+///  with their initial levels
+func openGpiodLines(sys gpioSys, chipPath string, offsets []int, initial []bool) ([]BitBangLine, func() error, error) {
+	chipFd, err := sys.Open(chipPath)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	defer sys.Close(chipFd)
+
+	req := gpioLineRequest{
+		NumLines: uint32(len(offsets)),
+		Config:   gpioLineConfig{Flags: gpioLineFlagOutput, NumAttrs: 1},
+	}
+
+	copy(req.Consumer[:], gpiodConsumer)
+
+	values := &req.Config.Attrs[0]
+	values.Attr.Id = gpioLineAttrOutputValues
+
+	for i, offset := range offsets {
+		req.Offsets[i] = uint32(offset)
+		values.Mask |= 1 << uint(i)
+
+		if initial[i] {
+			values.Attr.Value |= 1 << uint(i)
+		}
+	}
+
+	if err = sys.Ioctl(chipFd, gpioGetLineIoctl, unsafe.Pointer(&req)); err != nil {
+		return nil, nil, err
+	}
+
+	lines := &gpiodLines{sys: sys, fd: int(req.Fd)}
+	result := make([]BitBangLine, len(offsets))
+
+	for i := range offsets {
+		result[i] = &gpiodLine{lines: lines, index: i}
+	}
+
+	return result, func() error {
+		return sys.Close(lines.fd)
+	}, nil
+}
+
+var _ WireDriver = &ShiftRegisterDriver{}
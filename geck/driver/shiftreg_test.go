@@ -0,0 +1,202 @@
+package driver
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+// fake74HC595 daisy chained registers, clocked by the data, clock,
+//  latch and enable lines
+type fake74HC595 struct {
+	levels  [4]bool
+	shift   []bool
+	outputs []bool
+	fail    bool
+	closed  bool
+}
+
+type fake74HC595Line struct {
+	chip *fake74HC595
+	line int
+}
+
+func (l *fake74HC595Line) Set(high bool) error {
+	c := l.chip
+
+	if c.fail {
+		return fmt.Errorf("line busy")
+	}
+
+	rising := high && !c.levels[l.line]
+	c.levels[l.line] = high
+
+	switch {
+	case l.line == 1 && rising:
+		// Q0 of the first register takes the data, the rest shift along
+		copy(c.shift[1:], c.shift)
+		c.shift[0] = c.levels[0]
+	case l.line == 2 && rising:
+		copy(c.outputs, c.shift)
+	}
+
+	return nil
+}
+
+func (c *fake74HC595) open(t *testing.T, registers int) func([]int, []bool) ([]BitBangLine, func() error, error) {
+	c.shift = make([]bool, 8 * registers)
+	c.outputs = make([]bool, 8 * registers)
+
+	return func(offsets []int, initial []bool) ([]BitBangLine, func() error, error) {
+		require.Equal(t, []int{27, 4, 22, 17}, offsets)
+
+		lines := make([]BitBangLine, len(offsets))
+
+		for i := range offsets {
+			c.levels[i] = initial[i]
+			lines[i] = &fake74HC595Line{chip: c, line: i}
+		}
+
+		return lines, func() error {
+			c.closed = true
+			return nil
+		}, nil
+	}
+}
+
+func (c *fake74HC595) enabled() bool {
+	return !c.levels[3]
+}
+
+func testShiftRegisters() *ShiftRegisterConfig {
+	enable := 17
+
+	return &ShiftRegisterConfig{
+		Data:      27,
+		Clock:     4,
+		Latch:     22,
+		Enable:    &enable,
+		Registers: 2,
+	}
+}
+
+func TestShiftRegisterDriver(t *testing.T) {
+	chip := &fake74HC595{}
+	config := testShiftRegisters()
+	require.NoError(t, config.Validate())
+
+	// Outputs, which were left on, stay disabled until the chain is cleared
+	open := chip.open(t, 2)
+	drv := newShiftRegisterDriver(config, func(offsets []int, initial []bool) ([]BitBangLine, func() error, error) {
+		require.Equal(t, []bool{false, false, false, true}, initial)
+		chip.outputs[3] = true
+		return open(offsets, initial)
+	})
+
+	require.NoError(t, drv.Startup())
+	require.True(t, chip.enabled())
+	require.Equal(t, make([]bool, 16), chip.outputs)
+
+	actors := drv.AvailableActors()
+	require.Len(t, actors, 16)
+	require.Equal(t, "sr0", actors[0].GetID())
+	require.Equal(t, "sr15", actors[15].GetID())
+
+	actors[0].Start()
+	actors[9].Start()
+	require.True(t, actors[9].IsRunning())
+
+	expected := make([]bool, 16)
+	expected[0] = true
+	expected[9] = true
+	require.Equal(t, expected, chip.outputs)
+
+	actors[0].Stop()
+	require.False(t, actors[0].IsRunning())
+	expected[0] = false
+	require.Equal(t, expected, chip.outputs)
+
+	// A failed write keeps the pin state
+	chip.fail = true
+	actors[1].Start()
+	require.False(t, actors[1].IsRunning())
+	chip.fail = false
+
+	actors[2].Start()
+	expected[2] = true
+	require.Equal(t, expected, chip.outputs)
+
+	drv.Shutdown()
+	require.Equal(t, make([]bool, 16), chip.outputs)
+	require.False(t, chip.enabled())
+	require.True(t, chip.closed)
+}
+
+func TestShiftRegisterActiveLow(t *testing.T) {
+	chip := &fake74HC595{}
+	config := testShiftRegisters()
+	config.ActiveLow = true
+	config.Pins = []ShiftRegisterPin{{ Id: "zone1", Bit: 3 }}
+
+	drv := newShiftRegisterDriver(config, chip.open(t, 2))
+	require.NoError(t, drv.Startup())
+
+	actors := drv.AvailableActors()
+	require.Len(t, actors, 1)
+
+	for _, output := range chip.outputs {
+		require.True(t, output)
+	}
+
+	actors[0].Start()
+	require.False(t, chip.outputs[3])
+	require.True(t, chip.outputs[2])
+
+	drv.Shutdown()
+	require.True(t, chip.outputs[3])
+}
+
+func TestShiftRegisterGpiodLines(t *testing.T) {
+	chip := newFakeGpioChip(32)
+	lines, closeLines, err := openGpiodLines(chip, "/dev/gpiochip0", []int{27, 4, 17}, []bool{false, false, true})
+	require.NoError(t, err)
+
+	// The initial levels are set with the request
+	require.False(t, chip.level(27))
+	require.True(t, chip.level(17))
+
+	require.NoError(t, lines[0].Set(true))
+	require.NoError(t, lines[2].Set(false))
+	require.True(t, chip.level(27))
+	require.False(t, chip.level(17))
+
+	require.NoError(t, closeLines())
+	require.Empty(t, chip.requests)
+}
+
+func TestShiftRegisterConfigValidate(t *testing.T) {
+	enable := 4
+
+	invalid := []ShiftRegisterConfig{
+		{ Data: 27, Clock: 4, Latch: 22 },
+		{ Data: 27, Clock: 4, Latch: 4, Registers: 1 },
+		{ Data: 27, Clock: 4, Latch: 22, Enable: &enable, Registers: 1 },
+		{ Data: -1, Clock: 4, Latch: 22, Registers: 1 },
+		{ Data: 27, Clock: 4, Latch: 22, Registers: 1, Pins: []ShiftRegisterPin{{ Id: "x", Bit: 8 }} },
+		{ Data: 27, Clock: 4, Latch: 22, Registers: 1, Pins: []ShiftRegisterPin{{ Id: "x" }, { Id: "x", Bit: 1 }} },
+		{ Data: 27, Clock: 4, Latch: 22, Registers: 1, Pins: []ShiftRegisterPin{{ Id: "x" }, { Id: "y" }} },
+	}
+
+	for _, c := range invalid {
+		require.Error(t, c.Validate(), "%+v", c)
+	}
+
+	config, err := LoadShiftRegisterConfig("testdata/shiftregister.json")
+	require.NoError(t, err)
+	require.Equal(t, 17, *config.Enable)
+	require.Len(t, config.pins(), 3)
+	require.Equal(t, 15, config.Pins[2].Bit)
+
+	require.Error(t, checkLinesUnused(&PinMap{Actors: []PinConfig{{ Id: "gpio0", Pin: 17 }}}, config.lineOffsets()))
+	require.NoError(t, checkLinesUnused(DefaultPinMap(DRIVER_PI1_A), []int{2}))
+}
@@ -0,0 +1,481 @@
+package driver
+
+import (
+	"encoding/json"
+	"fmt"
+	"geck/mqtt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+/// MQTTFile name of the MQTT relays config in the data directory
+const MQTTFile = "mqtt.json"
+
+/// TasmotaRelay a relay of a Tasmota device. The topic is the device
+///  topic, relay is the relay number, or 0 for the devices with one relay
+type TasmotaRelay struct {
+	Id    string `json:"id"`
+	Topic string `json:"topic"`
+	Relay int    `json:"relay,omitempty"`
+}
+
+/// MQTTConfig the broker and the relays on it, the broker is host:port
+type MQTTConfig struct {
+	Broker   string         `json:"broker"`
+	ClientId string         `json:"client_id,omitempty"`
+	Username string         `json:"username,omitempty"`
+	Password string         `json:"password,omitempty"`
+	Relays   []TasmotaRelay `json:"relays"`
+}
+
+/// maxTasmotaRelays the POWER1 to POWER32 of a device
+const maxTasmotaRelays = 32
+
+/// Validate check the broker is set and the relays are unique
+func (c *MQTTConfig) Validate() error {
+	if c.Broker == "" {
+		return fmt.Errorf("the mqtt broker is not set")
+	}
+
+	ids := make(map[string]bool)
+	relays := make(map[string]bool)
+
+	for _, r := range c.Relays {
+		if r.Id == "" || ids[r.Id] {
+			return fmt.Errorf("mqtt relay without id or with a duplicate id: %+v", r)
+		}
+
+		if r.Topic == "" || strings.ContainsAny(r.Topic, "+#") {
+			return fmt.Errorf("invalid device topic of %s: %s", r.Id, r.Topic)
+		}
+
+		if r.Relay < 0 || r.Relay > maxTasmotaRelays {
+			return fmt.Errorf("relay number of %s out of range: %d", r.Id, r.Relay)
+		}
+
+		key := r.Topic + "/" + r.powerKey()
+
+		if relays[key] {
+			return fmt.Errorf("relay is used twice: %+v", r)
+		}
+
+		ids[r.Id] = true
+		relays[key] = true
+	}
+
+	return nil
+}
+
+/// LoadMQTTConfig read the broker and the relays, nil if the file does not exist
+func LoadMQTTConfig(path string) (*MQTTConfig, error) {
+	data, err := ioutil.ReadFile(path)
+
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var result MQTTConfig
+
+	if err = json.Unmarshal(data, &result); err != nil {
+		return nil, fmt.Errorf("invalid mqtt config %s: %s", path, err.Error())
+	}
+
+	if err = result.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid mqtt config %s: %s", path, err.Error())
+	}
+
+	return &result, nil
+}
+
+/// address the broker with the default port
+func (c *MQTTConfig) address() string {
+	address := c.Broker
+
+	for _, scheme := range []string{"tcp://", "mqtt://"} {
+		address = strings.TrimPrefix(address, scheme)
+	}
+
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, "1883")
+	}
+
+	return address
+}
+
+/// powerKey the command and the state key, POWER1 is POWER on the
+///  devices with one relay
+func (r *TasmotaRelay) powerKey() string {
+	if r.Relay <= 1 {
+		return "POWER"
+	}
+
+	return fmt.Sprintf("POWER%d", r.Relay)
+}
+
+func (r *TasmotaRelay) matches(key string) bool {
+	return key == r.powerKey() || r.Relay <= 1 && key == "POWER1"
+}
+
+/// MQTTActor a relay switched over MQTT. It's running only once the
+///  device confirms the relay is on
+type MQTTActor struct {
+	relay  TasmotaRelay
+	driver *MQTTDriver
+
+	// guarded by the driver
+	desired   bool
+	confirmed bool
+}
+
+/// GetID get pin name
+func (a *MQTTActor) GetID() string {
+	return a.relay.Id
+}
+
+/// IsRunning the relay state last reported by the device
+func (a *MQTTActor) IsRunning() bool {
+	a.driver.mu.Lock()
+	defer a.driver.mu.Unlock()
+
+	return a.confirmed
+}
+
+/// IsPending the relay was switched, but the device did not report it yet
+func (a *MQTTActor) IsPending() bool {
+	a.driver.mu.Lock()
+	defer a.driver.mu.Unlock()
+
+	return a.desired != a.confirmed
+}
+
+/// Start - switch the relay on
+func (a *MQTTActor) Start() {
+	a.driver.switchRelay(a, true)
+}
+
+/// Stop - switch the relay off
+func (a *MQTTActor) Stop() {
+	a.driver.switchRelay(a, false)
+}
+
+/// MQTTDriver relays of Tasmota devices, e.g. ESP8266 relay boards,
+///  which are switched with cmnd/<topic>/POWER<n>. The state comes from
+///  stat/<topic>/POWER<n>, stat/<topic>/RESULT and tele/<topic>/STATE.
+///  The commands are sent again, when the broker or the device reconnects
+type MQTTDriver struct {
+	config *MQTTConfig
+	retry  time.Duration
+
+	mu     sync.Mutex
+	client *mqtt.Client
+	actors []*MQTTActor
+
+	// serializes the commands of the switches
+	switchMu sync.Mutex
+
+	started bool
+	stopC   chan struct{}
+	doneC   chan struct{}
+}
+
+/// NewMQTTDriver the driver for the relays of the config
+func NewMQTTDriver(config *MQTTConfig) *MQTTDriver {
+	d := &MQTTDriver{
+		config: config,
+		retry:  5 * time.Second,
+		stopC:  make(chan struct{}),
+		doneC:  make(chan struct{}),
+	}
+
+	for _, r := range config.Relays {
+		d.actors = append(d.actors, &MQTTActor{relay: r, driver: d})
+	}
+
+	return d
+}
+
+/// AvailableActors enumerate the relays
+func (d *MQTTDriver) AvailableActors() []WireActor {
+	result := make([]WireActor, len(d.actors))
+
+	for i, actor := range d.actors {
+		result[i] = actor
+	}
+
+	return result
+}
+
+func payload(on bool) []byte {
+	if on {
+		return []byte("ON")
+	}
+
+	return []byte("OFF")
+}
+
+/// command publish the relay state
+func (d *MQTTDriver) command(client *mqtt.Client, a *MQTTActor, on bool) error {
+	return client.Publish(mqtt.Message{
+		Topic:   "cmnd/" + a.relay.Topic + "/" + a.relay.powerKey(),
+		Payload: payload(on),
+	})
+}
+
+/// switchRelay publish the command outside the mutex, so a slow broker
+///  does not hold up the state reports. The switches are still sent in order
+func (d *MQTTDriver) switchRelay(a *MQTTActor, on bool) {
+	d.switchMu.Lock()
+	defer d.switchMu.Unlock()
+
+	d.mu.Lock()
+	a.desired = on
+	client := d.client
+	d.mu.Unlock()
+
+	if client == nil {
+		log.Printf("Unable to switch %s : not connected to the mqtt broker, sent on reconnect", a.relay.Id)
+		return
+	}
+
+	if err := d.command(client, a, on); err != nil {
+		log.Printf("Unable to switch %s : %s", a.relay.Id, err.Error())
+	}
+}
+
+/// Startup connect to the broker in the background, all the relays are
+///  switched off once it's connected
+func (d *MQTTDriver) Startup() error {
+	d.started = true
+	go d.run()
+	return nil
+}
+
+func (d *MQTTDriver) run() {
+	defer close(d.doneC)
+
+	for {
+		client, err := d.connect()
+
+		if err != nil {
+			log.Printf("Unable to connect to the mqtt broker %s : %s", d.config.Broker, err.Error())
+		} else {
+			select {
+			case <-d.stopC:
+				d.disconnect(client)
+				return
+			case <-client.Done():
+				log.Printf("Lost the mqtt broker %s : %s", d.config.Broker, client.Err().Error())
+			}
+
+			d.mu.Lock()
+			d.client = nil
+			d.mu.Unlock()
+		}
+
+		select {
+		case <-d.stopC:
+			return
+		case <-time.After(d.retry):
+		}
+	}
+}
+
+func (d *MQTTDriver) connect() (*mqtt.Client, error) {
+	clientId := d.config.ClientId
+
+	if clientId == "" {
+		clientId = "geck"
+	}
+
+	client, err := mqtt.Connect(mqtt.Options{
+		Address:   d.config.address(),
+		ClientId:  clientId,
+		Username:  d.config.Username,
+		Password:  d.config.Password,
+		OnMessage: d.handle,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	var filters []string
+	topics := make(map[string]bool)
+
+	for _, a := range d.actors {
+		if !topics[a.relay.Topic] {
+			filters = append(filters, "stat/" + a.relay.Topic + "/+", "tele/" + a.relay.Topic + "/+")
+			topics[a.relay.Topic] = true
+		}
+	}
+
+	if len(filters) > 0 {
+		if err = client.Subscribe(filters...); err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+
+	d.switchMu.Lock()
+	defer d.switchMu.Unlock()
+
+	if err = d.resend(client, d.actors); err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	d.mu.Lock()
+	d.client = client
+	d.mu.Unlock()
+
+	log.Printf("MQTT broker %s : %d relays", d.config.Broker, len(d.actors))
+
+	return client, nil
+}
+
+/// resend send the desired states of the relays again. The caller holds
+///  the switch mutex, the commands are published outside the mutex
+func (d *MQTTDriver) resend(client *mqtt.Client, actors []*MQTTActor) error {
+	d.mu.Lock()
+	states := make([]bool, len(actors))
+
+	for i, a := range actors {
+		states[i] = a.desired
+	}
+
+	d.mu.Unlock()
+
+	for i, a := range actors {
+		if err := d.command(client, a, states[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/// disconnect switch all the relays off and close the connection
+func (d *MQTTDriver) disconnect(client *mqtt.Client) {
+	d.switchMu.Lock()
+	defer d.switchMu.Unlock()
+
+	d.mu.Lock()
+
+	for _, a := range d.actors {
+		a.desired = false
+	}
+
+	d.client = nil
+	d.mu.Unlock()
+
+	for _, a := range d.actors {
+		if err := d.command(client, a, false); err != nil {
+			log.Printf("Unable to switch %s : %s", a.relay.Id, err.Error())
+		}
+	}
+
+	client.Close()
+}
+
+/// handle the state reports of the devices
+func (d *MQTTDriver) handle(msg mqtt.Message) {
+	first, last := strings.Index(msg.Topic, "/"), strings.LastIndex(msg.Topic, "/")
+
+	if first < 0 || first == last {
+		return
+	}
+
+	prefix, topic, suffix := msg.Topic[:first], msg.Topic[first + 1:last], msg.Topic[last + 1:]
+	text := strings.ToUpper(strings.TrimSpace(string(msg.Payload)))
+
+	var restarted []*MQTTActor
+
+	d.mu.Lock()
+	client := d.client
+
+	defer func() {
+		d.mu.Unlock()
+
+		if len(restarted) > 0 && client != nil {
+			// Not on the read loop of the client, and in order with the switches
+			go func() {
+				d.switchMu.Lock()
+				defer d.switchMu.Unlock()
+
+				if err := d.resend(client, restarted); err != nil {
+					log.Printf("Unable to resend to %s : %s", topic, err.Error())
+				}
+			}()
+		}
+	}()
+
+	switch {
+	case prefix == "stat" && strings.HasPrefix(suffix, "POWER"):
+		d.report(topic, suffix, text)
+
+	case prefix == "stat" && suffix == "RESULT", prefix == "tele" && suffix == "STATE":
+		var state map[string]interface{}
+
+		if err := json.Unmarshal(msg.Payload, &state); err != nil {
+			return
+		}
+
+		for key, value := range state {
+			if text, ok := value.(string); ok && strings.HasPrefix(key, "POWER") {
+				d.report(topic, key, strings.ToUpper(text))
+			}
+		}
+
+	case prefix == "tele" && suffix == "LWT":
+		for _, a := range d.actors {
+			if a.relay.Topic != topic {
+				continue
+			}
+
+			if text == "OFFLINE" {
+				a.confirmed = false
+			} else if text == "ONLINE" {
+				// The device may have restarted with other relay states
+				restarted = append(restarted, a)
+			}
+		}
+
+		log.Printf("Tasmota %s : %s", topic, string(msg.Payload))
+	}
+}
+
+/// report the relay state, the caller holds the mutex
+func (d *MQTTDriver) report(topic string, key string, state string) {
+	if state != "ON" && state != "OFF" {
+		return
+	}
+
+	for _, a := range d.actors {
+		if a.relay.Topic == topic && a.relay.matches(key) {
+			a.confirmed = state == "ON"
+		}
+	}
+}
+
+/// Shutdown switch all the relays off and disconnect
+func (d *MQTTDriver) Shutdown() {
+	if !d.started {
+		return
+	}
+
+	d.started = false
+	close(d.stopC)
+	<-d.doneC
+}
+
+var _ WireDriver = &MQTTDriver{}
+var _ ConfirmedActor = &MQTTActor{}
@@ -0,0 +1,180 @@
+package driver
+
+import (
+	"fmt"
+	"geck/mqtt"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeTasmota a device with two relays, which answers the commands
+//  with the stat/<topic>/POWER<n> reports
+type fakeTasmota struct {
+	t      *testing.T
+	client *mqtt.Client
+
+	mu       sync.Mutex
+	relays   [3]bool
+	commands []string
+}
+
+func newFakeTasmota(t *testing.T, broker *mqtt.Broker) *fakeTasmota {
+	device := &fakeTasmota{t: t}
+	client, err := mqtt.Connect(mqtt.Options{
+		Address:   broker.Addr(),
+		ClientId:  "tasmota_yard",
+		OnMessage: device.handle,
+	})
+	require.NoError(t, err)
+	require.NoError(t, client.Subscribe("cmnd/#"))
+
+	device.client = client
+	return device
+}
+
+func (d *fakeTasmota) handle(msg mqtt.Message) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.commands = append(d.commands, msg.Topic + " " + string(msg.Payload))
+
+	if !strings.HasPrefix(msg.Topic, "cmnd/yard/POWER") {
+		return
+	}
+
+	relay := 1
+	fmt.Sscanf(strings.TrimPrefix(msg.Topic, "cmnd/yard/POWER"), "%d", &relay)
+	d.relays[relay] = string(msg.Payload) == "ON"
+
+	// Not from the reading goroutine, the broker may be gone by then
+	go d.client.Publish(mqtt.Message{Topic: fmt.Sprintf("stat/yard/POWER%d", relay), Payload: msg.Payload})
+}
+
+func (d *fakeTasmota) publish(topic string, payload string) {
+	require.NoError(d.t, d.client.Publish(mqtt.Message{Topic: topic, Payload: []byte(payload)}))
+}
+
+func (d *fakeTasmota) relay(n int) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.relays[n]
+}
+
+func (d *fakeTasmota) received(command string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, c := range d.commands {
+		if c == command {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (d *fakeTasmota) reset() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.commands = nil
+}
+
+func eventually(t *testing.T, condition func() bool) {
+	require.Eventually(t, condition, 2 * time.Second, 5 * time.Millisecond)
+}
+
+func TestMQTTDriver(t *testing.T) {
+	broker, err := mqtt.NewBroker("127.0.0.1:0")
+	require.NoError(t, err)
+	defer broker.Close()
+
+	device := newFakeTasmota(t, broker)
+
+	config := &MQTTConfig{
+		Broker: "tcp://" + broker.Addr(),
+		Relays: []TasmotaRelay{
+			{ Id: "yard1", Topic: "yard", Relay: 1 },
+			{ Id: "yard2", Topic: "yard", Relay: 2 },
+			{ Id: "gate", Topic: "gate" },
+		},
+	}
+	require.NoError(t, config.Validate())
+
+	drv := NewMQTTDriver(config)
+	drv.retry = 10 * time.Millisecond
+	require.NoError(t, drv.Startup())
+
+	actors := drv.AvailableActors()
+	require.Len(t, actors, 3)
+	require.Equal(t, "yard2", actors[1].GetID())
+
+	// All the relays are switched off on connect
+	eventually(t, func() bool {
+		return device.received("cmnd/yard/POWER OFF") && device.received("cmnd/yard/POWER2 OFF") &&
+			device.received("cmnd/gate/POWER OFF")
+	})
+
+	// Running once the device confirms it
+	actors[1].Start()
+	eventually(t, actors[1].IsRunning)
+	require.True(t, device.relay(2))
+	require.False(t, actors[0].IsRunning())
+
+	// Without a device there is no confirmation
+	actors[2].Start()
+	eventually(t, func() bool { return device.received("cmnd/gate/POWER ON") })
+	require.False(t, actors[2].IsRunning())
+	require.True(t, actors[2].(ConfirmedActor).IsPending())
+	require.False(t, actors[1].(ConfirmedActor).IsPending())
+	actors[2].Stop()
+
+	// The relays are switched at the device too
+	device.publish("tele/yard/STATE", `{"Time":"2026-10-17T06:00:00","POWER1":"ON","POWER2":"OFF"}`)
+	eventually(t, actors[0].IsRunning)
+	eventually(t, func() bool { return !actors[1].IsRunning() })
+
+	// The commanded states are sent again on reconnect
+	device.reset()
+	broker.Disconnect("geck")
+	eventually(t, func() bool { return device.received("cmnd/yard/POWER2 ON") })
+	eventually(t, func() bool { return actors[1].IsRunning() && !actors[0].IsRunning() })
+
+	device.publish("stat/yard/RESULT", `{"POWER2":"OFF"}`)
+	eventually(t, func() bool { return !actors[1].IsRunning() })
+
+	// and when the device comes back
+	device.publish("tele/yard/LWT", "Offline")
+	device.reset()
+	device.publish("tele/yard/LWT", "Online")
+	eventually(t, func() bool { return device.received("cmnd/yard/POWER2 ON") })
+	eventually(t, actors[1].IsRunning)
+
+	device.reset()
+	drv.Shutdown()
+	eventually(t, func() bool { return device.received("cmnd/yard/POWER2 OFF") && !device.relay(2) })
+}
+
+func TestMQTTConfigValidate(t *testing.T) {
+	invalid := []MQTTConfig{
+		{ Relays: []TasmotaRelay{{ Id: "x", Topic: "yard" }} },
+		{ Broker: "mqtt", Relays: []TasmotaRelay{{ Id: "x", Topic: "yard/#" }} },
+		{ Broker: "mqtt", Relays: []TasmotaRelay{{ Id: "x", Topic: "yard", Relay: 33 }} },
+		{ Broker: "mqtt", Relays: []TasmotaRelay{{ Id: "x", Topic: "yard" }, { Id: "x", Topic: "gate" }} },
+		{ Broker: "mqtt", Relays: []TasmotaRelay{{ Id: "x", Topic: "yard" }, { Id: "y", Topic: "yard", Relay: 1 }} },
+	}
+
+	for _, c := range invalid {
+		require.Error(t, c.Validate(), "%+v", c)
+	}
+
+	config, err := LoadMQTTConfig("testdata/mqtt.json")
+	require.NoError(t, err)
+	require.Len(t, config.Relays, 2)
+	require.Equal(t, "192.168.1.20:1883", config.address())
+	require.Equal(t, "POWER2", config.Relays[1].powerKey())
+}
@@ -6,23 +6,19 @@ import (
 	"geck/driver"
 	"geck/model"
 	"geck/registry"
+	"geck/weather"
 	"geck/web"
 	"log"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 )
 
 func main() {
 	log.SetFlags(log.Ldate|log.Ltime|log.Lmicroseconds)
 	log.SetOutput(os.Stderr)
 
-	ioDriver, err := driver.CreateIODriver()
-
-	if err != nil {
-		log.Printf("error : %s", err.Error())
-	}
-
 	var dataDirectory string
 	var webDataFile string
 
@@ -32,11 +28,60 @@ func main() {
 	flag.StringVar(&webDataFile, "web-data", "./garden-webdata.tar.gz",
 		"Tar file with web data")
 
+	var weatherFile string
+	var weatherUrl string
+	var rainSkip weather.RainSkipPolicy
+
+	flag.StringVar(&weatherFile, "weather-file", "",
+		"Json file with precipitation readings for the rain skip")
+
+	flag.StringVar(&weatherUrl, "weather-url", "",
+		"Http service with precipitation readings for the rain skip")
+
+	flag.Float64Var(&rainSkip.Threshold, "rain-threshold", 5,
+		"Precipitation in mm, which skips scheduled runs")
+
+	flag.DurationVar(&rainSkip.Observed, "rain-observed", 24 * time.Hour,
+		"Window before the run for observed precipitation")
+
+	flag.DurationVar(&rainSkip.Forecast, "rain-forecast", 12 * time.Hour,
+		"Window after the run for forecast precipitation")
+
+	var ioConfig driver.IOConfig
+
+	flag.StringVar(&ioConfig.Kind, "io-driver", driver.DriverAuto,
+		"Valve driver: auto, rpio, gpiod or test")
+
+	flag.StringVar(&ioConfig.GpioChip, "gpio-chip", "/dev/gpiochip0",
+		"GPIO character device for the gpiod driver")
+
 	flag.Parse()
 
+	ioConfig.DataDirectory = dataDirectory
+	ioDriver, err := driver.CreateIODriver(ioConfig)
+
+	if err != nil {
+		log.Fatalf("IO driver error : %s", err.Error())
+	}
+
 	services := registry.NewServiceRegistry()
 	storage := model.NewDirectoryStorageDriver(dataDirectory)
 	gc := controller.NewGardenController(ioDriver, storage)
+
+	var weatherSource weather.ReportSource
+
+	if weatherUrl != "" {
+		weatherSource = weather.NewHttpProvider(weatherUrl)
+	} else if weatherFile != "" {
+		weatherSource = weather.NewFileProvider(weatherFile)
+	}
+
+	if weatherSource != nil {
+		// The lanes ask for the weather, they must not wait for the source
+		rainSkip.Provider = weather.NewCachedProvider(weatherSource)
+		gc.SetRainSkip(&rainSkip)
+		gc.SetWeatherProvider(rainSkip.Provider)
+	}
 	webData := web.NewTarMap(webDataFile, "/var/tmp/geck/web")
 	api := controller.NewGardenAPI(gc, webData)
 
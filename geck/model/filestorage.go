@@ -16,7 +16,9 @@ import (
 
 
 type staticConfigFile struct {
-	Zones []*ZoneInfoStatic `json:"zones"`
+	Zones    []*ZoneInfoStatic `json:"zones"`
+	Settings *GardenSettings   `json:"settings,omitempty"`
+	Programs []*Program        `json:"programs,omitempty"`
 }
 
 type getZonesContext struct {
@@ -42,6 +44,33 @@ type addHistoryContext struct {
 	history *ZoneRun
 }
 
+type getAlertsContext struct {
+	start  time.Time
+	end    time.Time
+}
+
+type addAlertContext struct {
+	alert *Alert
+}
+
+type getSettingsContext struct {
+}
+
+type saveSettingsContext struct {
+	settings *GardenSettings
+}
+
+type getProgramsContext struct {
+}
+
+type saveProgramContext struct {
+	program *Program
+}
+
+type deleteProgramContext struct {
+	id string
+}
+
 
 type QueryContextBase struct {
 	ctx     context.Context
@@ -147,6 +176,40 @@ func (fsd *DirectoryStorageDriver) ProcessRequest(request *QueryContextBase) {
 		if request.err = fsd.doAddHistoryItem(query); request.err == nil {
 			request.result <- struct{}{}
 		}
+	case getSettingsContext:
+		var result *GardenSettings
+
+		if result, request.err = fsd.doLoadSettings(); request.err == nil {
+			request.result <- result
+		}
+	case saveSettingsContext:
+		if request.err = fsd.doSaveSettings(query); request.err == nil {
+			request.result <- struct{}{}
+		}
+	case getProgramsContext:
+		var result []*Program
+
+		if result, request.err = fsd.doLoadPrograms(); request.err == nil {
+			request.result <- result
+		}
+	case saveProgramContext:
+		if request.err = fsd.doSaveProgram(query); request.err == nil {
+			request.result <- struct{}{}
+		}
+	case deleteProgramContext:
+		if request.err = fsd.doDeleteProgram(query); request.err == nil {
+			request.result <- struct{}{}
+		}
+	case getAlertsContext:
+		var result []*Alert
+
+		if result, request.err = fsd.doGetAlerts(query); request.err == nil {
+			request.result <- result
+		}
+	case addAlertContext:
+		if request.err = fsd.doAddAlert(query); request.err == nil {
+			request.result <- struct{}{}
+		}
 	case getZonesContext:
 		var result []*ZoneInfo
 
@@ -273,7 +336,137 @@ func (fsd *DirectoryStorageDriver) doLoadZones() ([]*ZoneInfo, error) {
 	return zoneResult, nil
 }
 
-func (fsd *DirectoryStorageDriver) GetHistory(start time.Time, end time.Time) ([]ZoneRun, error) {
+/// LoadSettings load the controller wide settings
+func (fsd *DirectoryStorageDriver) LoadSettings() (*GardenSettings, error) {
+	result, err := fsd.doQuery(getSettingsContext{})
+
+	if err != nil {
+		return nil, fmt.Errorf("request error : %s", err.Error())
+	}
+
+	if castResult, ok := result.(*GardenSettings); ok {
+		return castResult, nil
+	}
+
+	return nil, fmt.Errorf("invalid response : %+v", result)
+}
+
+func (fsd *DirectoryStorageDriver) doLoadSettings() (*GardenSettings, error) {
+	if err := fsd.loadFromFile(); err != nil {
+		return nil, err
+	}
+
+	result := &GardenSettings{}
+
+	if fsd.zoneStaticConfig.Settings != nil {
+		*result = *fsd.zoneStaticConfig.Settings
+	}
+
+	return result, nil
+}
+
+/// SaveSettings update the controller wide settings
+func (fsd *DirectoryStorageDriver) SaveSettings(settings *GardenSettings) error {
+	if _, err := fsd.doQuery(saveSettingsContext{settings: settings}); err != nil {
+		return fmt.Errorf("request error : %s", err.Error())
+	}
+
+	return nil
+}
+
+func (fsd *DirectoryStorageDriver) doSaveSettings(ctx saveSettingsContext) error {
+	settings := *ctx.settings
+	fsd.zoneStaticConfig.Settings = &settings
+
+	return fsd.saveJsonToFile(fsd.zoneStaticConfig, zoneStaticFile)
+}
+
+/// LoadPrograms load the programs
+func (fsd *DirectoryStorageDriver) LoadPrograms() ([]*Program, error) {
+	result, err := fsd.doQuery(getProgramsContext{})
+
+	if err != nil {
+		return nil, fmt.Errorf("request error : %s", err.Error())
+	}
+
+	if castResult, ok := result.([]*Program); ok {
+		return castResult, nil
+	}
+
+	return nil, fmt.Errorf("invalid response : %+v", result)
+}
+
+func (fsd *DirectoryStorageDriver) doLoadPrograms() ([]*Program, error) {
+	if err := fsd.loadFromFile(); err != nil {
+		return nil, err
+	}
+
+	result := make([]*Program, len(fsd.zoneStaticConfig.Programs))
+
+	for i, program := range fsd.zoneStaticConfig.Programs {
+		p := *program
+		result[i] = &p
+	}
+
+	return result, nil
+}
+
+/// SaveProgram add or replace the program with the same id
+func (fsd *DirectoryStorageDriver) SaveProgram(program *Program) error {
+	if _, err := fsd.doQuery(saveProgramContext{program: program}); err != nil {
+		return fmt.Errorf("request error : %s", err.Error())
+	}
+
+	return nil
+}
+
+func (fsd *DirectoryStorageDriver) doSaveProgram(ctx saveProgramContext) error {
+	program := *ctx.program
+	programs := fsd.zoneStaticConfig.Programs
+	found := false
+
+	for i, p := range programs {
+		if p.Id == program.Id {
+			programs[i] = &program
+			found = true
+		}
+	}
+
+	if !found {
+		fsd.zoneStaticConfig.Programs = append(programs, &program)
+	}
+
+	return fsd.saveJsonToFile(fsd.zoneStaticConfig, zoneStaticFile)
+}
+
+/// DeleteProgram remove the program
+func (fsd *DirectoryStorageDriver) DeleteProgram(id string) error {
+	if _, err := fsd.doQuery(deleteProgramContext{id: id}); err != nil {
+		return fmt.Errorf("request error : %s", err.Error())
+	}
+
+	return nil
+}
+
+func (fsd *DirectoryStorageDriver) doDeleteProgram(ctx deleteProgramContext) error {
+	programs := make([]*Program, 0, len(fsd.zoneStaticConfig.Programs))
+
+	for _, p := range fsd.zoneStaticConfig.Programs {
+		if p.Id != ctx.id {
+			programs = append(programs, p)
+		}
+	}
+
+	if len(programs) == len(fsd.zoneStaticConfig.Programs) {
+		return fmt.Errorf("program not found: %s", ctx.id)
+	}
+
+	fsd.zoneStaticConfig.Programs = programs
+
+	return fsd.saveJsonToFile(fsd.zoneStaticConfig, zoneStaticFile)
+}
+
+func (fsd *DirectoryStorageDriver) GetHistory(start time.Time, end time.Time) ([]*ZoneRun, error) {
 	result, err := fsd.doQuery(getHistoryContext{
 		start:  start,
 		end:    end,
@@ -283,16 +476,103 @@ func (fsd *DirectoryStorageDriver) GetHistory(start time.Time, end time.Time) ([
 		return nil, fmt.Errorf("request error : %s", err.Error())
 	}
 
-	if castResult, ok := result.([]ZoneRun); ok {
+	if castResult, ok := result.([]*ZoneRun); ok {
 		return castResult, nil
 	}
 
 	return nil, fmt.Errorf("invalid response : %+v", result)
 }
 
+const historyFile = "history.csv"
+
+/// parseHistoryRecord decodes a single history line. Older files only
+///  have the first three columns, so the rest are optional
+func parseHistoryRecord(x []string) (*ZoneRun, error) {
+	if len(x) < 3 {
+		return nil, fmt.Errorf("invalid history record : %+v", x)
+	}
+
+	record := ZoneRun{ Id: x[0] }
+
+	if err := record.Started.UnmarshalText([]byte(x[1])); err != nil {
+		return nil, err
+	}
+
+	dur, err := strconv.ParseInt(x[2], 10, 64)
+
+	if err != nil {
+		return nil, err
+	}
+
+	record.Duration = time.Duration(dur)
+
+	if len(x) > 3 {
+		record.Lane = x[3]
+	}
+
+	if len(x) > 4 && x[4] != "" {
+		if record.Volume, err = strconv.ParseFloat(x[4], 64); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(x) > 5 && x[5] != "" {
+		if record.Measured, err = strconv.ParseBool(x[5]); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(x) > 6 {
+		record.Skipped = x[6]
+	}
+
+	if len(x) > 7 {
+		record.RunId = x[7]
+	}
+
+	if len(x) > 9 {
+		record.Interrupted = x[9]
+	}
+
+	if len(x) > 10 {
+		record.Program = x[10]
+	}
+
+	if len(x) > 8 && x[8] != "" {
+		if record.Cycle, err = strconv.Atoi(x[8]); err != nil {
+			return nil, err
+		}
+	}
+
+	return &record, nil
+}
+
+/// formatHistoryRecord encodes a single history line
+func formatHistoryRecord(zone *ZoneRun) []string {
+	startTime, _ := zone.Started.MarshalText()
+
+	return []string{
+		zone.Id,
+		string(startTime),
+		strconv.FormatInt(int64(zone.Duration), 10),
+		zone.Lane,
+		strconv.FormatFloat(zone.Volume, 'f', -1, 64),
+		strconv.FormatBool(zone.Measured),
+		zone.Skipped,
+		zone.RunId,
+		strconv.Itoa(zone.Cycle),
+		zone.Interrupted,
+		zone.Program,
+	}
+}
 
 func (fsd *DirectoryStorageDriver) doGetHistory(ctx getHistoryContext) ([]*ZoneRun, error) {
-	f, err := os.Open(path.Join(fsd.FilePath, "history.csv"))
+	f, err := os.Open(path.Join(fsd.FilePath, historyFile))
+
+	if os.IsNotExist(err) {
+		// Nothing has run yet
+		return []*ZoneRun{}, nil
+	}
 
 	if err != nil {
 		return nil, err
@@ -302,6 +582,7 @@ func (fsd *DirectoryStorageDriver) doGetHistory(ctx getHistoryContext) ([]*ZoneR
 
 	reader := csv.NewReader(f)
 	reader.ReuseRecord = true
+	reader.FieldsPerRecord = -1
 
 	result := make([]*ZoneRun, 0, 1024)
 
@@ -316,22 +597,14 @@ func (fsd *DirectoryStorageDriver) doGetHistory(ctx getHistoryContext) ([]*ZoneR
 			return nil, err
 		}
 
-		record := ZoneRun{ Id: x[0] }
-
-		if err = record.Started.UnmarshalText([]byte(x[1])); err != nil {
-			return nil, err
-		}
-
-		dur, err := strconv.ParseInt(x[2], 10, 64);
+		record, err := parseHistoryRecord(x)
 
 		if err != nil {
 			return nil, err
 		}
 
-		record.Duration = time.Duration(dur)
-
-		if record.Started.After(ctx.start) && record.Started.Before(ctx.end) {
-			result = append(result, &record)
+		if !record.Started.Before(ctx.start) && record.Started.Before(ctx.end) {
+			result = append(result, record)
 		}
 	}
 }
@@ -347,8 +620,8 @@ func (fsd *DirectoryStorageDriver) AddHistoryItem(zoneRun *ZoneRun) error {
 
 func (fsd *DirectoryStorageDriver) doAddHistoryItem(ctx addHistoryContext) error {
 	f, err := os.OpenFile(
-		path.Join(fsd.FilePath, "history.csv"),
-		os.O_APPEND|os.O_CREATE,
+		path.Join(fsd.FilePath, historyFile),
+		os.O_WRONLY|os.O_APPEND|os.O_CREATE,
 		0666,
 	)
 
@@ -358,17 +631,15 @@ func (fsd *DirectoryStorageDriver) doAddHistoryItem(ctx addHistoryContext) error
 
 	defer f.Close()
 
-	zone := ctx.history
-	startTime, _ := zone.Started.MarshalText()
+	writer := csv.NewWriter(f)
 
-	err = csv.
-		NewWriter(f).
-		Write([]string{
-			zone.Id,
-			string(startTime),
-			strconv.FormatInt(int64(zone.Duration), 10)})
+	if err = writer.Write(formatHistoryRecord(ctx.history)); err != nil {
+		return err
+	}
 
-	if err != nil {
+	writer.Flush()
+
+	if err = writer.Error(); err != nil {
 		return err
 	}
 
@@ -377,6 +648,117 @@ func (fsd *DirectoryStorageDriver) doAddHistoryItem(ctx addHistoryContext) error
 	return err
 }
 
+const alertsFile = "alerts.csv"
+
+func (fsd *DirectoryStorageDriver) GetAlerts(start time.Time, end time.Time) ([]*Alert, error) {
+	result, err := fsd.doQuery(getAlertsContext{
+		start:  start,
+		end:    end,
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("request error : %s", err.Error())
+	}
+
+	if castResult, ok := result.([]*Alert); ok {
+		return castResult, nil
+	}
+
+	return nil, fmt.Errorf("invalid response : %+v", result)
+}
+
+func (fsd *DirectoryStorageDriver) doGetAlerts(ctx getAlertsContext) ([]*Alert, error) {
+	f, err := os.Open(path.Join(fsd.FilePath, alertsFile))
+
+	if os.IsNotExist(err) {
+		return []*Alert{}, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = 5
+
+	result := make([]*Alert, 0, 16)
+
+	for {
+		x, err := reader.Read()
+
+		if err == io.EOF {
+			return result, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		alert := Alert{
+			Kind:    x[1],
+			ZoneId:  x[2],
+			Sensor:  x[3],
+			Message: x[4],
+		}
+
+		if err = alert.Time.UnmarshalText([]byte(x[0])); err != nil {
+			return nil, err
+		}
+
+		if !alert.Time.Before(ctx.start) && alert.Time.Before(ctx.end) {
+			result = append(result, &alert)
+		}
+	}
+}
+
+func (fsd *DirectoryStorageDriver) AddAlert(alert *Alert) error {
+	_, err := fsd.doQuery(addAlertContext{
+		alert: alert,
+	})
+
+	return err
+}
+
+func (fsd *DirectoryStorageDriver) doAddAlert(ctx addAlertContext) error {
+	f, err := os.OpenFile(
+		path.Join(fsd.FilePath, alertsFile),
+		os.O_WRONLY|os.O_APPEND|os.O_CREATE,
+		0666,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	alert := ctx.alert
+	alertTime, _ := alert.Time.MarshalText()
+	writer := csv.NewWriter(f)
+
+	err = writer.Write([]string{
+		string(alertTime),
+		alert.Kind,
+		alert.ZoneId,
+		alert.Sensor,
+		alert.Message,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	writer.Flush()
+
+	if err = writer.Error(); err != nil {
+		return err
+	}
+
+	return f.Sync()
+}
+
 func (fsd *DirectoryStorageDriver) loadFromFile() error {
 	data, err := ioutil.ReadFile(path.Join(fsd.FilePath, zoneStaticFile))
 
@@ -384,6 +766,9 @@ func (fsd *DirectoryStorageDriver) loadFromFile() error {
 		return err
 	}
 
+	fsd.zoneStaticConfig.Settings = nil
+	fsd.zoneStaticConfig.Programs = nil
+
 	if err = json.Unmarshal(data, &fsd.zoneStaticConfig); err != nil {
 		return err
 	}
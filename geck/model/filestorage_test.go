@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"github.com/stretchr/testify/require"
 	"io"
+	"io/ioutil"
 	"os"
 	"testing"
 	"time"
@@ -69,4 +70,112 @@ func TestNewDirectoryStorageDriver(t *testing.T) {
 		zone: &list[0].ZoneInfoStatic,
 	})
 	require.NoError(t, err)
-}
\ No newline at end of file
+}
+
+func TestDirectoryStorageDriverHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "geck-history")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	driver := NewDirectoryStorageDriver(dir)
+
+	list, err := driver.doGetHistory(getHistoryContext{
+		start: time.Time{},
+		end:   time.Now(),
+	})
+	require.NoError(t, err)
+	require.Empty(t, list)
+
+	base := time.Date(2021, 5, 23, 10, 0, 0, 0, time.UTC)
+
+	for i, id := range []string{"roses", "lawn", "roses"} {
+		err = driver.doAddHistoryItem(addHistoryContext{
+			history: &ZoneRun{
+				Id:       id,
+				Started:  base.Add(time.Duration(i) * time.Hour),
+				Duration: 5 * time.Minute,
+				Lane:     "0",
+				Volume:   12.5,
+				Measured: true,
+				RunId:    id + "-1",
+				Cycle:    2,
+
+				Interrupted: PreemptResume,
+			},
+		})
+		require.NoError(t, err)
+	}
+
+	// Legacy records do not have the lane column
+	f, err := os.OpenFile(dir + "/history.csv", os.O_WRONLY|os.O_APPEND, 0666)
+	require.NoError(t, err)
+	_, err = f.WriteString("lawn,2021-05-23T14:00:00Z,60000000000\n")
+	require.NoError(t, err)
+	f.Close()
+
+	list, err = driver.doGetHistory(getHistoryContext{
+		start: base.Add(time.Hour),
+		end:   base.Add(24 * time.Hour),
+	})
+	require.NoError(t, err)
+	require.Len(t, list, 3)
+
+	require.Equal(t, "lawn", list[0].Id)
+	require.Equal(t, "0", list[0].Lane)
+	require.Equal(t, 5 * time.Minute, list[0].Duration)
+	require.Equal(t, 12.5, list[0].Volume)
+	require.True(t, list[0].Measured)
+	require.Equal(t, "lawn-1", list[0].RunId)
+	require.Equal(t, 2, list[0].Cycle)
+	require.Equal(t, PreemptResume, list[0].Interrupted)
+	require.True(t, base.Add(time.Hour).Equal(list[0].Started))
+
+	require.Equal(t, "lawn", list[2].Id)
+	require.Equal(t, "", list[2].Lane)
+	require.Equal(t, time.Minute, list[2].Duration)
+	require.False(t, list[2].Measured)
+	require.Equal(t, "", list[2].Skipped)
+	require.Equal(t, "", list[2].Interrupted)
+}
+
+func TestDirectoryStorageDriverPrograms(t *testing.T) {
+	dir, err := ioutil.TempDir("", "geck-programs")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	require.NoError(t, ioutil.WriteFile(dir + "/zones.conf.json", []byte(`{"zones": []}`), 0644))
+
+	driver := NewDirectoryStorageDriver(dir)
+
+	programs, err := driver.doLoadPrograms()
+	require.NoError(t, err)
+	require.Empty(t, programs)
+
+	front := &Program{
+		Id:        "front",
+		IsEnabled: true,
+		Steps: []*ProgramStep{
+			{ ZoneId: "roses", Duration: 5 * time.Minute },
+			{ ZoneId: "lawn", Duration: 10 * time.Minute },
+		},
+	}
+
+	require.NoError(t, driver.doSaveProgram(saveProgramContext{program: front}))
+	require.NoError(t, driver.doSaveProgram(saveProgramContext{program: &Program{Id: "back"}}))
+
+	front.Name = "Front yard"
+	require.NoError(t, driver.doSaveProgram(saveProgramContext{program: front}))
+
+	programs, err = driver.doLoadPrograms()
+	require.NoError(t, err)
+	require.Len(t, programs, 2)
+	require.Equal(t, "Front yard", programs[0].Name)
+	require.Equal(t, "lawn", programs[0].Steps[1].ZoneId)
+
+	require.NoError(t, driver.doDeleteProgram(deleteProgramContext{id: "back"}))
+	require.Error(t, driver.doDeleteProgram(deleteProgramContext{id: "back"}))
+
+	programs, err = driver.doLoadPrograms()
+	require.NoError(t, err)
+	require.Len(t, programs, 1)
+}
@@ -1,25 +1,331 @@
 package model
 
-import "time"
+import (
+	"fmt"
+	"time"
+)
 
 /// ZoneRun a public representation of run history
 type ZoneRun struct {
 	Id       string 		`json:"id"`
 	Started  time.Time      `json:"started"`
 	Duration time.Duration  `json:"for"`
+	Lane     string         `json:"lane,omitempty"`
+	Volume   float64        `json:"volume"`
+
+	// Volume comes from a flow meter rather than the nominal flow rate
+	Measured bool           `json:"measured,omitempty"`
+
+	// Reason the scheduled run did not water
+	Skipped  string         `json:"skipped,omitempty"`
+
+	// Originating run and the cycle number, for the runs split with soaks
+	RunId    string         `json:"run_id,omitempty"`
+	Cycle    int            `json:"cycle,omitempty"`
+
+	// Run was stopped by a manual start, and what happened to the rest of it
+	Interrupted string      `json:"interrupted,omitempty"`
+
+	// Program, which started the run
+	Program  string         `json:"program,omitempty"`
 }
 
 /// ZoneScheduleSpec a public representation of Schedule
 type ZoneScheduleSpec struct {
 	Idx        int            `json:"index"`
 	Duration   time.Duration  `json:"for"`
+
+	// weekly (default), interval, odd or even
+	Type       string         `json:"type,omitempty"`
 	DaysOfWeek []time.Weekday `json:"days"`
 
+	// Every Interval days starting from StartDate (2006-01-02)
+	Interval   int            `json:"interval,omitempty"`
+	StartDate  string         `json:"start_date,omitempty"`
+
+	// Start at the sunrise or sunset plus the offset instead of h:m.
+	//  With finish the run ends at that time instead
+	Anchor     string         `json:"anchor,omitempty"`
+	Offset     time.Duration  `json:"offset,omitempty"`
+	Finish     bool           `json:"finish,omitempty"`
+
+	// Active season, month and day (01-02) both inclusive
+	SeasonStart string        `json:"season_start,omitempty"`
+	SeasonEnd   string        `json:"season_end,omitempty"`
+
 	Hours      uint8  `json:"h"`
 	Minutes    uint8  `json:"m"`
 	AtTimeZone string `json:"tz"`
 }
 
+/// Validate check the finish by is used with an anchor
+func (s *ZoneScheduleSpec) Validate() error {
+	if s.Finish && s.Anchor == "" {
+		return fmt.Errorf("finish requires a sunrise or sunset anchor: %+v", *s)
+	}
+
+	return nil
+}
+
+/// WaterBudget scales the scheduled durations, in percent. The monthly
+///  table (January to December) takes precedence over the flat percent,
+///  zero entries mean not set
+type WaterBudget struct {
+	Percent int   `json:"percent,omitempty"`
+	Monthly []int `json:"monthly,omitempty"`
+}
+
+/// PercentFor returns the budget for the month, if it's set
+func (b *WaterBudget) PercentFor(month time.Month) (int, bool) {
+	if b == nil {
+		return 0, false
+	}
+
+	if len(b.Monthly) == 12 && b.Monthly[month - 1] > 0 {
+		return b.Monthly[month - 1], true
+	}
+
+	if b.Percent > 0 {
+		return b.Percent, true
+	}
+
+	return 0, false
+}
+
+/// Validate check the budget values
+func (b *WaterBudget) Validate() error {
+	if b == nil {
+		return nil
+	}
+
+	if len(b.Monthly) != 0 && len(b.Monthly) != 12 {
+		return fmt.Errorf("monthly budget must have 12 entries: %+v", *b)
+	}
+
+	if b.Percent < 0 {
+		return fmt.Errorf("budget must not be negative: %+v", *b)
+	}
+
+	for _, p := range b.Monthly {
+		if p < 0 {
+			return fmt.Errorf("budget must not be negative: %+v", *b)
+		}
+	}
+
+	return nil
+}
+
+/// SiteLocation geographic location of the garden, in degrees
+type SiteLocation struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+/// Blackout a time when scheduled runs are not allowed, either a one-off
+///  window from start to end, or a daily window of the local time of day
+type Blackout struct {
+	Id     string `json:"id"`
+	Reason string `json:"reason,omitempty"`
+
+	Start  *time.Time `json:"start,omitempty"`
+	End    *time.Time `json:"end,omitempty"`
+
+	// Time of day, 15:04, the window may wrap around midnight
+	From   string `json:"from,omitempty"`
+	To     string `json:"to,omitempty"`
+}
+
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day, expected 15:04: %s", s)
+	}
+
+	return time.Duration(t.Hour()) * time.Hour + time.Duration(t.Minute()) * time.Minute, nil
+}
+
+/// Validate check the blackout is either a one-off or a daily window
+func (b *Blackout) Validate() error {
+	if b.Id == "" {
+		return fmt.Errorf("blackout id is required: %+v", *b)
+	}
+
+	if b.Start != nil || b.End != nil {
+		if b.Start == nil || b.End == nil || !b.End.After(*b.Start) {
+			return fmt.Errorf("blackout must end after it starts: %s", b.Id)
+		}
+
+		return nil
+	}
+
+	if _, err := parseTimeOfDay(b.From); err != nil {
+		return err
+	}
+
+	if _, err := parseTimeOfDay(b.To); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+/// Contains check the time is within the blackout, the daily
+///  window is in the location of t
+func (b *Blackout) Contains(t time.Time) bool {
+	if b.Start != nil && b.End != nil {
+		return !t.Before(*b.Start) && t.Before(*b.End)
+	}
+
+	from, err := parseTimeOfDay(b.From)
+	if err != nil {
+		return false
+	}
+
+	to, err := parseTimeOfDay(b.To)
+	if err != nil {
+		return false
+	}
+
+	tod := time.Duration(t.Hour()) * time.Hour + time.Duration(t.Minute()) * time.Minute
+
+	if from <= to {
+		return tod >= from && tod < to
+	}
+
+	return tod >= from || tod < to
+}
+
+/// Pause suspends the scheduled runs until the given time, manual
+///  starts are still allowed unless they are blocked as well
+type Pause struct {
+	Until       time.Time `json:"until"`
+	Reason      string    `json:"reason,omitempty"`
+	BlockManual bool      `json:"block_manual,omitempty"`
+}
+
+/// Active check the pause has not expired yet
+func (p *Pause) Active(t time.Time) bool {
+	return p != nil && t.Before(p.Until)
+}
+
+/// MasterValve a master valve or a pump relay, which is on whenever a zone
+///  of the lane runs. It goes on Lead before the zone valve opens and off
+///  Lag after the zone valve closes
+type MasterValve struct {
+	HardwareId string        `json:"hw_id"`
+	Lead       time.Duration `json:"lead,omitempty"`
+	Lag        time.Duration `json:"lag,omitempty"`
+}
+
+/// Validate check the master timings
+func (m *MasterValve) Validate() error {
+	if m == nil {
+		return nil
+	}
+
+	if m.HardwareId == "" {
+		return fmt.Errorf("master valve without hardware id: %+v", *m)
+	}
+
+	if m.Lead < 0 || m.Lag < 0 {
+		return fmt.Errorf("master valve lead and lag must not be negative: %+v", *m)
+	}
+
+	return nil
+}
+
+/// GardenSettings a public representation of the controller wide settings
+type GardenSettings struct {
+	Budget    WaterBudget  `json:"budget"`
+	Site      SiteLocation `json:"site"`
+	Blackouts []Blackout   `json:"blackouts,omitempty"`
+
+	// Controller wide and per lane pauses
+	Pause      *Pause            `json:"pause,omitempty"`
+	LanePauses map[string]*Pause `json:"lane_pauses,omitempty"`
+
+	// Controller wide and per lane master valves, the lane one wins
+	Master       *MasterValve            `json:"master,omitempty"`
+	LaneMasters  map[string]*MasterValve `json:"lane_masters,omitempty"`
+}
+
+const (
+	ModeSchedule = "schedule"
+	ModeET       = "et"
+)
+
+/// SoilWaterCapacity available water in mm per mm of root depth
+var SoilWaterCapacity = map[string]float64{
+	"sand":       0.07,
+	"sandy_loam": 0.12,
+	"loam":       0.17,
+	"clay_loam":  0.18,
+	"clay":       0.16,
+}
+
+const defaultAllowedDepletion = 0.5
+
+/// ETSettings soil and plant parameters for evapotranspiration driven watering
+type ETSettings struct {
+	RootDepth        float64 `json:"root_depth_mm"`
+	SoilType         string  `json:"soil"`
+	CropCoefficient  float64 `json:"kc"`
+
+	// Fraction of the available water, which can be used before watering
+	AllowedDepletion float64 `json:"allowed_depletion,omitempty"`
+
+	// Precipitation rate of the sprinklers
+	ApplicationRate  float64 `json:"application_rate_mm_h"`
+}
+
+/// TotalAvailableWater water in mm the root zone holds at field capacity
+func (et *ETSettings) TotalAvailableWater() float64 {
+	return SoilWaterCapacity[et.SoilType] * et.RootDepth
+}
+
+/// ReadilyAvailableWater the deficit in mm, which triggers watering
+func (et *ETSettings) ReadilyAvailableWater() float64 {
+	depletion := et.AllowedDepletion
+
+	if depletion == 0 {
+		depletion = defaultAllowedDepletion
+	}
+
+	return depletion * et.TotalAvailableWater()
+}
+
+/// Validate check the soil and plant parameters
+func (et *ETSettings) Validate() error {
+	if _, ok := SoilWaterCapacity[et.SoilType]; !ok {
+		return fmt.Errorf("unknown soil type: %s", et.SoilType)
+	}
+
+	if et.RootDepth <= 0 || et.CropCoefficient <= 0 || et.ApplicationRate <= 0 {
+		return fmt.Errorf("root depth, crop coefficient and application rate must be positive: %+v", *et)
+	}
+
+	if et.AllowedDepletion < 0 || et.AllowedDepletion > 1 {
+		return fmt.Errorf("allowed depletion must be within 0..1: %+v", *et)
+	}
+
+	return nil
+}
+
+/// Policies for the remaining duration of a preempted run
+const (
+	PreemptDrop    = "drop"
+	PreemptResume  = "resume"
+	PreemptRestart = "restart"
+)
+
+/// Policies for the scheduled runs missed while the controller was off
+const (
+	CatchUpAlways = "always"
+	CatchUpWithin = "within"
+	CatchUpNever  = "never"
+)
+
 /// A structure for a public representation of zone static data
 type ZoneInfoStatic struct {
 	Id      string `json:"id"`
@@ -30,6 +336,34 @@ type ZoneInfoStatic struct {
 	HardwareId string `json:"hw_id"`
 	Lane       string `json:"lane"`
 
+	// Nominal flow in volume units (gallons or litres) per minute
+	FlowRate   float64 `json:"flow_rate,omitempty"`
+
+	// Flow meter measuring this zone and its calibration
+	FlowSensor    string  `json:"flow_sensor,omitempty"`
+	PulsesPerUnit float64 `json:"pulses_per_unit,omitempty"`
+
+	// Zone budget, overrides the global one
+	Budget *WaterBudget `json:"budget,omitempty"`
+
+	// Long runs are split into cycles of at most MaxCycle,
+	//  with at least MinSoak between them
+	MaxCycle time.Duration `json:"max_cycle,omitempty"`
+	MinSoak  time.Duration `json:"min_soak,omitempty"`
+
+	// What to do with the rest of the run, when a manual start stops it
+	OnPreempt string `json:"on_preempt,omitempty"`
+
+	// Whether a scheduled run missed while the controller was off runs late,
+	//  always (default), within CatchUpWindow after its start time or never
+	CatchUp       string        `json:"catch_up,omitempty"`
+	CatchUpWindow time.Duration `json:"catch_up_window,omitempty"`
+
+	// In the et mode the schedule only defines the allowed watering
+	//  windows, the zone waters when the soil moisture deficit is high enough
+	Mode string      `json:"mode,omitempty"`
+	ET   *ETSettings `json:"et,omitempty"`
+
 	Schedule []*ZoneScheduleSpec `json:"schedule"`
 }
 
@@ -39,8 +373,21 @@ type ZoneState struct {
 
 	// Zone is disabled due to error
 	Disabled   bool `json:"disabled"`
+	Fault      string `json:"fault,omitempty"`
+
+	// Why the last scheduled run was skipped
+	SkipReason string `json:"skip_reason,omitempty"`
+
+	// Soil moisture deficit in mm, accounted for the days before DeficitDate
+	Deficit     float64   `json:"deficit_mm"`
+	DeficitDate time.Time `json:"deficit_date"`
 
 	NextRun    *time.Time    `json:"next_run"`
+
+	// Scheduled and budget adjusted durations of the next run
+	NextRunNominal time.Duration `json:"next_run_nominal"`
+	NextRunFor     time.Duration `json:"next_run_for"`
+
 	StartedAt  time.Time     `json:"started_at"`
 	LastRun    time.Time     `json:"last_run"`
 	Runtime    time.Duration `json:"runtime"` // total zone run time
@@ -51,6 +398,67 @@ type ZoneInfo struct {
 	ZoneState
 }
 
+/// VolumeFor estimates water volume for the run duration based on the nominal flow rate
+func (zone *ZoneInfoStatic) VolumeFor(d time.Duration) float64 {
+	return zone.FlowRate * d.Minutes()
+}
+
+const (
+	AlertStartFailed = "start_failed"
+	AlertLeak        = "leak"
+	AlertNoFlow      = "no_flow"
+)
+
+/// Alert a public representation of a fault event
+type Alert struct {
+	Time    time.Time `json:"time"`
+	Kind    string    `json:"kind"`
+	ZoneId  string    `json:"zone_id,omitempty"`
+	Sensor  string    `json:"sensor,omitempty"`
+	Message string    `json:"message"`
+}
+
+/// ProgramStep a zone of the program and how long it runs
+type ProgramStep struct {
+	ZoneId   string        `json:"zone_id"`
+	Duration time.Duration `json:"for"`
+}
+
+/// Program runs its zones back to back in the given order, at every start
+///  of its schedule. The durations of the schedule entries are not used
+type Program struct {
+	Id        string              `json:"id"`
+	Name      string              `json:"name"`
+	IsEnabled bool                `json:"is_on"`
+	Schedule  []*ZoneScheduleSpec `json:"schedule"`
+	Steps     []*ProgramStep      `json:"zones"`
+}
+
+/// Validate check the program is complete
+func (p *Program) Validate() error {
+	if p.Id == "" {
+		return fmt.Errorf("program id is required: %+v", *p)
+	}
+
+	if len(p.Steps) == 0 {
+		return fmt.Errorf("program has no zones: %s", p.Id)
+	}
+
+	for _, step := range p.Steps {
+		if step.ZoneId == "" || step.Duration <= 0 {
+			return fmt.Errorf("program step needs a zone and a positive duration: %s, %+v", p.Id, *step)
+		}
+	}
+
+	for _, sch := range p.Schedule {
+		if err := sch.Validate(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 /// StorageDriver - garden persistence engine
 type StorageDriver interface {
 	LoadZones() ([]*ZoneInfo, error)
@@ -58,6 +466,16 @@ type StorageDriver interface {
 	SaveZone(zone *ZoneInfoStatic) error
 	UpdateZoneState(zoneId string, zone *ZoneState) error
 
-	GetHistory(start time.Time, end time.Time) ([]ZoneRun, error)
+	GetHistory(start time.Time, end time.Time) ([]*ZoneRun, error)
 	AddHistoryItem(ZoneRun * ZoneRun) error
+
+	GetAlerts(start time.Time, end time.Time) ([]*Alert, error)
+	AddAlert(alert *Alert) error
+
+	LoadSettings() (*GardenSettings, error)
+	SaveSettings(settings *GardenSettings) error
+
+	LoadPrograms() ([]*Program, error)
+	SaveProgram(program *Program) error
+	DeleteProgram(id string) error
 }
@@ -0,0 +1,63 @@
+package model
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestWaterBudgetPercentFor(t *testing.T) {
+	var budget *WaterBudget
+
+	_, ok := budget.PercentFor(time.April)
+	require.False(t, ok)
+
+	budget = &WaterBudget{
+		Percent: 80,
+		Monthly: []int{0, 0, 0, 60, 80, 100, 130, 130, 100, 70, 0, 0},
+	}
+
+	p, ok := budget.PercentFor(time.April)
+	require.True(t, ok)
+	require.Equal(t, 60, p)
+
+	p, ok = budget.PercentFor(time.July)
+	require.True(t, ok)
+	require.Equal(t, 130, p)
+
+	// Months without an entry use the flat percent
+	p, ok = budget.PercentFor(time.January)
+	require.True(t, ok)
+	require.Equal(t, 80, p)
+
+	require.NoError(t, budget.Validate())
+	require.Error(t, (&WaterBudget{Monthly: []int{100}}).Validate())
+	require.Error(t, (&WaterBudget{Percent: -10}).Validate())
+}
+
+func TestBlackoutContains(t *testing.T) {
+	day := func(h, m int) time.Time {
+		return time.Date(2021, 10, 20, h, m, 0, 0, time.UTC)
+	}
+
+	daily := Blackout{Id: "ordinance", From: "10:00", To: "18:00"}
+	require.NoError(t, daily.Validate())
+	require.True(t, daily.Contains(day(10, 0)))
+	require.True(t, daily.Contains(day(17, 59)))
+	require.False(t, daily.Contains(day(18, 0)))
+	require.False(t, daily.Contains(day(6, 0)))
+
+	night := Blackout{Id: "night", From: "22:00", To: "05:00"}
+	require.True(t, night.Contains(day(23, 0)))
+	require.True(t, night.Contains(day(4, 59)))
+	require.False(t, night.Contains(day(12, 0)))
+
+	start, end := day(0, 0), day(24, 0)
+	aeration := Blackout{Id: "aeration", Start: &start, End: &end}
+	require.NoError(t, aeration.Validate())
+	require.True(t, aeration.Contains(day(6, 0)))
+	require.False(t, aeration.Contains(end))
+
+	require.Error(t, (&Blackout{Id: "bad", From: "10am", To: "18:00"}).Validate())
+	require.Error(t, (&Blackout{Id: "bad", Start: &end, End: &start}).Validate())
+}
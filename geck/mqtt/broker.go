@@ -0,0 +1,246 @@
+package mqtt
+
+import (
+	"bufio"
+	"net"
+	"sync"
+)
+
+// Broker a minimal in-process broker with QoS 0 and retained messages,
+//  enough for the tests and for running the relays without a server
+type Broker struct {
+	listener net.Listener
+
+	mu       sync.Mutex
+	closed   bool
+	sessions map[*brokerSession]bool
+	retained map[string]Message
+	wg       sync.WaitGroup
+}
+
+type brokerSession struct {
+	conn     net.Conn
+	clientId string
+	filters  []string
+
+	writeMutex sync.Mutex
+}
+
+// NewBroker listen on the address, e.g. 127.0.0.1:0 for any free port
+func NewBroker(address string) (*Broker, error) {
+	listener, err := net.Listen("tcp", address)
+
+	if err != nil {
+		return nil, err
+	}
+
+	b := &Broker{
+		listener: listener,
+		sessions: make(map[*brokerSession]bool),
+		retained: make(map[string]Message),
+	}
+
+	b.wg.Add(1)
+	go b.acceptLoop()
+
+	return b, nil
+}
+
+// Addr the address the broker listens on
+func (b *Broker) Addr() string {
+	return b.listener.Addr().String()
+}
+
+func (b *Broker) acceptLoop() {
+	defer b.wg.Done()
+
+	for {
+		conn, err := b.listener.Accept()
+
+		if err != nil {
+			return
+		}
+
+		s := &brokerSession{conn: conn}
+
+		b.mu.Lock()
+
+		if b.closed {
+			b.mu.Unlock()
+			_ = conn.Close()
+			return
+		}
+
+		b.sessions[s] = true
+		b.wg.Add(1)
+		b.mu.Unlock()
+
+		go b.serve(s)
+	}
+}
+
+func (s *brokerSession) write(p *packet) {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+
+	_, _ = s.conn.Write(p.bytes())
+}
+
+func (b *Broker) serve(s *brokerSession) {
+	defer b.wg.Done()
+	defer s.conn.Close()
+
+	defer func() {
+		b.mu.Lock()
+		delete(b.sessions, s)
+		b.mu.Unlock()
+	}()
+
+	r := bufio.NewReader(s.conn)
+	p, err := readPacket(r)
+
+	if err != nil || p.kind != packetConnect {
+		return
+	}
+
+	body := &reader{b: p.body}
+	body.string()
+	body.byte()
+	body.byte()
+	body.uint16()
+	clientId := body.string()
+
+	if body.err != nil {
+		return
+	}
+
+	b.mu.Lock()
+	s.clientId = clientId
+	b.mu.Unlock()
+
+	s.write(&packet{kind: packetConnAck, body: []byte{0, 0}})
+
+	for {
+		p, err := readPacket(r)
+
+		if err != nil {
+			return
+		}
+
+		switch p.kind {
+		case packetPublish:
+			msg, id, err := parsePublish(p)
+
+			if err != nil {
+				return
+			}
+
+			if id != 0 {
+				s.write(&packet{kind: packetPubAck, body: []byte{byte(id >> 8), byte(id)}})
+			}
+
+			b.Publish(msg)
+
+		case packetSubscribe:
+			if !b.subscribe(s, p) {
+				return
+			}
+
+		case packetPingReq:
+			s.write(&packet{kind: packetPingResp})
+
+		case packetDisconnect:
+			return
+		}
+	}
+}
+
+func (b *Broker) subscribe(s *brokerSession, p *packet) bool {
+	r := &reader{b: p.body}
+	id := r.uint16()
+	ack := []byte{byte(id >> 8), byte(id)}
+
+	var filters []string
+
+	for r.err == nil && len(r.b) > 0 {
+		filters = append(filters, r.string())
+		r.byte()
+		ack = append(ack, 0)
+	}
+
+	if r.err != nil {
+		return false
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	s.filters = append(s.filters, filters...)
+	s.write(&packet{kind: packetSubAck, body: ack})
+
+	for _, msg := range b.retained {
+		for _, filter := range filters {
+			if TopicMatch(filter, msg.Topic) {
+				s.write(publishPacket(msg))
+				break
+			}
+		}
+	}
+
+	return true
+}
+
+// Publish deliver the message to the subscribers, and keep it if it's
+//  retained. A retained message without payload clears the topic
+func (b *Broker) Publish(msg Message) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if msg.Retain {
+		if len(msg.Payload) == 0 {
+			delete(b.retained, msg.Topic)
+		} else {
+			b.retained[msg.Topic] = msg
+		}
+	}
+
+	// Retain is only set on the messages sent on subscription
+	delivered := msg
+	delivered.Retain = false
+
+	for s := range b.sessions {
+		for _, filter := range s.filters {
+			if TopicMatch(filter, msg.Topic) {
+				s.write(publishPacket(delivered))
+				break
+			}
+		}
+	}
+}
+
+// Disconnect drop the connections of the client
+func (b *Broker) Disconnect(clientId string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for s := range b.sessions {
+		if s.clientId == clientId {
+			_ = s.conn.Close()
+		}
+	}
+}
+
+// Close stop listening and drop all the connections
+func (b *Broker) Close() {
+	_ = b.listener.Close()
+
+	b.mu.Lock()
+	b.closed = true
+
+	for s := range b.sessions {
+		_ = s.conn.Close()
+	}
+
+	b.mu.Unlock()
+	b.wg.Wait()
+}
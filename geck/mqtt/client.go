@@ -0,0 +1,278 @@
+package mqtt
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// Options of the connection. The handler is called from the reading
+//  goroutine for every received message, so it must not block
+type Options struct {
+	Address   string
+	ClientId  string
+	Username  string
+	Password  string
+	KeepAlive time.Duration
+	OnMessage func(msg Message)
+
+	// a stuck broker fails the connection, instead of blocking the writers
+	WriteTimeout time.Duration
+}
+
+// Client a minimal MQTT 3.1.1 client, with QoS 0 only. There is no
+//  reconnection, Done is closed once the connection is lost
+type Client struct {
+	options Options
+	conn    net.Conn
+
+	writeMutex sync.Mutex
+	nextId     uint16
+	subAcks    chan uint16
+
+	done    chan struct{}
+	err     error
+	errOnce sync.Once
+}
+
+const dialTimeout = 5 * time.Second
+
+// Connect open the connection and wait for the broker to accept it
+func Connect(options Options) (*Client, error) {
+	if options.KeepAlive == 0 {
+		options.KeepAlive = 30 * time.Second
+	}
+
+	if options.WriteTimeout == 0 {
+		options.WriteTimeout = 5 * time.Second
+	}
+
+	conn, err := net.DialTimeout("tcp", options.Address, dialTimeout)
+
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Client{
+		options: options,
+		conn:    conn,
+		subAcks: make(chan uint16, 1),
+		done:    make(chan struct{}),
+	}
+
+	r := bufio.NewReader(conn)
+
+	if err = c.handshake(r); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	go c.readLoop(r)
+	go c.pingLoop()
+
+	return c, nil
+}
+
+func (c *Client) handshake(r *bufio.Reader) error {
+	flags := byte(0x02) // clean session
+	body := appendString(nil, "MQTT")
+	body = append(body, 4)
+
+	if c.options.Username != "" {
+		flags |= 0x80
+	}
+
+	if c.options.Password != "" {
+		flags |= 0x40
+	}
+
+	keepAlive := uint16(c.options.KeepAlive / time.Second)
+	body = append(body, flags, byte(keepAlive >> 8), byte(keepAlive))
+	body = appendString(body, c.options.ClientId)
+
+	if c.options.Username != "" {
+		body = appendString(body, c.options.Username)
+	}
+
+	if c.options.Password != "" {
+		body = appendString(body, c.options.Password)
+	}
+
+	_ = c.conn.SetDeadline(time.Now().Add(dialTimeout))
+	defer c.conn.SetDeadline(time.Time{})
+
+	if err := c.write(&packet{kind: packetConnect, body: body}); err != nil {
+		return err
+	}
+
+	ack, err := readPacket(r)
+
+	if err != nil {
+		return err
+	}
+
+	if ack.kind != packetConnAck || len(ack.body) != 2 {
+		return fmt.Errorf("unexpected packet instead of connack: %d", ack.kind)
+	}
+
+	if ack.body[1] != 0 {
+		return fmt.Errorf("connection refused by the broker: %d", ack.body[1])
+	}
+
+	return nil
+}
+
+func (c *Client) write(p *packet) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	_ = c.conn.SetWriteDeadline(time.Now().Add(c.options.WriteTimeout))
+	_, err := c.conn.Write(p.bytes())
+
+	if err != nil {
+		// The packet may be sent in part, nothing can follow it
+		c.fail(err)
+	}
+
+	return err
+}
+
+func (c *Client) fail(err error) {
+	c.errOnce.Do(func() {
+		c.err = err
+		_ = c.conn.Close()
+		close(c.done)
+	})
+}
+
+func (c *Client) readLoop(r *bufio.Reader) {
+	for {
+		// The broker answers the pings, so a silent connection is dead
+		_ = c.conn.SetReadDeadline(time.Now().Add(c.options.KeepAlive * 3 / 2))
+
+		p, err := readPacket(r)
+
+		if err != nil {
+			c.fail(err)
+			return
+		}
+
+		switch p.kind {
+		case packetPublish:
+			msg, id, err := parsePublish(p)
+
+			if err != nil {
+				c.fail(err)
+				return
+			}
+
+			if id != 0 {
+				_ = c.write(&packet{kind: packetPubAck, body: []byte{byte(id >> 8), byte(id)}})
+			}
+
+			if c.options.OnMessage != nil {
+				c.options.OnMessage(msg)
+			}
+
+		case packetSubAck:
+			r := &reader{b: p.body}
+			id := r.uint16()
+
+			select {
+			case c.subAcks <- id:
+			default:
+			}
+		}
+	}
+}
+
+func (c *Client) pingLoop() {
+	ticker := time.NewTicker(c.options.KeepAlive / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			if err := c.write(&packet{kind: packetPingReq}); err != nil {
+				c.fail(err)
+				return
+			}
+		}
+	}
+}
+
+// Subscribe to the topic filters and wait for the broker to confirm it
+func (c *Client) Subscribe(filters ...string) error {
+	c.writeMutex.Lock()
+	c.nextId++
+
+	if c.nextId == 0 {
+		c.nextId = 1
+	}
+
+	id := c.nextId
+	c.writeMutex.Unlock()
+
+	body := []byte{byte(id >> 8), byte(id)}
+
+	for _, filter := range filters {
+		body = appendString(body, filter)
+		body = append(body, 0)
+	}
+
+	if err := c.write(&packet{kind: packetSubscribe, flags: 2, body: body}); err != nil {
+		return err
+	}
+
+	timeout := time.NewTimer(dialTimeout)
+	defer timeout.Stop()
+
+	for {
+		select {
+		case ack := <-c.subAcks:
+			if ack == id {
+				return nil
+			}
+		case <-c.done:
+			return c.Err()
+		case <-timeout.C:
+			return fmt.Errorf("no suback from the broker")
+		}
+	}
+}
+
+// Publish the message with QoS 0
+func (c *Client) Publish(msg Message) error {
+	select {
+	case <-c.done:
+		return c.Err()
+	default:
+	}
+
+	return c.write(publishPacket(msg))
+}
+
+// Done closed once the connection is lost or closed
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err the reason the connection was lost
+func (c *Client) Err() error {
+	select {
+	case <-c.done:
+		return c.err
+	default:
+		return nil
+	}
+}
+
+// Close disconnect from the broker
+func (c *Client) Close() {
+	_ = c.write(&packet{kind: packetDisconnect})
+	c.fail(fmt.Errorf("connection closed"))
+}
@@ -0,0 +1,128 @@
+package mqtt
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/stretchr/testify/require"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestPacketLength(t *testing.T) {
+	for _, n := range []int{0, 127, 128, 16383, 16384, 300000} {
+		p := &packet{kind: packetPublish, flags: 1, body: make([]byte, n)}
+		read, err := readPacket(bufio.NewReader(bytes.NewReader(p.bytes())))
+		require.NoError(t, err)
+		require.Equal(t, p, read)
+	}
+
+	// Length of 2 bytes: 321 = 0xc1 0x02
+	require.Equal(t, []byte{0x30, 0xc1, 0x02}, (&packet{kind: packetPublish, body: make([]byte, 321)}).bytes()[:3])
+}
+
+func TestTopicMatch(t *testing.T) {
+	require.True(t, TopicMatch("stat/relay/+", "stat/relay/POWER1"))
+	require.True(t, TopicMatch("stat/#", "stat/relay/POWER1"))
+	require.True(t, TopicMatch("stat/#", "stat"))
+	require.True(t, TopicMatch("+/relay/RESULT", "stat/relay/RESULT"))
+	require.True(t, TopicMatch("tele/relay/LWT", "tele/relay/LWT"))
+	require.False(t, TopicMatch("stat/relay/+", "stat/relay"))
+	require.False(t, TopicMatch("stat/relay/+", "stat/relay/POWER1/x"))
+	require.False(t, TopicMatch("stat/relay", "stat/relay/POWER1"))
+	require.False(t, TopicMatch("tele/relay/LWT", "tele/relay/STATE"))
+}
+
+func TestClientBroker(t *testing.T) {
+	broker, err := NewBroker("127.0.0.1:0")
+	require.NoError(t, err)
+	defer broker.Close()
+
+	received := make(chan Message, 10)
+
+	subscriber, err := Connect(Options{
+		Address:   broker.Addr(),
+		ClientId:  "subscriber",
+		OnMessage: func(msg Message) { received <- msg },
+	})
+	require.NoError(t, err)
+
+	publisher, err := Connect(Options{Address: broker.Addr(), ClientId: "publisher", Username: "user", Password: "secret"})
+	require.NoError(t, err)
+
+	require.NoError(t, publisher.Publish(Message{Topic: "tele/relay/LWT", Payload: []byte("Online"), Retain: true}))
+
+	// The broker handles the packets of a connection in order
+	require.NoError(t, publisher.Subscribe("cmnd/relay/+"))
+	require.NoError(t, subscriber.Subscribe("stat/relay/+", "tele/relay/LWT"))
+
+	// The retained message is sent on subscription
+	msg := <-received
+	require.Equal(t, "tele/relay/LWT", msg.Topic)
+	require.Equal(t, "Online", string(msg.Payload))
+	require.True(t, msg.Retain)
+
+	require.NoError(t, publisher.Publish(Message{Topic: "stat/other/POWER", Payload: []byte("ON")}))
+	require.NoError(t, publisher.Publish(Message{Topic: "stat/relay/POWER", Payload: []byte("ON")}))
+
+	msg = <-received
+	require.Equal(t, "stat/relay/POWER", msg.Topic)
+	require.Equal(t, "ON", string(msg.Payload))
+	require.False(t, msg.Retain)
+
+	broker.Disconnect("subscriber")
+
+	select {
+	case <-subscriber.Done():
+		require.Error(t, subscriber.Err())
+	case <-time.After(time.Second):
+		require.Fail(t, "the connection was not dropped")
+	}
+
+	require.Error(t, subscriber.Publish(Message{Topic: "x"}))
+	publisher.Close()
+	<-publisher.Done()
+}
+
+func TestClientWriteTimeout(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+
+	// The broker accepts the connection and stops reading
+	go func() {
+		conn, err := listener.Accept()
+
+		if err != nil {
+			return
+		}
+
+		defer conn.Close()
+
+		if _, err = readPacket(bufio.NewReader(conn)); err == nil {
+			_, _ = conn.Write((&packet{kind: packetConnAck, body: []byte{0, 0}}).bytes())
+			<-done
+		}
+	}()
+
+	client, err := Connect(Options{Address: listener.Addr().String(), ClientId: "stuck", WriteTimeout: 50 * time.Millisecond})
+	require.NoError(t, err)
+
+	msg := Message{Topic: "cmnd/relay/POWER", Payload: make([]byte, 1 << 16)}
+	deadline := time.Now().Add(2 * time.Second)
+
+	for err == nil && time.Now().Before(deadline) {
+		err = client.Publish(msg)
+	}
+
+	require.Error(t, err)
+
+	select {
+	case <-client.Done():
+	default:
+		require.Fail(t, "the connection was not dropped")
+	}
+}
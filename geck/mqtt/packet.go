@@ -0,0 +1,213 @@
+package mqtt
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Control packet types of MQTT 3.1.1, only QoS 0 is used
+const (
+	packetConnect    = 1
+	packetConnAck    = 2
+	packetPublish    = 3
+	packetPubAck     = 4
+	packetSubscribe  = 8
+	packetSubAck     = 9
+	packetPingReq    = 12
+	packetPingResp   = 13
+	packetDisconnect = 14
+)
+
+// maxPacketSize the largest packet accepted, the relays send short messages
+const maxPacketSize = 1 << 20
+
+type packet struct {
+	kind  byte
+	flags byte
+	body  []byte
+}
+
+// readPacket the fixed header with the variable length, then the rest
+func readPacket(r *bufio.Reader) (*packet, error) {
+	header, err := r.ReadByte()
+
+	if err != nil {
+		return nil, err
+	}
+
+	length := 0
+
+	for shift := uint(0); ; shift += 7 {
+		if shift > 21 {
+			return nil, fmt.Errorf("malformed remaining length")
+		}
+
+		b, err := r.ReadByte()
+
+		if err != nil {
+			return nil, err
+		}
+
+		length |= int(b & 0x7f) << shift
+
+		if b & 0x80 == 0 {
+			break
+		}
+	}
+
+	if length > maxPacketSize {
+		return nil, fmt.Errorf("packet too large: %d", length)
+	}
+
+	result := &packet{kind: header >> 4, flags: header & 0x0f, body: make([]byte, length)}
+
+	if _, err = io.ReadFull(r, result.body); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (p *packet) bytes() []byte {
+	result := []byte{p.kind << 4 | p.flags}
+	length := len(p.body)
+
+	for {
+		b := byte(length & 0x7f)
+		length >>= 7
+
+		if length > 0 {
+			b |= 0x80
+		}
+
+		result = append(result, b)
+
+		if length == 0 {
+			break
+		}
+	}
+
+	return append(result, p.body...)
+}
+
+func appendString(b []byte, s string) []byte {
+	b = append(b, byte(len(s) >> 8), byte(len(s)))
+	return append(b, s...)
+}
+
+// reader of the variable header and the payload
+type reader struct {
+	b   []byte
+	err error
+}
+
+func (r *reader) uint16() uint16 {
+	if r.err != nil || len(r.b) < 2 {
+		r.err = fmt.Errorf("packet too short")
+		return 0
+	}
+
+	result := binary.BigEndian.Uint16(r.b)
+	r.b = r.b[2:]
+
+	return result
+}
+
+func (r *reader) string() string {
+	n := int(r.uint16())
+
+	if r.err != nil || len(r.b) < n {
+		r.err = fmt.Errorf("packet too short")
+		return ""
+	}
+
+	result := string(r.b[:n])
+	r.b = r.b[n:]
+
+	return result
+}
+
+func (r *reader) byte() byte {
+	if r.err != nil || len(r.b) < 1 {
+		r.err = fmt.Errorf("packet too short")
+		return 0
+	}
+
+	result := r.b[0]
+	r.b = r.b[1:]
+
+	return result
+}
+
+// Message an application message
+type Message struct {
+	Topic   string
+	Payload []byte
+	Retain  bool
+}
+
+func publishPacket(msg Message) *packet {
+	result := &packet{kind: packetPublish, body: appendString(nil, msg.Topic)}
+	result.body = append(result.body, msg.Payload...)
+
+	if msg.Retain {
+		result.flags = 1
+	}
+
+	return result
+}
+
+// parsePublish the message and the packet id, which is only set above QoS 0
+func parsePublish(p *packet) (Message, uint16, error) {
+	r := &reader{b: p.body}
+	msg := Message{Topic: r.string(), Retain: p.flags & 1 != 0}
+
+	var id uint16
+
+	if qos := (p.flags >> 1) & 3; qos > 0 {
+		id = r.uint16()
+	}
+
+	if r.err != nil {
+		return msg, 0, r.err
+	}
+
+	msg.Payload = r.b
+
+	return msg, id, nil
+}
+
+// TopicMatch the topic matches the filter with the + and # wildcards
+func TopicMatch(filter string, topic string) bool {
+	for {
+		if filter == "#" {
+			return true
+		}
+
+		fi, ti := indexSlash(filter), indexSlash(topic)
+		level := filter[:fi]
+
+		if level != "+" && level != topic[:ti] {
+			return false
+		}
+
+		if fi == len(filter) || ti == len(topic) {
+			// "a/#" also matches "a"
+			return fi == len(filter) && ti == len(topic) || ti == len(topic) && filter[fi:] == "/#"
+		}
+
+		filter, topic = filter[fi + 1:], topic[ti + 1:]
+	}
+}
+
+func indexSlash(s string) int {
+	for i := 0; i < len(s); i++ {
+		if s[i] == '/' {
+			return i
+		}
+	}
+
+	return len(s)
+}
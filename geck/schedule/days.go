@@ -0,0 +1,243 @@
+package schedule
+
+import (
+	"fmt"
+	"time"
+)
+
+// DayEntry runs on the days matching the filter, at the same time
+// of the day or relative to the sunrise or sunset of that day
+type DayEntry struct {
+	loc  *time.Location
+	data interface{}
+
+	match func(day time.Time) bool
+	at    func(day time.Time) (time.Time, bool)
+
+	// longest gap between two matching days
+	span int
+}
+
+func (e *DayEntry) Len() int {
+	return 1
+}
+
+// GetNearest walks the days around t, so the slots keep their
+// wall clock time across the daylight saving changes, see WallClock
+func (e *DayEntry) GetNearest(t time.Time) (TimeSlot, TimeSlot) {
+	t = t.In(e.loc)
+
+	var ltt, rtt TimeSlot
+
+	for i := -e.span - 1; i <= e.span + 1; i++ {
+		day := time.Date(t.Year(), t.Month(), t.Day() + i, 0, 0, 0, 0, time.UTC)
+
+		if !e.match(day) {
+			continue
+		}
+
+		st, ok := e.at(day)
+
+		if !ok {
+			continue
+		}
+
+		if st.After(t) {
+			if rtt.Time.IsZero() {
+				rtt = TimeSlot{Time: st, Data: e.data}
+			}
+		} else {
+			ltt = TimeSlot{Time: st, Data: e.data}
+		}
+	}
+
+	return ltt, rtt
+}
+
+// civilDays number of calendar days since the epoch, for a date in UTC
+func civilDays(day time.Time) int {
+	return int(day.Unix() / (24 * 60 * 60))
+}
+
+// NewDayEntry builds an interval or odd/even days entry from the spec
+func NewDayEntry(spec Spec) (*DayEntry, error) {
+	loc, err := time.LoadLocation(spec.AtTimeZone)
+
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &DayEntry{
+		loc:  loc,
+		data: spec.Data,
+	}
+
+	if entry.at, err = slotTime(spec, loc); err != nil {
+		return nil, err
+	}
+
+	switch spec.Type {
+	case "", SpecWeekly:
+		if len(spec.DaysOfWeek) == 0 {
+			return nil, fmt.Errorf("no days specified")
+		}
+
+		days := make(map[time.Weekday]bool)
+
+		for _, dow := range spec.DaysOfWeek {
+			days[dow] = true
+		}
+
+		entry.span = 7
+		entry.match = func(day time.Time) bool {
+			return days[day.Weekday()]
+		}
+	case SpecInterval:
+		if spec.Interval <= 0 {
+			return nil, fmt.Errorf("interval must be positive: %d", spec.Interval)
+		}
+
+		start := time.Unix(0, 0)
+
+		if spec.StartDate != "" {
+			if start, err = time.Parse("2006-01-02", spec.StartDate); err != nil {
+				return nil, err
+			}
+		}
+
+		first := civilDays(start)
+		interval := spec.Interval
+
+		entry.span = interval
+		entry.match = func(day time.Time) bool {
+			n := (civilDays(day) - first) % interval
+			return n == 0
+		}
+	case SpecOdd, SpecEven:
+		// The 31st and the 1st are both odd, the 28th of February
+		//  and the 2nd of March are the closest even days
+		parity := 0
+
+		if spec.Type == SpecOdd {
+			parity = 1
+		}
+
+		entry.span = 3
+		entry.match = func(day time.Time) bool {
+			return day.Day() % 2 == parity
+		}
+	default:
+		return nil, fmt.Errorf("unknown schedule type: %s", spec.Type)
+	}
+
+	return entry, nil
+}
+
+// slotTime the time of the slot on the day, which is a date in UTC
+func slotTime(spec Spec, loc *time.Location) (func(day time.Time) (time.Time, bool), error) {
+	switch spec.Anchor {
+	case "":
+		return func(day time.Time) (time.Time, bool) {
+			return WallClock(day.Year(), day.Month(), day.Day(),
+				int(spec.Hours), int(spec.Minutes), loc), true
+		}, nil
+	case SunriseAnchor, SunsetAnchor:
+		return func(day time.Time) (time.Time, bool) {
+			sunrise, sunset, ok := SunTimes(day, spec.Latitude, spec.Longitude)
+
+			if spec.Anchor == SunsetAnchor {
+				sunrise = sunset
+			}
+
+			return sunrise.Add(spec.Offset).Truncate(time.Minute).In(loc), ok
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unknown schedule anchor: %s", spec.Anchor)
+}
+
+// Schedule combines the weekly times with the other entries
+type Schedule struct {
+	weekly  WeeklySchedule
+	entries []Entry
+}
+
+// AddSpec adds the weekly times or a new entry for the other spec types,
+//  for the sun relative times and for the seasonal specs
+func (s *Schedule) AddSpec(spec Spec) error {
+	weekly := (spec.Type == "" || spec.Type == SpecWeekly) && spec.Anchor == ""
+
+	if weekly && spec.SeasonStart == "" && spec.SeasonEnd == "" {
+		return s.weekly.AddSpec(spec)
+	}
+
+	var entry Entry
+
+	if weekly {
+		w := &WeeklySchedule{}
+
+		if err := w.AddSpec(spec); err != nil {
+			return err
+		}
+
+		entry = w
+	} else {
+		e, err := NewDayEntry(spec)
+
+		if err != nil {
+			return err
+		}
+
+		entry = e
+	}
+
+	if spec.SeasonStart != "" || spec.SeasonEnd != "" {
+		season, err := NewSeasonEntry(entry, spec)
+
+		if err != nil {
+			return err
+		}
+
+		entry = season
+	}
+
+	s.entries = append(s.entries, entry)
+
+	return nil
+}
+
+func (s *Schedule) Len() int {
+	result := s.weekly.Len()
+
+	for _, entry := range s.entries {
+		result += entry.Len()
+	}
+
+	return result
+}
+
+// GetNearest the latest time to the left and the earliest to the right among all entries
+func (s *Schedule) GetNearest(t time.Time) (TimeSlot, TimeSlot) {
+	var ltt, rtt TimeSlot
+
+	if s.weekly.Len() > 0 {
+		ltt, rtt = s.weekly.GetNearest(t)
+	}
+
+	for _, entry := range s.entries {
+		lt, rt := entry.GetNearest(t)
+
+		if !lt.Time.IsZero() && (ltt.Time.IsZero() || lt.Time.After(ltt.Time)) {
+			ltt = lt
+		}
+
+		if !rt.Time.IsZero() && (rtt.Time.IsZero() || rt.Time.Before(rtt.Time)) {
+			rtt = rt
+		}
+	}
+
+	return ltt, rtt
+}
+
+var _ Entry = &DayEntry{}
+var _ Entry = &Schedule{}
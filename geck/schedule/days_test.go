@@ -0,0 +1,92 @@
+package schedule
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestDayEntryGetNearest(t *testing.T) {
+	utc := time.UTC
+
+	tests := []struct {
+		name   string
+		spec   Spec
+		at     time.Time
+		left   time.Time
+		right  time.Time
+	}{
+		{
+			name:  "every 3 days, before the slot",
+			spec:  Spec{Type: SpecInterval, Interval: 3, StartDate: "2021-05-01", Hours: 6},
+			at:    time.Date(2021, 5, 7, 5, 0, 0, 0, utc),
+			left:  time.Date(2021, 5, 4, 6, 0, 0, 0, utc),
+			right: time.Date(2021, 5, 7, 6, 0, 0, 0, utc),
+		},
+		{
+			name:  "every 3 days, at the slot",
+			spec:  Spec{Type: SpecInterval, Interval: 3, StartDate: "2021-05-01", Hours: 6},
+			at:    time.Date(2021, 5, 7, 6, 0, 0, 0, utc),
+			left:  time.Date(2021, 5, 7, 6, 0, 0, 0, utc),
+			right: time.Date(2021, 5, 10, 6, 0, 0, 0, utc),
+		},
+		{
+			name:  "every 2 days, before the start date",
+			spec:  Spec{Type: SpecInterval, Interval: 2, StartDate: "2021-05-10", Hours: 6},
+			at:    time.Date(2021, 5, 1, 12, 0, 0, 0, utc),
+			left:  time.Date(2021, 4, 30, 6, 0, 0, 0, utc),
+			right: time.Date(2021, 5, 2, 6, 0, 0, 0, utc),
+		},
+		{
+			name:  "odd days across the 31st",
+			spec:  Spec{Type: SpecOdd, Hours: 5, Minutes: 30},
+			at:    time.Date(2021, 5, 31, 12, 0, 0, 0, utc),
+			left:  time.Date(2021, 5, 31, 5, 30, 0, 0, utc),
+			right: time.Date(2021, 6, 1, 5, 30, 0, 0, utc),
+		},
+		{
+			name:  "even days across February",
+			spec:  Spec{Type: SpecEven, Hours: 5},
+			at:    time.Date(2021, 3, 1, 12, 0, 0, 0, utc),
+			left:  time.Date(2021, 2, 28, 5, 0, 0, 0, utc),
+			right: time.Date(2021, 3, 2, 5, 0, 0, 0, utc),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			spec := test.spec
+			spec.AtTimeZone = "UTC"
+
+			entry, err := NewDayEntry(spec)
+			require.NoError(t, err)
+
+			lt, rt := entry.GetNearest(test.at)
+			require.True(t, test.left.Equal(lt.Time), "left %s", lt.Time)
+			require.True(t, test.right.Equal(rt.Time), "right %s", rt.Time)
+		})
+	}
+}
+
+func TestDayEntryKeepsWallClockAcrossDST(t *testing.T) {
+	entry, err := NewDayEntry(Spec{Type: SpecOdd, Hours: 6, AtTimeZone: "America/Los_Angeles"})
+	require.NoError(t, err)
+
+	// Daylight saving starts on the 14th of March 2021
+	_, rt := entry.GetNearest(time.Date(2021, 3, 14, 12, 0, 0, 0, time.UTC))
+	require.Equal(t, 15, rt.Time.Day())
+	require.Equal(t, 6, rt.Time.Hour())
+}
+
+func TestScheduleCombinesEntries(t *testing.T) {
+	var s Schedule
+
+	require.NoError(t, s.AddSpec(Spec{DaysOfWeek: []time.Weekday{time.Monday}, Hours: 7, AtTimeZone: "UTC"}))
+	require.NoError(t, s.AddSpec(Spec{Type: SpecEven, Hours: 5, AtTimeZone: "UTC"}))
+	require.Error(t, s.AddSpec(Spec{Type: SpecInterval, AtTimeZone: "UTC"}))
+
+	// Sunday the 2nd of May 2021
+	lt, rt := s.GetNearest(time.Date(2021, 5, 2, 12, 0, 0, 0, time.UTC))
+	require.True(t, time.Date(2021, 5, 2, 5, 0, 0, 0, time.UTC).Equal(lt.Time))
+	require.True(t, time.Date(2021, 5, 3, 7, 0, 0, 0, time.UTC).Equal(rt.Time))
+}
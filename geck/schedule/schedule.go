@@ -6,20 +6,50 @@ import (
 	"time"
 )
 
+// Entry a source of scheduled times. GetNearest returns the nearest
+// scheduled time to the left (not after t) and to the right (after t)
 type Entry interface {
-	GetNearest(t time.Time) (time.Time, time.Time)
+	GetNearest(t time.Time) (TimeSlot, TimeSlot)
+	Len() int
 }
 
+const (
+	SpecWeekly   = "weekly"
+	SpecInterval = "interval"
+	SpecOdd      = "odd"
+	SpecEven     = "even"
+)
+
 type Spec struct {
+	// Weekly by default
+	Type       string
 	DaysOfWeek []time.Weekday
+
+	// Every Interval days counting from StartDate (2006-01-02)
+	Interval   int
+	StartDate  string
+
 	Hours      uint8
 	Minutes    uint8
 	AtTimeZone string
 	Data	   interface{}
+
+	// Sunrise or sunset instead of the hours and minutes, with
+	//  an offset, at the site latitude and longitude
+	Anchor     string
+	Offset     time.Duration
+	Latitude   float64
+	Longitude  float64
+
+	// Active season as month and day (01-02), both inclusive. The
+	//  season may wrap around the new year, e.g. 11-01 to 02-28
+	SeasonStart string
+	SeasonEnd   string
 }
 
 type slot struct {
-	offset time.Duration
+	offset time.Duration // nominal wall clock offset from Monday 00:00
+	loc    *time.Location
 	data   interface{}
 }
 
@@ -28,9 +58,11 @@ type TimeSlot struct {
 	Data interface{}
 }
 
+// WeeklySchedule times on the days of the week. The slots are wall clock
+// times of the day in their location, see WallClock for the daylight
+// saving transitions
 type WeeklySchedule struct {
-	loc   *time.Location
-	times []slot // offsets from the beginning of Monday
+	times []slot
 }
 
 func (w * WeeklySchedule) Len() int {
@@ -52,11 +84,6 @@ func (w *WeeklySchedule) addSchedule(offsets []slot) {
 
 func (w *WeeklySchedule) AddSpec(spec Spec) error {
 	loc, err := time.LoadLocation(spec.AtTimeZone)
-	if w.loc != nil && w.loc != loc {
-		return fmt.Errorf("all timezones must be the same for one schedule")
-	}
-
-	w.loc = loc
 
 	if err != nil {
 		return err
@@ -69,12 +96,13 @@ func (w *WeeklySchedule) AddSpec(spec Spec) error {
 	times := make([]slot, len(spec.DaysOfWeek))
 	tw := time.Hour * time.Duration(spec.Hours) + time.Minute * time.Duration(spec.Minutes)
 
-	// Convert to absolute times from the beginning of the week
+	// Nominal offsets from the beginning of the week
 	for i, dow := range spec.DaysOfWeek {
 		d := (int(dow) - int(time.Monday) + 7) % 7
 
 		times[i] = slot{
 			offset: time.Duration(d) * 24 * time.Hour + tw,
+			loc:    loc,
 			data:   spec.Data,
 		}
 	}
@@ -84,60 +112,82 @@ func (w *WeeklySchedule) AddSpec(spec Spec) error {
 	return nil
 }
 
-const week = time.Hour * 24 * 7
+// WallClock returns the time of the wall clock reading in the location.
+// A reading skipped by a daylight saving change (spring forward) happens
+// as much later, as the clock moved forward, e.g. 02:30 becomes 03:30.
+// A repeated reading (fall back) happens once, at its first occurrence
+func WallClock(year int, month time.Month, day, hour, min int, loc *time.Location) time.Time {
+	wall := time.Date(year, month, day, hour, min, 0, 0, time.UTC)
+
+	// Transitions are far less than a day apart from each other
+	_, before := wall.Add(-24 * time.Hour).In(loc).Zone()
+	_, after := wall.Add(24 * time.Hour).In(loc).Zone()
+
+	var result time.Time
+
+	for _, offset := range []int{before, after} {
+		t := wall.Add(-time.Duration(offset) * time.Second)
+
+		if _, actual := t.In(loc).Zone(); actual != offset {
+			continue
+		}
+
+		if result.IsZero() || t.Before(result) {
+			result = t
+		}
+	}
+
+	if result.IsZero() {
+		// Skipped reading, use the offset from before the change
+		result = wall.Add(-time.Duration(before) * time.Second)
+	}
+
+	return result.In(loc)
+}
 
 // GetCurrentWeekStart returns the time truncated
 // to the beginning of the nearest Monday (to the left)
 // with respect to the timezone
 func GetCurrentWeekStart(t time.Time) time.Time {
-	_, offset := t.Zone()
-	truncTime := t.Truncate(week).Unix() - int64(offset)
-	return time.Unix(truncTime, 0).In(t.Location())
+	d := (int(t.Weekday()) - int(time.Monday) + 7) % 7
+	return WallClock(t.Year(), t.Month(), t.Day() - d, 0, 0, t.Location())
 }
 
-func (s * slot) ToTimeSlot(base time.Time) TimeSlot {
+// at the slot time in the week, which starts on the given Monday
+func (s *slot) at(monday time.Time) TimeSlot {
+	wall := monday.Add(s.offset)
+
 	return TimeSlot{
-		Time: base.Add(s.offset),
+		Time: WallClock(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(), s.loc),
 		Data: s.data,
 	}
 }
 
 // GetNearest returns the nearest scheduled time to
 // the left and nearest scheduled time to the right
-func getNearestToArray(t time.Time, offsets []slot) (TimeSlot, TimeSlot) {
-	start := GetCurrentWeekStart(t)
-
-	i := sort.Search(len(offsets), func (i int) bool {
-		return start.Add(offsets[i].offset).After(t)
-	})
-
+func (w * WeeklySchedule) GetNearest(t time.Time) (TimeSlot, TimeSlot) {
 	var ltt, rtt TimeSlot
 
-	if len(offsets) == 0 {
-		return ltt, rtt
-	}
-
-	if i == 0 {
-		ltt = offsets[len(offsets) - 1].ToTimeSlot(start.Add(-week))
-	} else {
-		ltt = offsets[i - 1].ToTimeSlot(start)
-	}
-
-	if i == len(offsets) {
-		rtt = offsets[0].ToTimeSlot(start.Add(week))
-	} else {
-		rtt = offsets[i].ToTimeSlot(start)
+	for _, s := range w.times {
+		// Calendar date of the Monday in the slot location
+		lt := t.In(s.loc)
+		d := (int(lt.Weekday()) - int(time.Monday) + 7) % 7
+		monday := time.Date(lt.Year(), lt.Month(), lt.Day() - d, 0, 0, 0, 0, time.UTC)
+
+		for week := -1; week <= 1; week++ {
+			st := s.at(monday.AddDate(0, 0, 7 * week))
+
+			if st.Time.After(t) {
+				if rtt.Time.IsZero() || st.Time.Before(rtt.Time) {
+					rtt = st
+				}
+			} else if ltt.Time.IsZero() || st.Time.After(ltt.Time) {
+				ltt = st
+			}
+		}
 	}
 
 	return ltt, rtt
 }
 
-// GetNearest returns the nearest scheduled time to
-// the left and nearest scheduled time to the right
-func (w * WeeklySchedule) GetNearest(t time.Time) (TimeSlot, TimeSlot) {
-	if w.loc == nil {
-		w.loc = time.Local
-	}
-
-	return getNearestToArray(t.In(w.loc), w.times)
-}
+var _ Entry = &WeeklySchedule{}
@@ -0,0 +1,178 @@
+package schedule
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func mustLoad(t *testing.T, name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	require.NoError(t, err)
+	return loc
+}
+
+func utc(year int, month time.Month, day, hour, min int) time.Time {
+	return time.Date(year, month, day, hour, min, 0, 0, time.UTC)
+}
+
+func TestWallClock(t *testing.T) {
+	tests := []struct {
+		name     string
+		zone     string
+		wall     time.Time // wall clock reading, in UTC for convenience
+		expected time.Time
+	}{
+		{"los angeles, regular", "America/Los_Angeles", utc(2021, 3, 13, 2, 30), utc(2021, 3, 13, 10, 30)},
+		{"los angeles, spring forward", "America/Los_Angeles", utc(2021, 3, 14, 2, 30), utc(2021, 3, 14, 10, 30)},
+		{"los angeles, after spring forward", "America/Los_Angeles", utc(2021, 3, 14, 3, 30), utc(2021, 3, 14, 10, 30)},
+		{"los angeles, fall back", "America/Los_Angeles", utc(2021, 11, 7, 1, 30), utc(2021, 11, 7, 8, 30)},
+		{"los angeles, after fall back", "America/Los_Angeles", utc(2021, 11, 7, 2, 0), utc(2021, 11, 7, 10, 0)},
+		{"berlin, spring forward", "Europe/Berlin", utc(2021, 3, 28, 2, 15), utc(2021, 3, 28, 1, 15)},
+		{"berlin, fall back", "Europe/Berlin", utc(2021, 10, 31, 2, 30), utc(2021, 10, 31, 0, 30)},
+		{"sydney, spring forward", "Australia/Sydney", utc(2021, 10, 3, 2, 15), utc(2021, 10, 2, 16, 15)},
+		{"sydney, fall back", "Australia/Sydney", utc(2021, 4, 4, 2, 30), utc(2021, 4, 3, 15, 30)},
+		{"tehran, midnight spring forward", "Asia/Tehran", utc(2021, 3, 22, 0, 30), utc(2021, 3, 21, 21, 0)},
+		{"tehran, fall back", "Asia/Tehran", utc(2021, 9, 21, 23, 30), utc(2021, 9, 21, 19, 0)},
+		{"utc", "UTC", utc(2021, 3, 14, 2, 30), utc(2021, 3, 14, 2, 30)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := test.wall
+			result := WallClock(w.Year(), w.Month(), w.Day(), w.Hour(), w.Minute(), mustLoad(t, test.zone))
+			require.True(t, test.expected.Equal(result), "expected %s, got %s", test.expected, result.UTC())
+		})
+	}
+}
+
+func TestGetCurrentWeekStart(t *testing.T) {
+	tests := []struct {
+		name     string
+		zone     string
+		at       time.Time
+		expected time.Time
+	}{
+		{"los angeles, week with spring forward", "America/Los_Angeles", utc(2021, 3, 17, 12, 0), utc(2021, 3, 15, 7, 0)},
+		{"los angeles, sunday of spring forward", "America/Los_Angeles", utc(2021, 3, 14, 20, 0), utc(2021, 3, 8, 8, 0)},
+		{"berlin, monday after fall back", "Europe/Berlin", utc(2021, 11, 1, 0, 0), utc(2021, 10, 31, 23, 0)},
+		{"sydney, sunday evening utc is monday", "Australia/Sydney", utc(2021, 10, 3, 14, 0), utc(2021, 10, 3, 13, 0)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := GetCurrentWeekStart(test.at.In(mustLoad(t, test.zone)))
+			require.True(t, test.expected.Equal(result), "expected %s, got %s", test.expected, result.UTC())
+		})
+	}
+}
+
+func TestWeeklyScheduleGetNearest(t *testing.T) {
+	sunday := []time.Weekday{time.Sunday}
+
+	tests := []struct {
+		name   string
+		zone   string
+		days   []time.Weekday
+		hours  uint8
+		mins   uint8
+		at     time.Time
+		left   time.Time
+		right  time.Time
+	}{
+		{
+			name: "los angeles, morning run on the spring forward day",
+			zone: "America/Los_Angeles", days: sunday, hours: 6,
+			at:   utc(2021, 3, 13, 20, 0),
+			left: utc(2021, 3, 7, 14, 0), right: utc(2021, 3, 14, 13, 0),
+		},
+		{
+			name: "los angeles, skipped hour runs an hour later",
+			zone: "America/Los_Angeles", days: sunday, hours: 2, mins: 30,
+			at:   utc(2021, 3, 14, 10, 0),
+			left: utc(2021, 3, 7, 10, 30), right: utc(2021, 3, 14, 10, 30),
+		},
+		{
+			name: "los angeles, repeated hour runs once",
+			zone: "America/Los_Angeles", days: sunday, hours: 1, mins: 30,
+			at:   utc(2021, 11, 7, 8, 31),
+			left: utc(2021, 11, 7, 8, 30), right: utc(2021, 11, 14, 9, 30),
+		},
+		{
+			name: "los angeles, daily across the fall back",
+			zone: "America/Los_Angeles",
+			days: []time.Weekday{time.Saturday, time.Sunday, time.Monday}, hours: 6,
+			at:   utc(2021, 11, 6, 14, 0),
+			left: utc(2021, 11, 6, 13, 0), right: utc(2021, 11, 7, 14, 0),
+		},
+		{
+			name: "berlin, monday after spring forward",
+			zone: "Europe/Berlin", days: []time.Weekday{time.Monday}, hours: 6,
+			at:   utc(2021, 3, 28, 12, 0),
+			left: utc(2021, 3, 22, 5, 0), right: utc(2021, 3, 29, 4, 0),
+		},
+		{
+			name: "berlin, repeated hour",
+			zone: "Europe/Berlin", days: sunday, hours: 2, mins: 30,
+			at:   utc(2021, 10, 31, 0, 0),
+			left: utc(2021, 10, 24, 0, 30), right: utc(2021, 10, 31, 0, 30),
+		},
+		{
+			name: "sydney, skipped hour",
+			zone: "Australia/Sydney", days: sunday, hours: 2, mins: 15,
+			at:   utc(2021, 10, 2, 12, 0),
+			left: utc(2021, 9, 25, 16, 15), right: utc(2021, 10, 2, 16, 15),
+		},
+		{
+			name: "sydney, repeated hour",
+			zone: "Australia/Sydney", days: sunday, hours: 2, mins: 30,
+			at:   utc(2021, 4, 3, 15, 45),
+			left: utc(2021, 4, 3, 15, 30), right: utc(2021, 4, 10, 16, 30),
+		},
+		{
+			name: "tehran, skipped midnight",
+			zone: "Asia/Tehran", days: []time.Weekday{time.Monday}, hours: 0, mins: 30,
+			at:   utc(2021, 3, 21, 12, 0),
+			left: utc(2021, 3, 14, 21, 0), right: utc(2021, 3, 21, 21, 0),
+		},
+		{
+			name: "new week on monday",
+			zone: "UTC", days: sunday, hours: 23,
+			at:   utc(2021, 5, 3, 0, 0),
+			left: utc(2021, 5, 2, 23, 0), right: utc(2021, 5, 9, 23, 0),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var w WeeklySchedule
+
+			require.NoError(t, w.AddSpec(Spec{
+				DaysOfWeek: test.days,
+				Hours:      test.hours,
+				Minutes:    test.mins,
+				AtTimeZone: test.zone,
+			}))
+
+			lt, rt := w.GetNearest(test.at)
+			require.True(t, test.left.Equal(lt.Time), "left %s", lt.Time.UTC())
+			require.True(t, test.right.Equal(rt.Time), "right %s", rt.Time.UTC())
+		})
+	}
+}
+
+func TestWeeklyScheduleAddSpec(t *testing.T) {
+	var w WeeklySchedule
+
+	require.Error(t, w.AddSpec(Spec{DaysOfWeek: []time.Weekday{time.Monday}, AtTimeZone: "Nowhere/Unknown"}))
+	require.Error(t, w.AddSpec(Spec{AtTimeZone: "UTC"}))
+
+	// Different zones can be mixed in one schedule
+	require.NoError(t, w.AddSpec(Spec{DaysOfWeek: []time.Weekday{time.Monday}, Hours: 6, AtTimeZone: "UTC"}))
+	require.NoError(t, w.AddSpec(Spec{DaysOfWeek: []time.Weekday{time.Monday}, Hours: 6, AtTimeZone: "Europe/Berlin"}))
+	require.Equal(t, 2, w.Len())
+
+	lt, rt := w.GetNearest(utc(2021, 5, 3, 5, 0))
+	require.True(t, utc(2021, 5, 3, 4, 0).Equal(lt.Time))
+	require.True(t, utc(2021, 5, 3, 6, 0).Equal(rt.Time))
+}
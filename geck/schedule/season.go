@@ -0,0 +1,141 @@
+package schedule
+
+import (
+	"fmt"
+	"time"
+)
+
+// How many seasons to look through for a matching slot, a short
+//  season may have no slots of a weekly schedule for a few years
+const seasonSearchLimit = 10
+
+// SeasonEntry limits the slots of an entry to the active season of the year
+type SeasonEntry struct {
+	entry Entry
+	loc   *time.Location
+
+	startMonth time.Month
+	startDay   int
+	endMonth   time.Month
+	endDay     int
+}
+
+func parseMonthDay(s string) (time.Month, int, error) {
+	t, err := time.Parse("01-02", s)
+
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid season date, expected 01-02: %s", s)
+	}
+
+	return t.Month(), t.Day(), nil
+}
+
+// NewSeasonEntry wraps the entry with the season of the spec, a missing
+//  start or end means the beginning or the end of the year
+func NewSeasonEntry(entry Entry, spec Spec) (*SeasonEntry, error) {
+	loc, err := time.LoadLocation(spec.AtTimeZone)
+
+	if err != nil {
+		return nil, err
+	}
+
+	result := &SeasonEntry{
+		entry:      entry,
+		loc:        loc,
+		startMonth: time.January,
+		startDay:   1,
+		endMonth:   time.December,
+		endDay:     31,
+	}
+
+	if spec.SeasonStart != "" {
+		if result.startMonth, result.startDay, err = parseMonthDay(spec.SeasonStart); err != nil {
+			return nil, err
+		}
+	}
+
+	if spec.SeasonEnd != "" {
+		if result.endMonth, result.endDay, err = parseMonthDay(spec.SeasonEnd); err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+func monthDay(m time.Month, d int) int {
+	return int(m) * 100 + d
+}
+
+func (e *SeasonEntry) contains(t time.Time) bool {
+	t = t.In(e.loc)
+	md := monthDay(t.Month(), t.Day())
+	start := monthDay(e.startMonth, e.startDay)
+	end := monthDay(e.endMonth, e.endDay)
+
+	if start <= end {
+		return md >= start && md <= end
+	}
+
+	return md >= start || md <= end
+}
+
+// nextStart the beginning of the first season day after t
+func (e *SeasonEntry) nextStart(t time.Time) time.Time {
+	t = t.In(e.loc)
+	result := WallClock(t.Year(), e.startMonth, e.startDay, 0, 0, e.loc)
+
+	if !result.After(t) {
+		result = WallClock(t.Year() + 1, e.startMonth, e.startDay, 0, 0, e.loc)
+	}
+
+	return result
+}
+
+// prevEnd the end of the last season day before t
+func (e *SeasonEntry) prevEnd(t time.Time) time.Time {
+	t = t.In(e.loc)
+	result := WallClock(t.Year(), e.endMonth, e.endDay + 1, 0, 0, e.loc)
+
+	if result.After(t) {
+		result = WallClock(t.Year() - 1, e.endMonth, e.endDay + 1, 0, 0, e.loc)
+	}
+
+	return result.Add(-time.Nanosecond)
+}
+
+func (e *SeasonEntry) Len() int {
+	return e.entry.Len()
+}
+
+// GetNearest skips the slots outside of the season, jumping
+//  to the season boundary instead of walking through the slots
+func (e *SeasonEntry) GetNearest(t time.Time) (TimeSlot, TimeSlot) {
+	var ltt, rtt TimeSlot
+
+	for at, i := t, 0; i < seasonSearchLimit; i++ {
+		lt, _ := e.entry.GetNearest(at)
+
+		if lt.Time.IsZero() || e.contains(lt.Time) {
+			ltt = lt
+			break
+		}
+
+		at = e.prevEnd(lt.Time)
+	}
+
+	for at, i := t, 0; i < seasonSearchLimit; i++ {
+		_, rt := e.entry.GetNearest(at)
+
+		if rt.Time.IsZero() || e.contains(rt.Time) {
+			rtt = rt
+			break
+		}
+
+		at = e.nextStart(rt.Time).Add(-time.Nanosecond)
+	}
+
+	return ltt, rtt
+}
+
+var _ Entry = &SeasonEntry{}
@@ -0,0 +1,75 @@
+package schedule
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestSeasonEntryGetNearest(t *testing.T) {
+	daily := []time.Weekday{
+		time.Monday, time.Tuesday, time.Wednesday, time.Thursday,
+		time.Friday, time.Saturday, time.Sunday,
+	}
+
+	tests := []struct {
+		name  string
+		spec  Spec
+		at    time.Time
+		left  time.Time
+		right time.Time
+	}{
+		{
+			name:  "in season",
+			spec:  Spec{DaysOfWeek: daily, Hours: 6, SeasonStart: "04-01", SeasonEnd: "10-31"},
+			at:    utc(2021, 6, 10, 12, 0),
+			left:  utc(2021, 6, 10, 6, 0),
+			right: utc(2021, 6, 11, 6, 0),
+		},
+		{
+			name:  "winter waits for the next season",
+			spec:  Spec{DaysOfWeek: daily, Hours: 6, SeasonStart: "04-01", SeasonEnd: "10-31"},
+			at:    utc(2021, 12, 10, 12, 0),
+			left:  utc(2021, 10, 31, 6, 0),
+			right: utc(2022, 4, 1, 6, 0),
+		},
+		{
+			name:  "season across the new year",
+			spec:  Spec{DaysOfWeek: daily, Hours: 6, SeasonStart: "11-01", SeasonEnd: "02-28"},
+			at:    utc(2021, 6, 10, 12, 0),
+			left:  utc(2021, 2, 28, 6, 0),
+			right: utc(2021, 11, 1, 6, 0),
+		},
+		{
+			name:  "odd days in season",
+			spec:  Spec{Type: SpecOdd, Hours: 6, SeasonStart: "04-01"},
+			at:    utc(2021, 3, 30, 12, 0),
+			left:  utc(2020, 12, 31, 6, 0),
+			right: utc(2021, 4, 1, 6, 0),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var s Schedule
+
+			spec := test.spec
+			spec.AtTimeZone = "UTC"
+			require.NoError(t, s.AddSpec(spec))
+
+			lt, rt := s.GetNearest(test.at)
+			require.True(t, test.left.Equal(lt.Time), "left %s", lt.Time)
+			require.True(t, test.right.Equal(rt.Time), "right %s", rt.Time)
+		})
+	}
+}
+
+func TestSeasonEntryInvalidDate(t *testing.T) {
+	var s Schedule
+
+	require.Error(t, s.AddSpec(Spec{
+		DaysOfWeek:  []time.Weekday{time.Monday},
+		AtTimeZone:  "UTC",
+		SeasonStart: "April",
+	}))
+}
@@ -0,0 +1,59 @@
+package schedule
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	SunriseAnchor = "sunrise"
+	SunsetAnchor  = "sunset"
+)
+
+// Julian date of the Unix epoch and of the J2000 epoch
+const (
+	julianUnixEpoch = 2440587.5
+	julianJ2000     = 2451545.0
+)
+
+func sinDeg(x float64) float64 {
+	return math.Sin(x * math.Pi / 180)
+}
+
+func cosDeg(x float64) float64 {
+	return math.Cos(x * math.Pi / 180)
+}
+
+func fromJulian(jd float64) time.Time {
+	return time.Unix(0, int64((jd - julianUnixEpoch) * 86400 * float64(time.Second))).UTC()
+}
+
+// SunTimes computes sunrise and sunset (sunrise equation, within a couple
+// of minutes) for the calendar date of day at the latitude and longitude
+// (east positive) in degrees. ok is false for the polar day or night
+func SunTimes(day time.Time, latitude, longitude float64) (sunrise, sunset time.Time, ok bool) {
+	noon := time.Date(day.Year(), day.Month(), day.Day(), 12, 0, 0, 0, time.UTC)
+	n := math.Round(float64(noon.Unix()) / 86400 + julianUnixEpoch - julianJ2000)
+
+	// Mean solar noon, solar mean anomaly and the equation of the center
+	j := n - longitude / 360
+	m := math.Mod(357.5291 + 0.98560028 * j, 360)
+	c := 1.9148 * sinDeg(m) + 0.0200 * sinDeg(2 * m) + 0.0003 * sinDeg(3 * m)
+
+	// Ecliptic longitude, solar transit and the declination of the sun
+	lambda := math.Mod(m + c + 180 + 102.9372, 360)
+	transit := julianJ2000 + j + 0.0053 * sinDeg(m) - 0.0069 * sinDeg(2 * lambda)
+	sinDecl := sinDeg(lambda) * sinDeg(23.4397)
+	cosDecl := math.Sqrt(1 - sinDecl * sinDecl)
+
+	// Hour angle, corrected for the refraction and the solar disc
+	cosOmega := (sinDeg(-0.833) - sinDeg(latitude) * sinDecl) / (cosDeg(latitude) * cosDecl)
+
+	if cosOmega < -1 || cosOmega > 1 {
+		return time.Time{}, time.Time{}, false
+	}
+
+	omega := math.Acos(cosOmega) * 180 / math.Pi
+
+	return fromJulian(transit - omega / 360), fromJulian(transit + omega / 360), true
+}
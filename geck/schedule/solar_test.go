@@ -0,0 +1,47 @@
+package schedule
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func requireClose(t *testing.T, expected, actual time.Time) {
+	diff := actual.Sub(expected)
+	require.True(t, diff < 3 * time.Minute && diff > -3 * time.Minute,
+		"expected %s, got %s", expected, actual)
+}
+
+func TestSunTimes(t *testing.T) {
+	pdt := time.FixedZone("PDT", -7 * 3600)
+
+	// San Francisco, summer solstice
+	sunrise, sunset, ok := SunTimes(time.Date(2021, 6, 21, 0, 0, 0, 0, time.UTC), 37.7749, -122.4194)
+	require.True(t, ok)
+	requireClose(t, time.Date(2021, 6, 21, 5, 48, 0, 0, pdt), sunrise)
+	requireClose(t, time.Date(2021, 6, 21, 20, 35, 0, 0, pdt), sunset)
+
+	// Polar night in Tromsø
+	_, _, ok = SunTimes(time.Date(2021, 12, 21, 0, 0, 0, 0, time.UTC), 69.6492, 18.9553)
+	require.False(t, ok)
+}
+
+func TestSunAnchoredEntry(t *testing.T) {
+	var s Schedule
+
+	require.NoError(t, s.AddSpec(Spec{
+		DaysOfWeek: []time.Weekday{time.Monday},
+		AtTimeZone: "America/Los_Angeles",
+		Anchor:     SunsetAnchor,
+		Offset:     30 * time.Minute,
+		Latitude:   37.7749,
+		Longitude:  -122.4194,
+	}))
+
+	// Monday the 21st of June 2021, after the sunset
+	lt, rt := s.GetNearest(time.Date(2021, 6, 22, 6, 0, 0, 0, time.UTC))
+
+	loc, _ := time.LoadLocation("America/Los_Angeles")
+	requireClose(t, time.Date(2021, 6, 21, 21, 5, 0, 0, loc), lt.Time)
+	requireClose(t, time.Date(2021, 6, 28, 21, 5, 0, 0, loc), rt.Time)
+}
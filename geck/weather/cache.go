@@ -0,0 +1,160 @@
+package weather
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+/// ErrPending the report of the day is still being fetched
+var ErrPending = errors.New("weather report is being fetched")
+
+/// ReportSource is implemented by the providers, which return the readings
+type ReportSource interface {
+	/// Readings observed or forecast within [start, end)
+	Report(start time.Time, end time.Time) (*Report, error)
+}
+
+const (
+	// How long a fetched day is used, before it's fetched again
+	cacheMaxAge = 30 * time.Minute
+
+	// How long to wait before fetching a day again after a failure
+	cacheRetry = time.Minute
+
+	// Days not fetched for that long are dropped
+	cacheKeep = 24 * time.Hour
+)
+
+type cachedDay struct {
+	report  *Report
+	err     error
+	fetched time.Time
+	pending bool
+}
+
+/// CachedProvider answers from the reports of whole days, which are
+///  fetched in the background, so the callers never wait for the source.
+///  A day is unknown until its first fetch, later it's refreshed while
+///  the last report is still used
+type CachedProvider struct {
+	source ReportSource
+
+	mu   sync.Mutex
+	days map[time.Time]*cachedDay
+}
+
+func NewCachedProvider(source ReportSource) *CachedProvider {
+	return &CachedProvider{
+		source: source,
+		days:   make(map[time.Time]*cachedDay),
+	}
+}
+
+/// day the report of the day, the caller holds the mutex
+func (c *CachedProvider) day(day time.Time) (*Report, error) {
+	entry, ok := c.days[day]
+
+	if !ok {
+		entry = &cachedDay{}
+		c.days[day] = entry
+	}
+
+	age := time.Since(entry.fetched)
+	stale := entry.fetched.IsZero() || age > cacheMaxAge || (entry.err != nil && age > cacheRetry)
+
+	if stale && !entry.pending {
+		entry.pending = true
+		go c.fetch(day)
+	}
+
+	if entry.fetched.IsZero() {
+		return nil, ErrPending
+	}
+
+	return entry.report, entry.err
+}
+
+func (c *CachedProvider) fetch(day time.Time) {
+	end := day.AddDate(0, 0, 1)
+	report, err := c.source.Report(day, end)
+	result := &Report{}
+
+	if err == nil {
+		// Only the readings of the day, the days are put together later
+		for _, reading := range report.Readings {
+			if !reading.Time.Before(day) && reading.Time.Before(end) {
+				result.Readings = append(result.Readings, reading)
+			}
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+
+	for d, entry := range c.days {
+		if !entry.pending && now.Sub(entry.fetched) > cacheKeep {
+			delete(c.days, d)
+		}
+	}
+
+	c.days[day] = &cachedDay{
+		report:  result,
+		err:     err,
+		fetched: now,
+	}
+}
+
+/// report the readings of the days within [start, end). All the days
+///  are requested, even if some of them are not known yet
+func (c *CachedProvider) report(start time.Time, end time.Time) (*Report, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	result := &Report{}
+	var failure error
+
+	day := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, start.Location())
+
+	for ; day.Before(end); day = day.AddDate(0, 0, 1) {
+		report, err := c.day(day)
+
+		if err != nil {
+			if failure == nil {
+				failure = err
+			}
+
+			continue
+		}
+
+		result.Readings = append(result.Readings, report.Readings...)
+	}
+
+	return result, failure
+}
+
+func (c *CachedProvider) Precipitation(start time.Time, end time.Time) (float64, error) {
+	report, err := c.report(start, end)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return report.precipitation(start, end), nil
+}
+
+func (c *CachedProvider) Temperature(start time.Time, end time.Time) (float64, float64, error) {
+	report, err := c.report(start, end)
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return report.temperature(start, end)
+}
+
+var _ TemperatureProvider = &CachedProvider{}
+var _ ReportSource = &FileProvider{}
+var _ ReportSource = &HttpProvider{}
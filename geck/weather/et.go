@@ -0,0 +1,67 @@
+package weather
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+/// TemperatureProvider is implemented by the providers, which report air temperature
+type TemperatureProvider interface {
+	/// Minimum and maximum temperature in C observed within [start, end)
+	Temperature(start time.Time, end time.Time) (float64, float64, error)
+}
+
+const solarConstant = 0.0820 // MJ m-2 min-1
+
+/// ExtraterrestrialRadiation daily radiation at the top of the atmosphere
+///  in MJ m-2 day-1 for the latitude in degrees (FAO-56, eq. 21)
+func ExtraterrestrialRadiation(latitude float64, day time.Time) float64 {
+	phi := latitude * math.Pi / 180
+	j := float64(day.YearDay())
+
+	dr := 1 + 0.033 * math.Cos(2 * math.Pi / 365 * j)
+	delta := 0.409 * math.Sin(2 * math.Pi / 365 * j - 1.39)
+	ws := math.Acos(math.Max(-1, math.Min(1, -math.Tan(phi) * math.Tan(delta))))
+
+	return 24 * 60 / math.Pi * solarConstant * dr *
+		(ws * math.Sin(phi) * math.Sin(delta) + math.Cos(phi) * math.Cos(delta) * math.Sin(ws))
+}
+
+/// HargreavesET0 reference evapotranspiration in mm per day
+///  from the daily temperature range (FAO-56, eq. 52)
+func HargreavesET0(tMin, tMax float64, latitude float64, day time.Time) float64 {
+	if tMax < tMin {
+		tMin, tMax = tMax, tMin
+	}
+
+	// Radiation converted to the equivalent evaporation in mm
+	ra := 0.408 * ExtraterrestrialRadiation(latitude, day)
+	et0 := 0.0023 * ((tMin + tMax) / 2 + 17.8) * math.Sqrt(tMax - tMin) * ra
+
+	return math.Max(0, et0)
+}
+
+/// DailyWater reference evapotranspiration and precipitation in mm for the day
+func DailyWater(provider Provider, latitude float64, day time.Time) (float64, float64, error) {
+	tp, ok := provider.(TemperatureProvider)
+
+	if !ok {
+		return 0, 0, fmt.Errorf("weather provider has no temperature data")
+	}
+
+	end := day.AddDate(0, 0, 1)
+	tMin, tMax, err := tp.Temperature(day, end)
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	rain, err := provider.Precipitation(day, end)
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return HargreavesET0(tMin, tMax, latitude, day), rain, nil
+}
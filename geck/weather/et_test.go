@@ -0,0 +1,47 @@
+package weather
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestExtraterrestrialRadiation(t *testing.T) {
+	// FAO-56 example 8: 20 S on the 3rd of September
+	day := time.Date(2021, 9, 3, 0, 0, 0, 0, time.UTC)
+	require.InDelta(t, 32.2, ExtraterrestrialRadiation(-20, day), 0.1)
+}
+
+func TestDailyWater(t *testing.T) {
+	day := time.Date(2021, 7, 15, 0, 0, 0, 0, time.UTC)
+	t15, t32 := 15.0, 32.0
+
+	provider := &reportProvider{Report{
+		Readings: []Reading{
+			{ Time: day.Add(5 * time.Hour), Temperature: &t15 },
+			{ Time: day.Add(15 * time.Hour), Temperature: &t32, Precipitation: 1.5 },
+		},
+	}}
+
+	et0, rain, err := DailyWater(provider, 37.5, day)
+	require.NoError(t, err)
+	require.Equal(t, 1.5, rain)
+
+	// Mid summer at mid latitudes, a hot dry day is 6-7 mm
+	require.InDelta(t, 6.5, et0, 0.5)
+
+	_, _, err = DailyWater(provider, 37.5, day.AddDate(0, 0, 1))
+	require.Error(t, err)
+}
+
+type reportProvider struct {
+	report Report
+}
+
+func (rp *reportProvider) Precipitation(start time.Time, end time.Time) (float64, error) {
+	return rp.report.precipitation(start, end), nil
+}
+
+func (rp *reportProvider) Temperature(start time.Time, end time.Time) (float64, float64, error) {
+	return rp.report.temperature(start, end)
+}
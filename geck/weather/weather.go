@@ -0,0 +1,232 @@
+package weather
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+/// Reading precipitation observed or forecast for a single time point
+type Reading struct {
+	Time          time.Time `json:"time"`
+	Precipitation float64   `json:"precipitation_mm"`
+	Temperature   *float64  `json:"temperature_c,omitempty"`
+}
+
+/// Report a public representation of weather data, shared by the providers
+type Report struct {
+	Readings []Reading `json:"readings"`
+}
+
+/// Provider is an interface for a source of weather data
+type Provider interface {
+	/// Total precipitation in mm observed or forecast within [start, end)
+	Precipitation(start time.Time, end time.Time) (float64, error)
+}
+
+func (r *Report) precipitation(start time.Time, end time.Time) float64 {
+	total := 0.0
+
+	for _, reading := range r.Readings {
+		if !reading.Time.Before(start) && reading.Time.Before(end) {
+			total += reading.Precipitation
+		}
+	}
+
+	return total
+}
+
+func (r *Report) temperature(start time.Time, end time.Time) (float64, float64, error) {
+	var tMin, tMax float64
+	found := false
+
+	for _, reading := range r.Readings {
+		if reading.Temperature == nil || reading.Time.Before(start) || !reading.Time.Before(end) {
+			continue
+		}
+
+		if !found || *reading.Temperature < tMin {
+			tMin = *reading.Temperature
+		}
+
+		if !found || *reading.Temperature > tMax {
+			tMax = *reading.Temperature
+		}
+
+		found = true
+	}
+
+	if !found {
+		return 0, 0, fmt.Errorf("no temperature readings from %s to %s",
+			start.Format(time.RFC3339), end.Format(time.RFC3339))
+	}
+
+	return tMin, tMax, nil
+}
+
+/// FileProvider reads the weather report from a local json file,
+///  which can be updated by an external script
+type FileProvider struct {
+	FileName string
+}
+
+func NewFileProvider(fileName string) *FileProvider {
+	return &FileProvider{FileName: fileName}
+}
+
+/// Report all the readings of the file, the window is not used
+func (fp *FileProvider) Report(start time.Time, end time.Time) (*Report, error) {
+	return fp.report()
+}
+
+func (fp *FileProvider) report() (*Report, error) {
+	data, err := ioutil.ReadFile(fp.FileName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var report Report
+
+	if err = json.Unmarshal(data, &report); err != nil {
+		return nil, err
+	}
+
+	return &report, nil
+}
+
+func (fp *FileProvider) Precipitation(start time.Time, end time.Time) (float64, error) {
+	report, err := fp.report()
+
+	if err != nil {
+		return 0, err
+	}
+
+	return report.precipitation(start, end), nil
+}
+
+func (fp *FileProvider) Temperature(start time.Time, end time.Time) (float64, float64, error) {
+	report, err := fp.report()
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return report.temperature(start, end)
+}
+
+/// HttpProvider requests the weather report from a (local) http service,
+///  passing the window as start and end query parameters in RFC3339
+type HttpProvider struct {
+	Url    string
+	client http.Client
+}
+
+func NewHttpProvider(url string) *HttpProvider {
+	return &HttpProvider{
+		Url:    url,
+		client: http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+/// Report the readings of the window
+func (hp *HttpProvider) Report(start time.Time, end time.Time) (*Report, error) {
+	reqUrl, err := url.Parse(hp.Url)
+
+	if err != nil {
+		return nil, err
+	}
+
+	query := reqUrl.Query()
+	query.Set("start", start.Format(time.RFC3339))
+	query.Set("end", end.Format(time.RFC3339))
+	reqUrl.RawQuery = query.Encode()
+
+	resp, err := hp.client.Get(reqUrl.String())
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("weather service error : %s", resp.Status)
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var report Report
+
+	if err = json.Unmarshal(data, &report); err != nil {
+		return nil, err
+	}
+
+	return &report, nil
+}
+
+func (hp *HttpProvider) Precipitation(start time.Time, end time.Time) (float64, error) {
+	report, err := hp.Report(start, end)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return report.precipitation(start, end), nil
+}
+
+func (hp *HttpProvider) Temperature(start time.Time, end time.Time) (float64, float64, error) {
+	report, err := hp.Report(start, end)
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return report.temperature(start, end)
+}
+
+/// RainSkipPolicy skips scheduled runs if the precipitation observed
+///  before or forecast after the run exceeds the threshold
+type RainSkipPolicy struct {
+	Provider  Provider
+	Observed  time.Duration
+	Forecast  time.Duration
+	Threshold float64
+}
+
+/// SkipReason returns a non empty reason if the run at t should be skipped.
+///  If the weather is unknown, the run is never skipped
+func (p *RainSkipPolicy) SkipReason(t time.Time) string {
+	if p == nil || p.Provider == nil {
+		return ""
+	}
+
+	total, err := p.Provider.Precipitation(t.Add(-p.Observed), t.Add(p.Forecast))
+
+	if err == ErrPending {
+		return ""
+	}
+
+	if err != nil {
+		log.Printf("Weather provider error : %s", err.Error())
+		return ""
+	}
+
+	if total < p.Threshold {
+		return ""
+	}
+
+	return fmt.Sprintf("rain: %.1f mm within %s before and %s after",
+		total, p.Observed, p.Forecast)
+}
+
+var _ TemperatureProvider = &FileProvider{}
+var _ TemperatureProvider = &HttpProvider{}
@@ -0,0 +1,128 @@
+package weather
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+var base = time.Date(2021, 5, 23, 6, 0, 0, 0, time.UTC)
+
+var testReport = Report{
+	Readings: []Reading{
+		{ Time: base.Add(-30 * time.Hour), Precipitation: 20 },
+		{ Time: base.Add(-12 * time.Hour), Precipitation: 4 },
+		{ Time: base.Add(-1 * time.Hour), Precipitation: 2.5 },
+		{ Time: base.Add(6 * time.Hour), Precipitation: 1 },
+	},
+}
+
+func TestHttpProvider(t *testing.T) {
+	var query string
+
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
+		query = req.URL.RawQuery
+		data, _ := json.Marshal(testReport)
+		_, _ = writer.Write(data)
+	}))
+	defer server.Close()
+
+	provider := NewHttpProvider(server.URL + "/rain")
+
+	total, err := provider.Precipitation(base.Add(-24 * time.Hour), base.Add(12 * time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, 7.5, total)
+	require.Contains(t, query, "start=2021-05-22T06%3A00%3A00Z")
+
+	policy := &RainSkipPolicy{
+		Provider:  provider,
+		Observed:  24 * time.Hour,
+		Forecast:  12 * time.Hour,
+		Threshold: 5,
+	}
+
+	require.NotEmpty(t, policy.SkipReason(base))
+
+	policy.Threshold = 10
+	require.Empty(t, policy.SkipReason(base))
+
+	server.Close()
+	policy.Threshold = 0
+	require.Empty(t, policy.SkipReason(base), "unknown weather never skips")
+}
+
+func TestFileProvider(t *testing.T) {
+	f, err := ioutil.TempFile("", "weather")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	data, _ := json.Marshal(testReport)
+	_, _ = f.Write(data)
+	f.Close()
+
+	total, err := NewFileProvider(f.Name()).Precipitation(base.Add(-2 * time.Hour), base)
+	require.NoError(t, err)
+	require.Equal(t, 2.5, total)
+}
+
+// gatedSource a slow weather service, which answers once it's released
+type gatedSource struct {
+	mu      sync.Mutex
+	release chan struct{}
+	days    []time.Time
+}
+
+func (s *gatedSource) Report(start time.Time, end time.Time) (*Report, error) {
+	<-s.release
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.days = append(s.days, start)
+	return &testReport, nil
+}
+
+func (s *gatedSource) fetched() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.days)
+}
+
+func TestCachedProvider(t *testing.T) {
+	source := &gatedSource{release: make(chan struct{})}
+	provider := NewCachedProvider(source)
+
+	// The callers do not wait for the source
+	_, err := provider.Precipitation(base.Add(-24 * time.Hour), base.Add(12 * time.Hour))
+	require.Equal(t, ErrPending, err)
+
+	policy := &RainSkipPolicy{Provider: provider, Observed: 24 * time.Hour, Forecast: 12 * time.Hour}
+	require.Empty(t, policy.SkipReason(base), "unknown weather never skips")
+
+	close(source.release)
+
+	require.Eventually(t, func() bool {
+		total, err := provider.Precipitation(base.Add(-24 * time.Hour), base.Add(12 * time.Hour))
+		return err == nil && total == 7.5
+	}, time.Second, time.Millisecond)
+
+	// Each day is fetched once, and the readings are not counted twice
+	require.Equal(t, 2, source.fetched())
+
+	total, err := provider.Precipitation(base.Add(-48 * time.Hour), base.Add(-24 * time.Hour))
+	require.Equal(t, ErrPending, err)
+
+	require.Eventually(t, func() bool {
+		total, err = provider.Precipitation(base.Add(-48 * time.Hour), base.Add(-24 * time.Hour))
+		return err == nil
+	}, time.Second, time.Millisecond)
+	require.Equal(t, 20.0, total)
+	require.Equal(t, 3, source.fetched())
+}